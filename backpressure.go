@@ -0,0 +1,400 @@
+package zanarkand
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BackpressurePolicy decides what happens to a Frame or message when its consumer has
+// fallen behind and the buffer in front of it is full.
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock waits for room in the buffer. A slow consumer stalls reassembly,
+	// and eventually the capture, but nothing is lost after capture.
+	BackpressureBlock BackpressurePolicy = iota
+
+	// BackpressureDropNewest discards the value that didn't fit.
+	BackpressureDropNewest
+
+	// BackpressureDropOldest discards the oldest buffered value to make room, so the
+	// buffer always holds the latest values. Unbuffered channels drop the newest instead.
+	BackpressureDropOldest
+
+	// BackpressureSpill writes values that don't fit to a temporary file, delivering them
+	// in order as the consumer catches up. Values are dropped once the file reaches its
+	// size limit, see WithSpillQueue. Spilled values still queued when the Sniffer stops
+	// or the subscriber closes are discarded.
+	BackpressureSpill
+)
+
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case BackpressureBlock:
+		return "block"
+	case BackpressureDropNewest:
+		return "drop newest"
+	case BackpressureDropOldest:
+		return "drop oldest"
+	case BackpressureSpill:
+		return "spill"
+	default:
+		return "unknown"
+	}
+}
+
+// defaultSpillLimit bounds the size of a spill queue file
+const defaultSpillLimit = 256 << 20
+
+// WithBackpressure sets what happens to reassembled Frames when the buffer set by
+// WithDataBufferSize is full. Every dropped Frame is counted in Stats and reported as an
+// ErrDropped. The default is BackpressureBlock.
+func WithBackpressure(policy BackpressurePolicy) Option {
+	return func(c *snifferConfig) { c.backpressure = policy }
+}
+
+// WithSpillQueue sets the directory and size limit in bytes of the files used by
+// BackpressureSpill, for the Sniffer and the subscribers reading from it. The defaults
+// are os.TempDir() and 256 MiB.
+func WithSpillQueue(dir string, limit int64) Option {
+	return func(c *snifferConfig) { c.spillDir, c.spillLimit = dir, limit }
+}
+
+// errSpillFull is wrapped by ErrDropped when a spill queue reaches its size limit.
+var errSpillFull = errors.New("spill queue full")
+
+// spillCodec converts the values of a queue to and from the bytes spilled to disk.
+type spillCodec[T any] struct {
+	encode func(T) ([]byte, error)
+	decode func([]byte) (T, error)
+}
+
+// queue delivers values to a channel, applying a BackpressurePolicy when it's full.
+type queue[T any] struct {
+	name   string
+	ch     chan T
+	policy BackpressurePolicy
+	codec  spillCodec[T]
+
+	// Set from the Sniffer by attach
+	report     atomic.Pointer[func(error)] // read by senders without the lock
+	spillDir   string
+	spillLimit int64
+
+	mu    sync.Mutex // serialises spilling senders, and guards the spill settings
+	spill *spillFile
+
+	done     chan struct{} // closed by shutdown
+	doneOnce sync.Once
+
+	dropped atomic.Uint64
+	spilled atomic.Uint64
+	blocked atomic.Int64 // nanoseconds
+}
+
+func newQueue[T any](name string, ch chan T, policy BackpressurePolicy, codec spillCodec[T]) *queue[T] {
+	return &queue[T]{name: name, ch: ch, policy: policy, codec: codec, spillLimit: defaultSpillLimit, done: make(chan struct{})}
+}
+
+// attach reports drops and spills to files as configured for s.
+func (q *queue[T]) attach(s *Sniffer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	report := s.reportError
+	q.report.Store(&report)
+	q.spillDir, q.spillLimit = s.spillDir, s.spillLimit
+}
+
+// errQueueStopped is wrapped by ErrDropped when a blocked send is given up on because
+// the Sniffer stopped.
+var errQueueStopped = errors.New("sniffer stopped")
+
+// send delivers v, or drops or spills it if the channel is full. Once the queue is shut
+// down, v is discarded.
+func (q *queue[T]) send(v T) {
+	q.sendUntil(v, nil)
+}
+
+// sendUntil is send, but a blocked send gives up and drops v once stop is closed.
+func (q *queue[T]) sendUntil(v T, stop <-chan struct{}) {
+	select {
+	case <-q.done:
+		return
+	case q.ch <- v:
+		return
+	default:
+	}
+
+	switch q.policy {
+	case BackpressureDropNewest:
+		q.drop(nil)
+
+	case BackpressureDropOldest:
+		q.sendDropOldest(v)
+
+	case BackpressureSpill:
+		q.sendSpill(v)
+
+	default:
+		start := time.Now()
+		select {
+		case q.ch <- v:
+		case <-q.done:
+		case <-stop:
+			q.drop(errQueueStopped)
+		}
+		q.blocked.Add(int64(time.Since(start)))
+	}
+}
+
+func (q *queue[T]) sendDropOldest(v T) {
+	if cap(q.ch) == 0 {
+		q.drop(nil)
+		return
+	}
+
+	// Other senders may fill the room first, so keep going until v fits
+	for {
+		select {
+		case q.ch <- v:
+			return
+		default:
+		}
+
+		select {
+		case <-q.ch:
+			q.drop(nil)
+		default:
+		}
+	}
+}
+
+func (q *queue[T]) sendSpill(v T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return
+	default:
+	}
+
+	// Once anything is spilled, everything after it is too, to keep the order
+	if q.spill == nil || q.spill.empty() {
+		select {
+		case q.ch <- v:
+			return
+		default:
+		}
+	}
+
+	data, err := q.codec.encode(v)
+	if err == nil && q.spill == nil {
+		q.spill, err = newSpillFile(q.spillDir, q.spillLimit)
+		if err == nil {
+			go q.pump(q.spill)
+		}
+	}
+	if err == nil {
+		err = q.spill.push(data)
+	}
+
+	if err != nil {
+		q.drop(err)
+		return
+	}
+
+	q.spilled.Add(1)
+}
+
+// pump moves spilled values to the channel until the spill file is closed.
+func (q *queue[T]) pump(s *spillFile) {
+	defer close(s.pumped)
+
+	for {
+		data, lost, err := s.peek()
+		if errors.Is(err, errSpillClosed) {
+			return
+		}
+
+		// The file is unreadable and has been emptied, so carry on with the next value
+		if err != nil {
+			for i := 0; i < lost; i++ {
+				q.drop(err)
+			}
+			continue
+		}
+
+		v, err := q.codec.decode(data)
+		if err != nil {
+			s.commit()
+			q.drop(err)
+			continue
+		}
+
+		select {
+		case q.ch <- v:
+			s.commit()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (q *queue[T]) drop(err error) {
+	q.dropped.Add(1)
+
+	if report := q.report.Load(); report != nil {
+		(*report)(ErrDropped{Queue: q.name, Policy: q.policy, Err: err})
+	}
+}
+
+// close discards anything spilled and removes the spill file.
+func (q *queue[T]) close() {
+	q.mu.Lock()
+	s := q.spill
+	q.spill = nil
+	q.mu.Unlock()
+
+	if s != nil {
+		s.close()
+	}
+}
+
+// shutdown discards anything spilled and unblocks senders, which discard their values from
+// then on, so the channel can be closed once they've returned.
+func (q *queue[T]) shutdown() {
+	q.doneOnce.Do(func() { close(q.done) })
+	q.close()
+}
+
+// spillFile is a FIFO of byte records in a temporary file. Records stay in the file until
+// they're committed, and the file is emptied whenever the last one is.
+type spillFile struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	f      *os.File
+	r, w   int64 // offsets of the next record to read and write
+	n      int   // records pushed but not committed
+	limit  int64
+	closed bool
+
+	done   chan struct{} // closed by close
+	pumped chan struct{} // closed when the pump exits
+}
+
+func newSpillFile(dir string, limit int64) (*spillFile, error) {
+	f, err := os.CreateTemp(dir, "zanarkand-spill-*")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spillFile{f: f, limit: limit, done: make(chan struct{}), pumped: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+
+	return s, nil
+}
+
+func (s *spillFile) empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n == 0
+}
+
+func (s *spillFile) push(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.limit > 0 && s.w+4+int64(len(data)) > s.limit {
+		return fmt.Errorf("%w: %d bytes", errSpillFull, s.w)
+	}
+
+	record := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+	if _, err := s.f.WriteAt(append(record, data...), s.w); err != nil {
+		return err
+	}
+
+	s.w += int64(len(record) + len(data))
+	s.n++
+	s.cond.Signal()
+
+	return nil
+}
+
+// errSpillClosed is returned by peek once the spill file is closed.
+var errSpillClosed = errors.New("spill file closed")
+
+// peek waits for the oldest record, returning errSpillClosed once the file is closed. If
+// the record can't be read, every record is discarded, and the number lost is returned
+// with the error, so values are spilled and delivered afresh rather than stuck behind it.
+func (s *spillFile) peek() ([]byte, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.n == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return nil, 0, errSpillClosed
+	}
+
+	var length [4]byte
+	if _, err := s.f.ReadAt(length[:], s.r); err != nil {
+		return nil, s.reset(), err
+	}
+
+	data := make([]byte, binary.LittleEndian.Uint32(length[:]))
+	if _, err := s.f.ReadAt(data, s.r+4); err != nil {
+		return nil, s.reset(), err
+	}
+
+	return data, 0, nil
+}
+
+// reset discards every record, returning how many there were. s.mu must be held.
+func (s *spillFile) reset() int {
+	n := s.n
+	s.r, s.w, s.n = 0, 0, 0
+	_ = s.f.Truncate(0)
+
+	return n
+}
+
+// commit removes the oldest record.
+func (s *spillFile) commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.n == 0 {
+		return
+	}
+
+	var length [4]byte
+	_, _ = s.f.ReadAt(length[:], s.r)
+	s.r += 4 + int64(binary.LittleEndian.Uint32(length[:]))
+	s.n--
+
+	if s.n == 0 {
+		s.reset()
+	}
+}
+
+// close stops the pump and removes the file.
+func (s *spillFile) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	close(s.done)
+	<-s.pumped
+
+	_ = s.f.Close()
+	_ = os.Remove(s.f.Name())
+}
@@ -0,0 +1,289 @@
+package zanarkand
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+var intCodec = spillCodec[int]{
+	encode: func(v int) ([]byte, error) { return []byte(strconv.Itoa(v)), nil },
+	decode: func(data []byte) (int, error) { return strconv.Atoi(string(data)) },
+}
+
+func TestQueuePolicies(t *testing.T) {
+	tests := []struct {
+		policy  BackpressurePolicy
+		limit   int64
+		want    []int
+		dropped uint64
+		spilled uint64
+	}{
+		{BackpressureDropNewest, 0, []int{1, 2}, 3, 0},
+		{BackpressureDropOldest, 0, []int{4, 5}, 3, 0},
+		{BackpressureSpill, 0, []int{1, 2, 3, 4, 5}, 0, 3},
+		{BackpressureSpill, 10, []int{1, 2, 3, 4}, 1, 2}, // two 5 byte records fit
+	}
+
+	for _, tt := range tests {
+		var mu sync.Mutex
+		var errs []error
+
+		q := newQueue("test", make(chan int, 2), tt.policy, intCodec)
+		q.spillDir, q.spillLimit = t.TempDir(), tt.limit
+		report := func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		}
+		q.report.Store(&report)
+
+		for i := 1; i <= 5; i++ {
+			q.send(i)
+		}
+
+		var got []int
+		for len(got) < len(tt.want) {
+			select {
+			case v := <-q.ch:
+				got = append(got, v)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: timed out after %v, expected %v", tt.policy, got, tt.want)
+			}
+		}
+
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: expected %v, got %v", tt.policy, tt.want, got)
+				break
+			}
+		}
+
+		if d, s := q.dropped.Load(), q.spilled.Load(); d != tt.dropped || s != tt.spilled {
+			t.Errorf("%s: expected %d dropped and %d spilled, got %d and %d", tt.policy, tt.dropped, tt.spilled, d, s)
+		}
+
+		mu.Lock()
+		for _, err := range errs {
+			var dropped ErrDropped
+			if !errors.As(err, &dropped) || dropped.Queue != "test" || dropped.Policy != tt.policy {
+				t.Errorf("%s: unexpected error %v", tt.policy, err)
+			}
+
+			if tt.limit > 0 && !errors.Is(dropped.Err, errSpillFull) {
+				t.Errorf("%s: expected a full spill queue, got %v", tt.policy, dropped.Err)
+			}
+		}
+
+		if uint64(len(errs)) != tt.dropped {
+			t.Errorf("%s: expected %d reported drops, got %d", tt.policy, tt.dropped, len(errs))
+		}
+		mu.Unlock()
+
+		var name string
+		if q.spill != nil {
+			name = q.spill.f.Name()
+		}
+
+		q.close()
+
+		if name != "" {
+			if _, err := os.Stat(name); !os.IsNotExist(err) {
+				t.Errorf("%s: expected the spill file to be removed, got %v", tt.policy, err)
+			}
+		}
+	}
+}
+
+func TestQueueSpillReadFailure(t *testing.T) {
+	q := newQueue("test", make(chan int, 1), BackpressureSpill, intCodec)
+	q.spillDir = t.TempDir()
+	defer q.close()
+
+	receive := func(want int) {
+		t.Helper()
+
+		select {
+		case v := <-q.ch:
+			if v != want {
+				t.Errorf("Expected %d, got %d", want, v)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %d", want)
+		}
+	}
+
+	// 1 fills the channel, the pump holds 2, and 3 waits in the file
+	for i := 1; i <= 3; i++ {
+		q.send(i)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	// Break the file under the pump
+	if err := q.spill.f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	receive(1)
+	receive(2)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for q.dropped.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the unreadable value to be dropped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Nothing's left in the file, so values go straight to the channel again
+	q.send(4)
+	receive(4)
+
+	if d := q.dropped.Load(); d != 1 {
+		t.Errorf("Expected 1 dropped value, got %d", d)
+	}
+}
+
+func TestQueueBlock(t *testing.T) {
+	q := newQueue("test", make(chan int), BackpressureBlock, intCodec)
+
+	sent := make(chan struct{})
+	go func() {
+		q.send(1)
+		close(sent)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if v := <-q.ch; v != 1 {
+		t.Errorf("Expected 1, got %d", v)
+	}
+	<-sent
+
+	if q.blocked.Load() == 0 || q.dropped.Load() != 0 {
+		t.Errorf("Expected the send to block without drops, got %d ns and %d drops", q.blocked.Load(), q.dropped.Load())
+	}
+}
+
+func TestSpillCodecs(t *testing.T) {
+	meta := testMeta("203.0.113.7", "192.168.1.2", 55021, 50000)
+	meta.StreamID, meta.Offset, meta.Handshake = 7, 1234, true
+	meta.FirstSeen, meta.LastSeen = time.Unix(1580625008, 0), time.Unix(1580625009, 500)
+
+	data, err := packetCodec.encode(reassembledPacket{Body: []byte{1, 2, 3}, Meta: *meta})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := packetCodec.decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(p.Body, []byte{1, 2, 3}) || p.Meta.Flow != meta.Flow || p.Meta.Transport != meta.Transport ||
+		p.Meta.StreamID != 7 || p.Meta.Offset != 1234 || !p.Meta.Handshake || p.Meta.Initiator ||
+		!p.Meta.FirstSeen.Equal(meta.FirstSeen) || !p.Meta.LastSeen.Equal(meta.LastSeen) {
+		t.Errorf("Unexpected packet %+v, expected %+v", p, *meta)
+	}
+
+	registry := NewPayloadRegistry()
+	event := &GameEventMessage{GenericHeader: GenericHeader{Segment: GameEvent, SourceActor: 42}, Opcode: 0x0232, Timestamp: time.Unix(1580625008, 0), Body: []byte{1, 2, 3, 4}}
+
+	codec := gameEventCodec(registry)
+	if data, err = codec.encode(event); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := codec.decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Opcode != 0x0232 || msg.SourceActor != 42 || !msg.Timestamp.Equal(event.Timestamp) || !bytes.Equal(msg.Body, event.Body) || msg.payloads != registry {
+		t.Errorf("Unexpected message %s", msg)
+	}
+}
+
+func TestSnifferBackpressureSpill(t *testing.T) {
+	ping := func(id uint32) []byte {
+		return wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: id})
+	}
+
+	packets := tcpStream(t, 55021, ping(1), ping(2), ping(3))
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, len(packets))
+	defer handle.Close()
+
+	dir := t.TempDir()
+	sniffer, err := NewSnifferFromHandle(handle, WithDataBufferSize(1), WithBackpressure(BackpressureSpill), WithSpillQueue(dir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+	defer sniffer.Stop()
+
+	for !sniffer.IsActive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, p := range packets {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every Frame is decoded without a reader, the ones that don't fit are spilled
+	deadline := time.Now().Add(5 * time.Second)
+	for sniffer.Stats().DataSpilled < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for Frames to spill, got %+v", sniffer.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected a spill file, got %d files", len(files))
+	}
+
+	var stream uint64
+	for id := uint32(1); id <= 3; id++ {
+		frames := make(chan *Frame, 1)
+		go func() {
+			if frame, err := sniffer.NextFrame(); err == nil {
+				frames <- frame
+			}
+		}()
+
+		select {
+		case frame := <-frames:
+			if got := binary.LittleEndian.Uint32(frame.Body[16:20]); got != id {
+				t.Errorf("Expected ping %d, got %d", id, got)
+			}
+
+			if stream == 0 {
+				stream = frame.Meta().StreamID
+			} else if frame.Meta().StreamID != stream {
+				t.Errorf("Expected the spilled Frame's StreamID %d, got %d", stream, frame.Meta().StreamID)
+			}
+
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a frame")
+		}
+	}
+
+	if stats := sniffer.Stats(); stats.DataDropped != 0 || stats.DataSpilled != 2 {
+		t.Errorf("Expected 2 Frames spilled and none dropped, got %+v", stats)
+	}
+}
@@ -0,0 +1,397 @@
+package zanarkand
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sync"
+)
+
+// Envelope is a decoded message delivered by a Broker, along with the Frame it arrived in.
+// A single Envelope is shared between every matching Subscription, so it must be
+// treated as read-only.
+type Envelope struct {
+	Frame     *Frame
+	Header    GenericHeader
+	Direction FlowDirection
+	Message   GenericMessage // *GameEventMessage, *KeepaliveMessage, or nil for other segments
+}
+
+// MessageFilter reports whether an Envelope should be delivered to a Subscription.
+type MessageFilter func(e *Envelope) bool
+
+// OpcodeFilter returns a MessageFilter matching GameEventMessages with any of the given opcodes.
+func OpcodeFilter(opcodes ...uint16) MessageFilter {
+	set := make(map[uint16]struct{}, len(opcodes))
+	for _, op := range opcodes {
+		set[op] = struct{}{}
+	}
+
+	return func(e *Envelope) bool {
+		msg, ok := e.Message.(*GameEventMessage)
+		if !ok {
+			return false
+		}
+
+		_, ok = set[msg.Opcode]
+		return ok
+	}
+}
+
+// SubscriptionOption configures a Subscription on a Broker.
+type SubscriptionOption func(*subscriptionConfig)
+
+type subscriptionConfig struct {
+	bufSize      int
+	backpressure BackpressurePolicy
+	segments     map[uint16]struct{}
+	direction    FlowDirection
+	filters      []MessageFilter
+}
+
+const defaultSubscriptionBufSize = 64
+
+// WithSubscriptionBuffer sets the buffer size of the Subscription's Events channel.
+// The default is 64.
+func WithSubscriptionBuffer(n int) SubscriptionOption {
+	return func(c *subscriptionConfig) { c.bufSize = n }
+}
+
+// WithSubscriptionBackpressure sets what happens to Envelopes when the Subscription's
+// Events channel is full, so a slow Subscription doesn't hold up the others. Dropped
+// Envelopes are counted by Dropped and reported on the Sniffer's Errors as an ErrDropped.
+// Spill queues use the Sniffer's WithSpillQueue settings. The default is BackpressureBlock,
+// which stalls every Subscription until there's room.
+func WithSubscriptionBackpressure(policy BackpressurePolicy) SubscriptionOption {
+	return func(c *subscriptionConfig) { c.backpressure = policy }
+}
+
+// WithSegments restricts a Subscription to messages of the given segment types.
+func WithSegments(segments ...uint16) SubscriptionOption {
+	return func(c *subscriptionConfig) {
+		c.segments = make(map[uint16]struct{}, len(segments))
+		for _, seg := range segments {
+			c.segments[seg] = struct{}{}
+		}
+	}
+}
+
+// WithDirection restricts a Subscription to messages flowing in one direction.
+func WithDirection(direction FlowDirection) SubscriptionOption {
+	return func(c *subscriptionConfig) { c.direction = direction }
+}
+
+// WithMessageFilter adds a filter to a Subscription. All filters must match for
+// a message to be delivered.
+func WithMessageFilter(fn MessageFilter) SubscriptionOption {
+	return func(c *subscriptionConfig) { c.filters = append(c.filters, fn) }
+}
+
+// Subscription is a single consumer registered on a Broker.
+type Subscription struct {
+	Events <-chan *Envelope
+
+	events chan *Envelope
+	queue  *queue[*Envelope]
+	cfg    subscriptionConfig
+	broker *Broker
+
+	// mu guards closing events against a concurrent send
+	mu     sync.Mutex
+	closed bool
+}
+
+// Close removes the Subscription from its Broker and closes the Events channel.
+func (sub *Subscription) Close() {
+	sub.broker.Remove(sub)
+}
+
+// Dropped returns the number of Envelopes dropped under the BackpressurePolicy set by
+// WithSubscriptionBackpressure.
+func (sub *Subscription) Dropped() uint64 {
+	return sub.queue.dropped.Load()
+}
+
+// send delivers an Envelope under the Subscription's BackpressurePolicy. A blocked send
+// gives up once the Subscription is closed or stop is.
+func (sub *Subscription) send(e *Envelope, stop <-chan struct{}) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if !sub.closed {
+		sub.queue.sendUntil(e, stop)
+	}
+}
+
+// shutdown unblocks any pending send, then closes the Events channel once it's finished.
+func (sub *Subscription) shutdown() {
+	sub.queue.shutdown()
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if !sub.closed {
+		sub.closed = true
+		close(sub.events)
+	}
+}
+
+func (sub *Subscription) matches(e *Envelope) bool {
+	if len(sub.cfg.segments) > 0 {
+		if _, ok := sub.cfg.segments[e.Header.Segment]; !ok {
+			return false
+		}
+	}
+
+	if sub.cfg.direction != 0 && sub.cfg.direction != e.Direction {
+		return false
+	}
+
+	for _, fn := range sub.cfg.filters {
+		if !fn(e) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Broker is a Subscriber that reads each Frame from a Sniffer once, decodes each
+// message once, and fans it out to any number of Subscriptions. Subscriptions may
+// be added and removed while the Broker is running.
+type Broker struct {
+	mu      sync.RWMutex
+	subs    map[*Subscription]struct{}
+	sniffer *Sniffer // set by Subscribe, for reporting drops and spilling
+	closed  bool
+}
+
+// NewBroker returns a Broker with no Subscriptions.
+func NewBroker() *Broker {
+	return &Broker{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Add registers a new Subscription. If the Broker has been closed, the returned
+// Subscription's Events channel is already closed.
+func (b *Broker) Add(opts ...SubscriptionOption) *Subscription {
+	cfg := subscriptionConfig{bufSize: defaultSubscriptionBufSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	events := make(chan *Envelope, cfg.bufSize)
+	sub := &Subscription{
+		Events: events,
+		events: events,
+		queue:  newQueue("subscription events", events, cfg.backpressure, envelopeCodec),
+		cfg:    cfg,
+		broker: b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		sub.shutdown()
+		return sub
+	}
+
+	if b.sniffer != nil {
+		sub.queue.attach(b.sniffer)
+	}
+
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Remove unregisters a Subscription and closes its Events channel.
+// Removing a Subscription more than once is a no-op.
+func (b *Broker) Remove(sub *Subscription) {
+	b.mu.Lock()
+	delete(b.subs, sub)
+	b.mu.Unlock()
+
+	sub.shutdown()
+}
+
+// Len returns the number of registered Subscriptions.
+func (b *Broker) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
+
+// Subscribe starts the Broker. It blocks until the context is cancelled,
+// the Sniffer is stopped, or an error occurs. If the Sniffer is stopped, it will be
+// started in a goroutine. Messages that fail to decode are reported on the Sniffer's
+// Errors as an ErrDecodingFailure and skipped.
+func (b *Broker) Subscribe(ctx context.Context, s *Sniffer) error {
+	b.mu.Lock()
+	b.sniffer = s
+	for sub := range b.subs {
+		sub.queue.attach(s)
+	}
+	b.mu.Unlock()
+
+	s.startIfStopped(ctx)
+
+	return s.ProcessFrames(b.handler(ctx, s))
+}
+
+// handler decodes each message once and publishes it.
+func (b *Broker) handler(ctx context.Context, s *Sniffer) FrameHandler {
+	return func(frame *Frame, header *GenericHeader, r *bufio.Reader) error {
+		if b.Len() == 0 {
+			return nil
+		}
+
+		e := &Envelope{
+			Frame:     frame,
+			Header:    *header,
+			Direction: frame.Direction(),
+		}
+
+		switch header.Segment {
+		case GameEvent:
+			msg := new(GameEventMessage)
+			if err := msg.Decode(r); err != nil {
+				s.logDecodeFailure(frame, header, err)
+				s.reportError(ErrDecodingFailure{Err: err})
+				return nil
+			}
+			e.Message = msg
+
+		case ServerPing, ServerPong:
+			msg := new(KeepaliveMessage)
+			if err := msg.Decode(r); err != nil {
+				s.logDecodeFailure(frame, header, err)
+				s.reportError(ErrDecodingFailure{Err: err})
+				return nil
+			}
+			e.Message = msg
+		}
+
+		b.publish(ctx, e)
+		return nil
+	}
+}
+
+// Close will stop a sniffer, then remove every Subscription and close their channels.
+func (b *Broker) Close(s *Sniffer) {
+	s.Stop()
+
+	b.mu.Lock()
+	b.closed = true
+	subs := b.subs
+	b.subs = make(map[*Subscription]struct{})
+	b.mu.Unlock()
+
+	for sub := range subs {
+		sub.shutdown()
+	}
+}
+
+// publish delivers an Envelope to every matching Subscription under its BackpressurePolicy.
+// Blocked sends give up when the Subscription is removed, the Broker closed, or ctx
+// cancelled. Subscriptions are sent to outside the lock, so one slow consumer doesn't hold
+// up Add, Remove or Close.
+func (b *Broker) publish(ctx context.Context, e *Envelope) {
+	b.mu.RLock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for sub := range b.subs {
+		subs = append(subs, sub)
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		if !sub.matches(e) {
+			continue
+		}
+
+		sub.send(e, ctx.Done())
+	}
+}
+
+// spilledEnvelope is an Envelope in a spill queue: its Frame as a spilled packet, and its
+// GenericHeader and message in wire format.
+type spilledEnvelope struct {
+	Packet    []byte
+	Direction FlowDirection
+	Header    []byte
+	Message   []byte
+}
+
+// envelopeCodec spills the Envelopes of a Subscription. The Frame comes back with the
+// Direction it had, rather than the Sniffer's DirectionStrategy.
+var envelopeCodec = spillCodec[*Envelope]{
+	encode: func(e *Envelope) ([]byte, error) {
+		frame, err := e.Frame.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		packet, err := packetCodec.encode(reassembledPacket{Body: frame, Meta: e.Frame.meta})
+		if err != nil {
+			return nil, err
+		}
+
+		header, err := e.Header.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		var msg []byte
+		switch m := e.Message.(type) {
+		case *GameEventMessage:
+			msg, err = m.MarshalBinary()
+		case *KeepaliveMessage:
+			msg, err = m.MarshalBinary()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		err = gob.NewEncoder(&buf).Encode(spilledEnvelope{Packet: packet, Direction: e.Direction, Header: header, Message: msg})
+
+		return buf.Bytes(), err
+	},
+
+	decode: func(data []byte) (*Envelope, error) {
+		var se spilledEnvelope
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&se); err != nil {
+			return nil, err
+		}
+
+		packet, err := packetCodec.decode(se.Packet)
+		if err != nil {
+			return nil, err
+		}
+
+		frame := new(Frame)
+		if err := frame.Decode(packet.Body); err != nil {
+			return nil, err
+		}
+		frame.meta = packet.Meta
+		frame.direction = DirectionFunc(func(*FrameMeta) FlowDirection { return se.Direction })
+
+		e := &Envelope{Frame: frame, Direction: se.Direction}
+		if len(se.Header) < messageHeaderLength {
+			return nil, ErrNotEnoughData{Expected: messageHeaderLength, Received: len(se.Header)}
+		}
+		e.Header.unmarshal(se.Header)
+
+		switch {
+		case len(se.Message) == 0:
+		case e.Header.Segment == GameEvent:
+			e.Message, err = gameEventCodec(nil).decode(se.Message)
+		default:
+			e.Message, err = keepaliveCodec.decode(se.Message)
+		}
+
+		return e, err
+	},
+}
@@ -0,0 +1,311 @@
+package zanarkand
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+// drain reads an Events channel until it's closed.
+func drain(t *testing.T, events <-chan *Envelope) []*Envelope {
+	t.Helper()
+
+	var got []*Envelope
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return got
+			}
+			got = append(got, e)
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for the Events channel to close")
+		}
+	}
+}
+
+func TestBrokerFanOut(t *testing.T) {
+	event := func(opcode uint16) *GameEventMessage {
+		return &GameEventMessage{GenericHeader: GenericHeader{Segment: GameEvent}, Opcode: opcode, Body: []byte{1, 2, 3, 4}}
+	}
+	ping := &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: 1}
+
+	packets := append(
+		tcpReply(t, testServerIP, testClientIP, 55021, 50000, wireFrame(t, FrameCompressionZlib, event(0x0232), ping)),
+		tcpPackets(t, testClientIP, testServerIP, 50000, 55021, wireFrame(t, FrameCompressionNone, event(0x0125)))...,
+	)
+	handle := devices.NewMemoryHandleFromPackets(layers.LinkTypeEthernet, packets...)
+
+	sniffer, err := NewSnifferFromHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := NewBroker()
+	all := broker.Add()
+	events := broker.Add(WithSegments(GameEvent))
+	opcode := broker.Add(WithMessageFilter(OpcodeFilter(0x0125)))
+	egress := broker.Add(WithDirection(FrameEgress))
+
+	if err := broker.Subscribe(context.Background(), sniffer); err != nil {
+		t.Fatal(err)
+	}
+
+	broker.Close(sniffer)
+
+	tests := []struct {
+		name     string
+		sub      *Subscription
+		segments []uint16
+	}{
+		{"all", all, []uint16{GameEvent, ServerPing, GameEvent}},
+		{"segments", events, []uint16{GameEvent, GameEvent}},
+		{"opcode", opcode, []uint16{GameEvent}},
+		{"direction", egress, []uint16{GameEvent}},
+	}
+
+	for _, tt := range tests {
+		got := drain(t, tt.sub.Events)
+
+		// Each direction is its own stream, so only compare counts per segment
+		count := make(map[uint16]int)
+		for _, e := range got {
+			count[e.Header.Segment]++
+		}
+		want := make(map[uint16]int)
+		for _, seg := range tt.segments {
+			want[seg]++
+		}
+
+		if len(got) != len(tt.segments) || count[GameEvent] != want[GameEvent] || count[ServerPing] != want[ServerPing] {
+			t.Errorf("%s: expected segments %v, got %d envelopes %v", tt.name, tt.segments, len(got), count)
+		}
+	}
+
+	if got := drain(t, opcode.Events); len(got) != 0 {
+		t.Errorf("Expected the Events channel to stay closed, got %d envelopes", len(got))
+	}
+}
+
+func TestBrokerRemoveWhilePublishing(t *testing.T) {
+	broker := NewBroker()
+	stalled := broker.Add(WithSubscriptionBuffer(0))
+	other := broker.Add(WithSubscriptionBuffer(1))
+
+	published := make(chan struct{})
+	go func() {
+		broker.publish(context.Background(), &Envelope{Header: GenericHeader{Segment: GameEvent}})
+		close(published)
+	}()
+
+	// Nothing reads stalled, so publish is stuck on it or about to be
+	time.Sleep(10 * time.Millisecond)
+
+	added := make(chan *Subscription)
+	go func() { added <- broker.Add() }()
+
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("Add blocked behind a stalled Subscription")
+	}
+
+	removed := make(chan struct{})
+	go func() {
+		broker.Remove(stalled)
+		close(removed)
+	}()
+
+	select {
+	case <-removed:
+	case <-time.After(time.Second):
+		t.Fatal("Remove blocked behind a stalled Subscription")
+	}
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publish didn't move on after the Subscription was removed")
+	}
+
+	if _, ok := <-stalled.Events; ok {
+		t.Error("Expected the removed Subscription's Events channel to be closed")
+	}
+
+	if len(other.Events) != 1 {
+		t.Errorf("Expected the other Subscription to get the Envelope, got %d", len(other.Events))
+	}
+
+	if broker.Len() != 2 {
+		t.Errorf("Expected 2 Subscriptions left, got %d", broker.Len())
+	}
+
+	// Removing twice is a no-op
+	stalled.Close()
+}
+
+func TestBrokerClose(t *testing.T) {
+	sniffer, err := NewSnifferFromHandle(devices.NewMemoryHandle(layers.LinkTypeEthernet, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := NewBroker()
+	stalled := broker.Add(WithSubscriptionBuffer(0))
+
+	published := make(chan struct{})
+	go func() {
+		broker.publish(context.Background(), &Envelope{})
+		close(published)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		broker.Close(sniffer)
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked behind a stalled Subscription")
+	}
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publish didn't return after Close")
+	}
+
+	if got := drain(t, stalled.Events); len(got) != 0 {
+		t.Errorf("Expected no Envelopes after Close, got %d", len(got))
+	}
+
+	if broker.Len() != 0 {
+		t.Errorf("Expected no Subscriptions after Close, got %d", broker.Len())
+	}
+
+	late := broker.Add()
+	if _, ok := <-late.Events; ok {
+		t.Error("Expected a Subscription added after Close to be closed")
+	}
+
+	// Closing twice is a no-op
+	broker.Close(sniffer)
+}
+
+func TestBrokerSlowSubscription(t *testing.T) {
+	for _, policy := range []BackpressurePolicy{BackpressureDropNewest, BackpressureSpill} {
+		sniffer, err := NewSnifferFromHandle(devices.NewMemoryHandle(layers.LinkTypeEthernet, 0), WithSpillQueue(t.TempDir(), 0), WithErrorBufferSize(8))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		broker := NewBroker()
+		slow := broker.Add(WithSubscriptionBuffer(1), WithSubscriptionBackpressure(policy))
+		fast := broker.Add(WithSubscriptionBuffer(4))
+		broker.sniffer = sniffer
+		slow.queue.attach(sniffer)
+
+		frame, err := NewFrame(1, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame.direction = DirectionFunc(func(*FrameMeta) FlowDirection { return FrameIngress })
+
+		// Nothing reads slow, which mustn't hold up fast
+		published := make(chan struct{})
+		go func() {
+			for i := uint32(1); i <= 3; i++ {
+				msg := &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing, Length: 24}, ID: i}
+				broker.publish(context.Background(), &Envelope{Frame: frame, Header: msg.GenericHeader, Direction: FrameIngress, Message: msg})
+			}
+			close(published)
+		}()
+
+		select {
+		case <-published:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: a slow Subscription held up the others", policy)
+		}
+
+		if len(fast.Events) != 3 {
+			t.Errorf("%s: expected 3 Envelopes for the fast Subscription, got %d", policy, len(fast.Events))
+		}
+
+		if policy == BackpressureDropNewest {
+			if slow.Dropped() != 2 {
+				t.Errorf("%s: expected 2 dropped Envelopes, got %d", policy, slow.Dropped())
+			}
+			broker.Close(sniffer)
+			continue
+		}
+
+		// Spilled Envelopes come back in order
+		for i := uint32(1); i <= 3; i++ {
+			select {
+			case e := <-slow.Events:
+				msg, ok := e.Message.(*KeepaliveMessage)
+				if !ok || msg.ID != i || e.Direction != FrameIngress || e.Frame.Direction() != FrameIngress {
+					t.Errorf("%s: expected ping %d, got %+v", policy, i, e)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s: timed out waiting for ping %d", policy, i)
+			}
+		}
+
+		broker.Close(sniffer)
+	}
+}
+
+func TestBrokerDecodeFailure(t *testing.T) {
+	sniffer, err := NewSnifferFromHandle(devices.NewMemoryHandle(layers.LinkTypeEthernet, 0), WithErrorBufferSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := NewBroker()
+	all := broker.Add(WithSubscriptionBuffer(4))
+	handle := broker.handler(context.Background(), sniffer)
+
+	good, err := (&KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: 1}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame := &Frame{}
+	for _, msg := range [][]byte{good[:20], good} {
+		header := new(GenericHeader)
+		header.unmarshal(msg)
+
+		if err := handle(frame, header, bufio.NewReader(bytes.NewReader(msg))); err != nil {
+			t.Fatalf("Expected the Broker to carry on past a bad message, got %v", err)
+		}
+	}
+
+	broker.Close(sniffer)
+
+	got := drain(t, all.Events)
+	if len(got) != 1 || got[0].Message.(*KeepaliveMessage).ID != 1 {
+		t.Errorf("Expected only the good ping, got %d Envelopes", len(got))
+	}
+
+	select {
+	case err := <-sniffer.Errors():
+		var failure ErrDecodingFailure
+		if !errors.As(err, &failure) {
+			t.Errorf("Expected an ErrDecodingFailure, got %v", err)
+		}
+	default:
+		t.Error("Expected the decode failure on Errors")
+	}
+}
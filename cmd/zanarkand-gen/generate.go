@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+)
+
+// generator writes Go source for a validated Schema.
+type generator struct {
+	schema *Schema
+	source string // schema file name, for the generated header
+	buf    bytes.Buffer
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// formatted returns the gofmt'd buffer contents.
+func (g *generator) formatted() ([]byte, error) {
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w\n%s", err, g.buf.Bytes())
+	}
+
+	return src, nil
+}
+
+func (g *generator) header(imports ...string) {
+	g.printf("// Code generated by zanarkand-gen from %s. DO NOT EDIT.\n\n", g.source)
+	g.printf("package %s\n\n", g.schema.Package)
+
+	g.printf("import (\n")
+	for _, imp := range imports {
+		if imp == "" {
+			g.printf("\n")
+			continue
+		}
+		g.printf("%q\n", imp)
+	}
+	g.printf(")\n\n")
+}
+
+// Generate returns the source for the packet structs in the schema.
+func Generate(schema *Schema, source string) ([]byte, error) {
+	g := &generator{schema: schema, source: source}
+
+	imports := []string{"encoding/binary"}
+	if schema.uses(func(f *FieldSchema) bool { return f.Type == "string" }) {
+		imports = append([]string{"bytes"}, imports...)
+	}
+	if schema.hasJSON() {
+		imports = append(imports, "encoding/json")
+	}
+	imports = append(imports, "fmt")
+	if schema.uses(func(f *FieldSchema) bool { return f.Type == "float32" || f.Type == "float64" }) {
+		imports = append(imports, "math")
+	}
+	imports = append(imports, "", "github.com/ayyaruq/zanarkand")
+
+	g.header(imports...)
+
+	for i := range schema.Types {
+		g.typeDecl(&schema.Types[i])
+	}
+
+	return g.formatted()
+}
+
+// GenerateTests returns the source for table-driven round trip tests of the packet structs.
+func GenerateTests(schema *Schema, source string) ([]byte, error) {
+	g := &generator{schema: schema, source: source}
+
+	imports := []string{"bytes", "encoding"}
+	if schema.hasJSON() {
+		imports = append(imports, "encoding/json")
+	}
+	imports = append(imports, "fmt", "testing")
+
+	g.header(imports...)
+
+	g.printf("func Test%sRoundTrip(t *testing.T) {\n", exportedName(source))
+	g.printf(`type codec interface {
+		encoding.BinaryMarshaler
+		encoding.BinaryUnmarshaler
+		fmt.Stringer
+	}
+
+	tests := []struct {
+		name string
+		size int
+		json bool
+		new  func() codec
+	}{
+	`)
+
+	for i := range schema.Types {
+		t := &schema.Types[i]
+		g.printf("{%q, %sSize, %t, func() codec { return new(%s) }},\n", t.Name, t.Name, t.generateJSON(), t.Name)
+	}
+
+	g.printf(`}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, tt.size)
+			for i := range data {
+				data[i] = byte(i*7 + 1)
+			}
+
+			first := tt.new()
+			if err := first.UnmarshalBinary(data); err != nil {
+				t.Fatal(err)
+			}
+
+			encoded, err := first.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(encoded) != tt.size {
+				t.Errorf("Expected %%d bytes, got %%d", tt.size, len(encoded))
+			}
+
+			second := tt.new()
+			if err := second.UnmarshalBinary(encoded); err != nil {
+				t.Fatal(err)
+			}
+
+			reencoded, err := second.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(encoded, reencoded) {
+				t.Errorf("Round trip mismatch:\n%%x\n%%x", encoded, reencoded)
+			}
+
+			if first.String() != second.String() {
+				t.Errorf("String mismatch: %%s != %%s", first, second)
+			}
+	`)
+
+	if schema.hasJSON() {
+		g.printf(`
+			if tt.json {
+				if _, err := json.Marshal(first); err != nil {
+					t.Errorf("MarshalJSON failed: %%v", err)
+				}
+			}
+		`)
+	}
+
+	g.printf(`
+			if tt.size > 0 {
+				if err := tt.new().UnmarshalBinary(data[:tt.size-1]); err == nil {
+					t.Error("Expected an error decoding short data")
+				}
+			}
+		})
+	}
+}
+`)
+
+	return g.formatted()
+}
+
+func (g *generator) typeDecl(t *TypeSchema) {
+	g.printf("// %sSize is the size of an encoded %s in bytes.\n", t.Name, t.Name)
+	g.printf("const %sSize = %d\n\n", t.Name, t.size)
+
+	if t.Opcode != "" {
+		g.printf("// %sOpcode is the opcode of %s messages.\n", t.Name, t.Name)
+		g.printf("const %sOpcode uint16 = 0x%04X\n\n", t.Name, t.opcode)
+	}
+
+	if t.Doc != "" {
+		g.comment(t.Doc)
+	} else {
+		g.printf("// %s is a generated packet struct.\n", t.Name)
+	}
+
+	g.printf("type %s struct {\n", t.Name)
+	for i := range t.Fields {
+		f := &t.Fields[i]
+		g.printf("%s %s `json:%q`", f.Name, f.goType(), f.jsonName())
+		if f.Doc != "" {
+			g.printf(" // %s", f.Doc)
+		}
+		g.printf("\n")
+	}
+	g.printf("}\n\n")
+
+	g.unmarshal(t)
+	g.marshal(t)
+	g.stringer(t)
+
+	if t.generateJSON() {
+		g.printf("// MarshalJSON encodes the %s fields as a JSON object.\n", t.Name)
+		g.printf("func (p *%s) MarshalJSON() ([]byte, error) {\n", t.Name)
+		g.printf("type plain %s\n", t.Name)
+		g.printf("return json.Marshal((*plain)(p))\n")
+		g.printf("}\n\n")
+	}
+}
+
+func (g *generator) comment(doc string) {
+	for _, line := range strings.Split(strings.TrimSpace(doc), "\n") {
+		g.printf("// %s\n", line)
+	}
+}
+
+func (g *generator) unmarshal(t *TypeSchema) {
+	g.printf("// UnmarshalBinary decodes %s from its wire format.\n", article(t.Name))
+	g.printf("func (p *%s) UnmarshalBinary(data []byte) error {\n", t.Name)
+	g.printf("if len(data) < %sSize {\n", t.Name)
+	g.printf("return zanarkand.ErrNotEnoughData{Expected: %sSize, Received: len(data), Field: %q}\n", t.Name, t.Name)
+	g.printf("}\n\n")
+
+	for i := range t.Fields {
+		f := &t.Fields[i]
+
+		switch {
+		case f.Type == "string":
+			g.printf("p.%s = string(bytes.TrimRight(data[%d:%d], \"\\x00\"))\n", f.Name, f.Offset, f.Offset+f.Count)
+
+		case f.nested != nil && f.isArray():
+			g.printf("for i := range p.%s {\n", f.Name)
+			g.printf("if err := p.%s[i].UnmarshalBinary(data[%d+i*%d:]); err != nil {\nreturn err\n}\n", f.Name, f.Offset, f.size)
+			g.printf("}\n")
+
+		case f.nested != nil:
+			g.printf("if err := p.%s.UnmarshalBinary(data[%d:]); err != nil {\nreturn err\n}\n", f.Name, f.Offset)
+
+		case f.isArray() && f.size == 1 && f.Type != "bool" && f.Type != "int8":
+			g.printf("copy(p.%s[:], data[%d:%d])\n", f.Name, f.Offset, f.Offset+f.Count)
+
+		case f.isArray():
+			g.printf("for i := range p.%s {\n", f.Name)
+			g.printf("p.%s[i] = %s\n", f.Name, f.decodeExpr(fmt.Sprintf("data[%d+i*%d:]", f.Offset, f.size)))
+			g.printf("}\n")
+
+		default:
+			g.printf("p.%s = %s\n", f.Name, f.decodeExpr(fmt.Sprintf("data[%d:]", f.Offset)))
+		}
+	}
+
+	g.printf("\nreturn nil\n")
+	g.printf("}\n\n")
+}
+
+func (g *generator) marshal(t *TypeSchema) {
+	g.printf("// MarshalBinary encodes %s into its wire format.\n", article(t.Name))
+	g.printf("func (p *%s) MarshalBinary() ([]byte, error) {\n", t.Name)
+	g.printf("data := make([]byte, %sSize)\n", t.Name)
+	g.printf("p.encode(data)\n")
+	g.printf("return data, nil\n")
+	g.printf("}\n\n")
+
+	g.printf("// encode writes %s into data, which must hold at least %sSize bytes.\n", article(t.Name), t.Name)
+	g.printf("func (p *%s) encode(data []byte) {\n", t.Name)
+
+	for i := range t.Fields {
+		f := &t.Fields[i]
+
+		switch {
+		case f.Type == "string":
+			g.printf("copy(data[%d:%d], p.%s)\n", f.Offset, f.Offset+f.Count, f.Name)
+
+		case f.nested != nil && f.isArray():
+			g.printf("for i := range p.%s {\n", f.Name)
+			g.printf("p.%s[i].encode(data[%d+i*%d:])\n", f.Name, f.Offset, f.size)
+			g.printf("}\n")
+
+		case f.nested != nil:
+			g.printf("p.%s.encode(data[%d:])\n", f.Name, f.Offset)
+
+		case f.isArray() && f.size == 1 && f.Type != "bool" && f.Type != "int8":
+			g.printf("copy(data[%d:%d], p.%s[:])\n", f.Offset, f.Offset+f.Count, f.Name)
+
+		case f.isArray():
+			g.printf("for i, v := range p.%s {\n", f.Name)
+			g.printf("%s\n", f.encodeStmt(fmt.Sprintf("data[%d+i*%d:]", f.Offset, f.size), "v"))
+			g.printf("}\n")
+
+		default:
+			g.printf("%s\n", f.encodeStmt(fmt.Sprintf("data[%d:]", f.Offset), "p."+f.Name))
+		}
+	}
+
+	g.printf("}\n\n")
+}
+
+func (g *generator) stringer(t *TypeSchema) {
+	verbs := make([]string, 0, len(t.Fields))
+	args := make([]string, 0, len(t.Fields))
+
+	for i := range t.Fields {
+		f := &t.Fields[i]
+
+		verb := "%v"
+		if f.Type == "string" {
+			verb = "%q"
+		} else if f.nested != nil && !f.isArray() {
+			verb = "%s"
+		}
+
+		verbs = append(verbs, f.Name+": "+verb)
+
+		if f.nested != nil && !f.isArray() {
+			args = append(args, "&p."+f.Name)
+		} else {
+			args = append(args, "p."+f.Name)
+		}
+	}
+
+	g.printf("// String returns the %s fields for logging.\n", t.Name)
+	g.printf("func (p *%s) String() string {\n", t.Name)
+	if len(args) == 0 {
+		g.printf("return %q\n", t.Name+"{}")
+	} else {
+		g.printf("return fmt.Sprintf(%q, %s)\n", t.Name+"{"+strings.Join(verbs, ", ")+"}", strings.Join(args, ", "))
+	}
+	g.printf("}\n\n")
+}
+
+// order returns the binary.ByteOrder expression for the field.
+func (f *FieldSchema) order() string {
+	if f.Endian == "big" {
+		return "binary.BigEndian"
+	}
+	return "binary.LittleEndian"
+}
+
+// bits returns the integer width backing a multi-byte scalar.
+func (f *FieldSchema) bits() string {
+	return fmt.Sprint(f.size * 8)
+}
+
+// decodeExpr returns an expression decoding a scalar of the field's type from src.
+func (f *FieldSchema) decodeExpr(src string) string {
+	switch f.Type {
+	case "bool":
+		return src[:len(src)-2] + "] != 0"
+	case "byte", "uint8":
+		return src[:len(src)-2] + "]"
+	case "int8":
+		return "int8(" + src[:len(src)-2] + "])"
+	case "uint16", "uint32", "uint64":
+		return fmt.Sprintf("%s.Uint%s(%s)", f.order(), f.bits(), src)
+	case "int16", "int32", "int64":
+		return fmt.Sprintf("%s(%s.Uint%s(%s))", f.Type, f.order(), f.bits(), src)
+	case "float32", "float64":
+		return fmt.Sprintf("math.Float%sfrombits(%s.Uint%s(%s))", f.bits(), f.order(), f.bits(), src)
+	}
+
+	panic("unsupported scalar type " + f.Type)
+}
+
+// encodeStmt returns a statement encoding value, a scalar of the field's type, into dst.
+func (f *FieldSchema) encodeStmt(dst, value string) string {
+	index := dst[:len(dst)-2] + "]"
+
+	switch f.Type {
+	case "bool":
+		return fmt.Sprintf("if %s {\n%s = 1\n}", value, index)
+	case "byte", "uint8":
+		return fmt.Sprintf("%s = %s", index, value)
+	case "int8":
+		return fmt.Sprintf("%s = byte(%s)", index, value)
+	case "uint16", "uint32", "uint64":
+		return fmt.Sprintf("%s.PutUint%s(%s, %s)", f.order(), f.bits(), dst, value)
+	case "int16", "int32", "int64":
+		return fmt.Sprintf("%s.PutUint%s(%s, uint%s(%s))", f.order(), f.bits(), dst, f.bits(), value)
+	case "float32", "float64":
+		return fmt.Sprintf("%s.PutUint%s(%s, math.Float%sbits(%s))", f.order(), f.bits(), dst, f.bits(), value)
+	}
+
+	panic("unsupported scalar type " + f.Type)
+}
+
+// uses reports whether any field in the schema matches fn.
+func (s *Schema) uses(fn func(*FieldSchema) bool) bool {
+	for i := range s.Types {
+		for j := range s.Types[i].Fields {
+			if fn(&s.Types[i].Fields[j]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasJSON reports whether any type in the schema gets a generated MarshalJSON.
+func (s *Schema) hasJSON() bool {
+	for i := range s.Types {
+		if s.Types[i].generateJSON() {
+			return true
+		}
+	}
+	return false
+}
+
+// article prefixes a type name with "a" or "an".
+func article(name string) string {
+	if strings.ContainsRune("AEIOU", rune(name[0])) {
+		return "an " + name
+	}
+	return "a " + name
+}
+
+// exportedName turns a file name such as "event_play.json" into "EventPlay".
+func exportedName(file string) string {
+	base := file
+	if i := strings.LastIndexAny(base, `/\`); i >= 0 {
+		base = base[i+1:]
+	}
+	if i := strings.Index(base, "."); i > 0 {
+		base = base[:i]
+	}
+
+	var b strings.Builder
+	upper := true
+	for _, r := range base {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+
+	if b.Len() == 0 || !unicode.IsLetter([]rune(b.String())[0]) {
+		return "Generated" + b.String()
+	}
+	return b.String()
+}
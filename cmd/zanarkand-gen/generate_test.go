@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSchema = `
+package: packets
+types:
+  - name: Position
+    fields:
+      - {name: X, offset: 0, type: float32}
+      - {name: Y, offset: 4, type: float32}
+  - name: ActorCast
+    doc: ActorCast is sent when an actor starts casting.
+    opcode: "0x0232"
+    size: 64
+    fields:
+      - {name: ActionID, offset: 0, type: uint16}
+      - {name: Interruptible, offset: 2, type: bool}
+      - {name: Delta, offset: 3, type: int8}
+      - {name: Targets, offset: 4, type: uint32, count: 2}
+      - {name: Name, offset: 12, type: string, count: 8}
+      - {name: Sequence, offset: 20, type: uint32, endian: big, json: "-"}
+      - {name: Origin, offset: 24, type: Position}
+      - {name: Path, offset: 32, type: Position, count: 2}
+`
+
+func loadTestSchema(t *testing.T, contents string) *Schema {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "packets.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := LoadSchema(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return schema
+}
+
+func TestGenerate(t *testing.T) {
+	schema := loadTestSchema(t, testSchema)
+	if err := schema.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if schema.Types[0].size != 8 || schema.Types[1].size != 64 {
+		t.Errorf("Unexpected sizes %d and %d", schema.Types[0].size, schema.Types[1].size)
+	}
+
+	src, err := Generate(schema, "packets.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"const ActorCastOpcode uint16 = 0x0232",
+		"const ActorCastSize = 64",
+		"p.Interruptible = data[2] != 0",
+		"p.Delta = int8(data[3])",
+		"p.Targets[i] = binary.LittleEndian.Uint32(data[4+i*4:])",
+		`p.Name = string(bytes.TrimRight(data[12:20], "\x00"))`,
+		"p.Sequence = binary.BigEndian.Uint32(data[20:])",
+		"p.Path[i].encode(data[32+i*8:])",
+		"binary.LittleEndian.PutUint32(data[0:], math.Float32bits(p.X))",
+		"`json:\"-\"`",
+	}
+
+	for _, want := range expected {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("Generated code is missing %q", want)
+		}
+	}
+
+	if strings.Contains(string(src), "reflect") {
+		t.Error("Generated code should not use reflection")
+	}
+
+	if _, err := GenerateTests(schema, "packets.yaml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		err    string
+	}{
+		{"unknown type", "package: p\ntypes: [{name: A, fields: [{name: X, offset: 0, type: uint128}]}]", "unknown type"},
+		{"forward reference", "package: p\ntypes: [{name: A, fields: [{name: X, offset: 0, type: B}]}, {name: B}]", "unknown type"},
+		{"string without count", "package: p\ntypes: [{name: A, fields: [{name: X, offset: 0, type: string}]}]", "need a count"},
+		{"size too small", "package: p\ntypes: [{name: A, size: 2, fields: [{name: X, offset: 0, type: uint32}]}]", "smaller than the fields"},
+		{"duplicate field", "package: p\ntypes: [{name: A, fields: [{name: X, offset: 0, type: byte}, {name: X, offset: 1, type: byte}]}]", "duplicate field"},
+		{"bad opcode", "package: p\ntypes: [{name: A, opcode: \"0x10000\"}]", "invalid opcode"},
+		{"bad endian", "package: p\ntypes: [{name: A, fields: [{name: X, offset: 0, type: uint16, endian: middle}]}]", "endianness"},
+		{"unexported", "package: p\ntypes: [{name: a}]", "exported identifier"},
+		{"method name", "package: p\ntypes: [{name: A, fields: [{name: String, offset: 0, type: byte}]}]", "field String: the name is taken"},
+		{"marshal name", "package: p\ntypes: [{name: A, fields: [{name: MarshalBinary, offset: 0, type: byte}]}]", "field MarshalBinary: the name is taken"},
+		{"overlap", "package: p\ntypes: [{name: A, fields: [{name: X, offset: 0, type: uint32}, {name: Y, offset: 2, type: uint16}]}]", "field Y overlaps field X"},
+		{"overlap array", "package: p\ntypes: [{name: A, fields: [{name: Y, offset: 6, type: byte}, {name: X, offset: 0, type: uint16, count: 4}]}]", "field X overlaps field Y"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadTestSchema(t, tt.schema).Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
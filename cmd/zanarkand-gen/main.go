@@ -0,0 +1,106 @@
+// Command zanarkand-gen generates Go packet structs from a schema of IPC message layouts.
+//
+// Each generated struct gets UnmarshalBinary and MarshalBinary methods that decode and
+// encode fields at fixed offsets without reflection, as well as String and MarshalJSON,
+// and a table-driven round trip test. When layouts shift between patches only the schema
+// needs updating.
+//
+// Schemas are JSON or YAML files:
+//
+//	{
+//	  "package": "main",
+//	  "types": [
+//	    {
+//	      "name": "ActorCast",
+//	      "doc": "ActorCast is sent when an actor starts casting.",
+//	      "opcode": "0x0232",
+//	      "fields": [
+//	        {"name": "ActionID", "offset": 0, "type": "uint16"},
+//	        {"name": "CastTime", "offset": 8, "type": "float32"},
+//	        {"name": "Position", "offset": 20, "type": "uint16", "count": 3},
+//	        {"name": "Name", "offset": 26, "type": "string", "count": 32},
+//	        {"name": "Sequence", "offset": 58, "type": "uint32", "endian": "big", "json": "-"}
+//	      ]
+//	    }
+//	  ]
+//	}
+//
+// Field types are bool, byte, the sized integer and float types, string, or the name of a
+// type declared earlier in the schema. Count makes a field a fixed array, or gives the byte
+// size of a NUL-padded string. Fields may not overlap, or share a name with a generated
+// method. A type's size defaults to the end of its last field. Set "json" to false on a
+// type to skip generating MarshalJSON.
+//
+// Usage:
+//
+//	//go:generate go run github.com/ayyaruq/zanarkand/cmd/zanarkand-gen -schema packets.json -out packets_gen.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "zanarkand-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var schemaFile = flag.String("schema", "", "The JSON or YAML schema to generate from")
+	var outFile = flag.String("out", "", "The Go file to write, defaults to the schema name with a _gen.go suffix")
+	var pkg = flag.String("package", "", "The package name, overriding the schema")
+	var tests = flag.Bool("tests", true, "Also write a _test.go file with round trip tests")
+
+	flag.Parse()
+
+	if *schemaFile == "" {
+		flag.Usage()
+		return fmt.Errorf("no schema given")
+	}
+
+	schema, err := LoadSchema(*schemaFile)
+	if err != nil {
+		return err
+	}
+
+	if *pkg != "" {
+		schema.Package = *pkg
+	}
+
+	if err := schema.Validate(); err != nil {
+		return err
+	}
+
+	out := *outFile
+	if out == "" {
+		out = strings.TrimSuffix(*schemaFile, filepath.Ext(*schemaFile)) + "_gen.go"
+	}
+
+	source := filepath.Base(*schemaFile)
+
+	src, err := Generate(schema, source)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(out, src, 0o644); err != nil {
+		return err
+	}
+
+	if !*tests {
+		return nil
+	}
+
+	testSrc, err := GenerateTests(schema, source)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(strings.TrimSuffix(out, ".go")+"_test.go", testSrc, 0o644)
+}
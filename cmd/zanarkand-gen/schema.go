@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema describes the packet layouts to generate.
+type Schema struct {
+	Package string       `json:"package" yaml:"package"`
+	Types   []TypeSchema `json:"types" yaml:"types"`
+}
+
+// TypeSchema describes a single packet struct.
+type TypeSchema struct {
+	Name   string        `json:"name" yaml:"name"`
+	Doc    string        `json:"doc" yaml:"doc"`
+	Opcode string        `json:"opcode" yaml:"opcode"` // optional, decimal or 0x-prefixed hex
+	Size   int           `json:"size" yaml:"size"`     // optional, defaults to the extent of the fields
+	JSON   *bool         `json:"json" yaml:"json"`     // set false to skip MarshalJSON, e.g. for a hand-written one
+	Fields []FieldSchema `json:"fields" yaml:"fields"`
+
+	size   int
+	opcode uint16
+}
+
+// FieldSchema describes a single field of a packet struct.
+type FieldSchema struct {
+	Name   string `json:"name" yaml:"name"`
+	Doc    string `json:"doc" yaml:"doc"`
+	Offset int    `json:"offset" yaml:"offset"`
+	Type   string `json:"type" yaml:"type"`     // a scalar type, "string", or another type in the schema
+	Count  int    `json:"count" yaml:"count"`   // array length, or byte size for strings
+	Endian string `json:"endian" yaml:"endian"` // "little" (default) or "big"
+	JSON   string `json:"json" yaml:"json"`     // JSON field name, or "-" to omit; defaults to Name
+
+	size   int         // size of one element
+	nested *TypeSchema // set when Type names another schema type
+}
+
+// scalarSizes are the wire sizes of the supported scalar types.
+var scalarSizes = map[string]int{
+	"bool":    1,
+	"byte":    1,
+	"int8":    1,
+	"uint8":   1,
+	"int16":   2,
+	"uint16":  2,
+	"int32":   4,
+	"uint32":  4,
+	"float32": 4,
+	"int64":   8,
+	"uint64":  8,
+	"float64": 8,
+}
+
+// reservedFields are the names of the methods generated for every type, which a field
+// can't share.
+var reservedFields = map[string]struct{}{
+	"String":          {},
+	"MarshalJSON":     {},
+	"MarshalBinary":   {},
+	"UnmarshalBinary": {},
+	"Encode":          {},
+}
+
+// LoadSchema reads a schema from a .json, .yaml or .yml file.
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := new(Schema)
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, schema)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, schema)
+	default:
+		return nil, fmt.Errorf("unknown schema file extension for %s", path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("decoding schema %s: %w", path, err)
+	}
+
+	return schema, nil
+}
+
+// Validate checks the schema and computes the wire size of every type.
+// Types may refer to types declared earlier in the schema.
+func (s *Schema) Validate() error {
+	if !token.IsIdentifier(s.Package) {
+		return fmt.Errorf("invalid package name %q", s.Package)
+	}
+
+	types := make(map[string]*TypeSchema, len(s.Types))
+
+	for i := range s.Types {
+		t := &s.Types[i]
+
+		if !token.IsExported(t.Name) {
+			return fmt.Errorf("type name %q must be an exported identifier", t.Name)
+		}
+
+		if _, ok := types[t.Name]; ok {
+			return fmt.Errorf("duplicate type %s", t.Name)
+		}
+
+		if t.Opcode != "" {
+			op, err := strconv.ParseUint(t.Opcode, 0, 16)
+			if err != nil {
+				return fmt.Errorf("type %s: invalid opcode %q", t.Name, t.Opcode)
+			}
+			t.opcode = uint16(op)
+		}
+
+		if err := t.validate(types); err != nil {
+			return fmt.Errorf("type %s: %w", t.Name, err)
+		}
+
+		types[t.Name] = t
+	}
+
+	return nil
+}
+
+func (t *TypeSchema) validate(types map[string]*TypeSchema) error {
+	names := make(map[string]struct{}, len(t.Fields))
+	extent := 0
+
+	for i := range t.Fields {
+		f := &t.Fields[i]
+
+		if !token.IsExported(f.Name) {
+			return fmt.Errorf("field name %q must be an exported identifier", f.Name)
+		}
+
+		if _, ok := reservedFields[f.Name]; ok {
+			return fmt.Errorf("field %s: the name is taken by a generated method", f.Name)
+		}
+
+		if _, ok := names[f.Name]; ok {
+			return fmt.Errorf("duplicate field %s", f.Name)
+		}
+		names[f.Name] = struct{}{}
+
+		if f.Offset < 0 || f.Count < 0 {
+			return fmt.Errorf("field %s: negative offset or count", f.Name)
+		}
+
+		switch f.Endian {
+		case "", "little", "big":
+		default:
+			return fmt.Errorf("field %s: unknown endianness %q", f.Name, f.Endian)
+		}
+
+		switch {
+		case f.Type == "string":
+			if f.Count == 0 {
+				return fmt.Errorf("field %s: strings need a count of bytes", f.Name)
+			}
+			f.size = 1
+
+		case scalarSizes[f.Type] > 0:
+			f.size = scalarSizes[f.Type]
+
+		case types[f.Type] != nil:
+			f.nested = types[f.Type]
+			f.size = f.nested.size
+
+		default:
+			return fmt.Errorf("field %s: unknown type %q", f.Name, f.Type)
+		}
+
+		end := f.Offset + f.size*f.elements()
+		for _, other := range t.Fields[:i] {
+			if f.Offset < other.Offset+other.size*other.elements() && other.Offset < end {
+				return fmt.Errorf("field %s overlaps field %s", f.Name, other.Name)
+			}
+		}
+
+		if end > extent {
+			extent = end
+		}
+	}
+
+	t.size = extent
+	if t.Size > 0 {
+		if t.Size < extent {
+			return fmt.Errorf("size %d is smaller than the fields, which need %d bytes", t.Size, extent)
+		}
+		t.size = t.Size
+	}
+
+	return nil
+}
+
+// elements returns the number of elements the field occupies, counting string bytes.
+func (f *FieldSchema) elements() int {
+	if f.Count == 0 {
+		return 1
+	}
+	return f.Count
+}
+
+// isArray reports whether the field is a fixed array in Go.
+func (f *FieldSchema) isArray() bool {
+	return f.Type != "string" && f.Count > 0
+}
+
+// goType returns the Go type of the field.
+func (f *FieldSchema) goType() string {
+	if f.isArray() {
+		return fmt.Sprintf("[%d]%s", f.Count, f.Type)
+	}
+	return f.Type
+}
+
+// jsonName returns the JSON key of the field.
+func (f *FieldSchema) jsonName() string {
+	if f.JSON != "" {
+		return f.JSON
+	}
+	return f.Name
+}
+
+// generateJSON reports whether MarshalJSON should be generated for the type.
+func (t *TypeSchema) generateJSON() bool {
+	return t.JSON == nil || *t.JSON
+}
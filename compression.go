@@ -0,0 +1,114 @@
+package zanarkand
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Decompressor produces the decompressed body of a Frame.
+type Decompressor interface {
+	// Decompress returns a reader over the messages in the Frame body.
+	// The reader is closed once every message in the Frame has been read.
+	Decompress(frame *Frame) (io.ReadCloser, error)
+}
+
+// DecompressorFunc adapts an ordinary function to a Decompressor.
+type DecompressorFunc func(frame *Frame) (io.ReadCloser, error)
+
+// Decompress calls fn(frame).
+func (fn DecompressorFunc) Decompress(frame *Frame) (io.ReadCloser, error) {
+	return fn(frame)
+}
+
+// DecompressorRegistry maps Frame compression types to their Decompressor.
+// It is safe for concurrent use.
+type DecompressorRegistry struct {
+	mu sync.RWMutex
+	m  map[Compressor]Decompressor
+}
+
+// NewDecompressorRegistry returns a registry with the built-in None and ZLib decompressors.
+func NewDecompressorRegistry() *DecompressorRegistry {
+	r := &DecompressorRegistry{m: make(map[Compressor]Decompressor)}
+	r.Register(FrameCompressionNone, DecompressorFunc(decompressNone))
+	r.Register(FrameCompressionZlib, DecompressorFunc(decompressZlib))
+	return r
+}
+
+// Register sets the Decompressor for a compression type, replacing any existing one.
+// Registering a nil Decompressor removes the compression type.
+func (r *DecompressorRegistry) Register(c Compressor, d Decompressor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if d == nil {
+		delete(r.m, c)
+		return
+	}
+
+	r.m[c] = d
+}
+
+// Lookup returns the Decompressor for a compression type.
+func (r *DecompressorRegistry) Lookup(c Compressor) (Decompressor, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.m[c]
+	return d, ok
+}
+
+// DefaultDecompressors is the registry used by Sniffers unless WithDecompressors is given.
+var DefaultDecompressors = NewDecompressorRegistry()
+
+// RegisterDecompressor sets the Decompressor for a compression type in DefaultDecompressors.
+// This is the hook for Oodle support, which is not bundled:
+//
+//	zanarkand.RegisterDecompressor(zanarkand.FrameCompressionOodle, myOodleDecompressor)
+func RegisterDecompressor(c Compressor, d Decompressor) {
+	DefaultDecompressors.Register(c, d)
+}
+
+// WithDecompressors sets the registry used to decompress Frame bodies.
+// The default is DefaultDecompressors.
+func WithDecompressors(r *DecompressorRegistry) Option {
+	return func(c *snifferConfig) { c.decompressors = r }
+}
+
+func decompressNone(frame *Frame) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(frame.Body)), nil
+}
+
+// zlibPool holds zlib readers for reuse between frames.
+var zlibPool sync.Pool
+
+// pooledZlibReader returns its zlib reader to zlibPool when closed.
+type pooledZlibReader struct {
+	io.ReadCloser
+}
+
+func (z pooledZlibReader) Close() error {
+	err := z.ReadCloser.Close()
+	zlibPool.Put(z.ReadCloser)
+	return err
+}
+
+func decompressZlib(frame *Frame) (io.ReadCloser, error) {
+	z, _ := zlibPool.Get().(io.ReadCloser)
+	if z != nil {
+		if err := z.(zlib.Resetter).Reset(bytes.NewReader(frame.Body), nil); err != nil {
+			return nil, fmt.Errorf("error resetting ZLIB decoder: %w", err)
+		}
+	} else {
+		var err error
+		z, err = zlib.NewReader(bytes.NewReader(frame.Body))
+		if err != nil {
+			return nil, fmt.Errorf("error creating ZLIB decoder: %w", err)
+		}
+	}
+
+	return pooledZlibReader{z}, nil
+}
@@ -0,0 +1,103 @@
+package zanarkand
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gopacket/gopacket"
+)
+
+// ConnectionType is the kind of game server a connection is to, from the Frame headers.
+type ConnectionType int
+
+const (
+	ConnectionUnknown ConnectionType = iota
+	ConnectionLobby
+	ConnectionZone
+	ConnectionChat
+)
+
+// connectionType maps the Connection field of a Frame header to a ConnectionType.
+func connectionType(connection uint16) ConnectionType {
+	switch connection {
+	case 0:
+		return ConnectionLobby
+	case 1:
+		return ConnectionZone
+	case 2:
+		return ConnectionChat
+	default:
+		return ConnectionUnknown
+	}
+}
+
+func (c ConnectionType) String() string {
+	switch c {
+	case ConnectionLobby:
+		return "lobby"
+	case ConnectionZone:
+		return "zone"
+	case ConnectionChat:
+		return "chat"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnectionEventType is the kind of a ConnectionEvent.
+type ConnectionEventType int
+
+const (
+	// ConnectionOpened is sent when the first Frame of a connection is decoded, so only
+	// connections carrying game traffic are reported.
+	ConnectionOpened ConnectionEventType = iota + 1
+
+	// ConnectionClosed is sent once both directions of an opened connection are finished,
+	// after a FIN or RST, no packets for the WithIdleTimeout, or the Sniffer stopping.
+	ConnectionClosed
+)
+
+func (t ConnectionEventType) String() string {
+	switch t {
+	case ConnectionOpened:
+		return "opened"
+	case ConnectionClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnectionEvent describes a TCP connection carrying FFXIV Frames. Flow and Transport
+// are oriented from the side that sent the first packet captured, the client when the
+// handshake was seen. Bytes and Frames are totals for both directions so far.
+type ConnectionEvent struct {
+	Type       ConnectionEventType
+	StreamID   uint64
+	Connection ConnectionType
+	Flow       gopacket.Flow
+	Transport  gopacket.Flow
+	FirstSeen  time.Time
+	LastSeen   time.Time
+	Bytes      uint64
+	Frames     uint64
+}
+
+// String provides a string representation of a connection event.
+func (e ConnectionEvent) String() string {
+	return fmt.Sprintf("Connection %s - type: %s, flow: %s %s, bytes: %d, frames: %d",
+		e.Type, e.Connection, e.Flow, e.Transport, e.Bytes, e.Frames)
+}
+
+// WithConnectionBufferSize sets the buffer size for the channel returned by
+// Sniffer.Connections. Events are dropped if the buffer is full. The default is 16.
+func WithConnectionBufferSize(n int) Option {
+	return func(c *snifferConfig) { c.connBufSize = n }
+}
+
+// WithConnectionHandler sets a function called with every ConnectionEvent, in addition
+// to the Connections channel. It's called from the reassembly goroutines, so it should
+// return quickly.
+func WithConnectionHandler(fn func(ConnectionEvent)) Option {
+	return func(c *snifferConfig) { c.connHandler = fn }
+}
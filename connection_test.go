@@ -0,0 +1,130 @@
+package zanarkand
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+func TestSnifferConnections(t *testing.T) {
+	request := wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPong}, ID: 1})
+	response := wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: 1})
+
+	client := tcpPackets(t, testClientIP, testServerIP, 50000, 55021, request)
+	server := tcpReply(t, testServerIP, testClientIP, 55021, 50000, response)
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, 4)
+	start := time.Unix(1580625008, 0)
+
+	handled := make(chan ConnectionEvent, 2)
+	sniffer, err := NewSnifferFromHandle(handle, WithConnectionHandler(func(e ConnectionEvent) { handled <- e }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- sniffer.Start(context.Background()) }()
+
+	for i, p := range [][]byte{client[0], server[0], client[1], server[1]} {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{Timestamp: start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handle.Finish()
+
+	if err := <-done; err != io.EOF {
+		t.Fatalf("Expected io.EOF at the end of the capture, got %v", err)
+	}
+
+	next := func(ch <-chan ConnectionEvent) ConnectionEvent {
+		select {
+		case e := <-ch:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a connection event")
+			return ConnectionEvent{}
+		}
+	}
+
+	opened, closed := next(sniffer.Connections()), next(sniffer.Connections())
+
+	if opened.Type != ConnectionOpened || opened.Connection != ConnectionZone || opened.Frames != 1 {
+		t.Errorf("Unexpected opened event: %s", opened)
+	}
+
+	if closed.Type != ConnectionClosed || closed.Connection != ConnectionZone || closed.Frames != 2 {
+		t.Errorf("Unexpected closed event: %s", closed)
+	}
+
+	if closed.Bytes != uint64(len(request)+len(response)) {
+		t.Errorf("Expected %d bytes, got %d", len(request)+len(response), closed.Bytes)
+	}
+
+	if !closed.FirstSeen.Equal(start) || !closed.LastSeen.Equal(start.Add(3*time.Second)) {
+		t.Errorf("Unexpected timestamps: first %v, last %v", closed.FirstSeen, closed.LastSeen)
+	}
+
+	// Oriented from the client, which sent the SYN
+	if src, dst := closed.Flow.Endpoints(); src != layers.NewIPEndpoint(testClientIP) || dst != layers.NewIPEndpoint(testServerIP) {
+		t.Errorf("Expected the flow from the client, got %s", closed.Flow)
+	}
+
+	if e := next(handled); e != opened {
+		t.Errorf("Expected the handler to get %s, got %s", opened, e)
+	}
+
+	if e := next(handled); e != closed {
+		t.Errorf("Expected the handler to get %s, got %s", closed, e)
+	}
+}
+
+func TestSnifferIdleConnection(t *testing.T) {
+	request := wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPong}, ID: 1})
+	response := wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: 1})
+
+	client := tcpPackets(t, testClientIP, testServerIP, 50000, 55021, request)
+	server := tcpReply(t, testServerIP, testClientIP, 55021, 50000, response)
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, 4)
+	defer handle.Close()
+
+	sniffer, err := NewSnifferFromHandle(handle, WithIdleTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sniffer.Close()
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+
+	// The connection goes silent without a FIN or RST
+	start := time.Now()
+	for _, p := range [][]byte{client[0], server[0], client[1], server[1]} {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{Timestamp: start}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var events []ConnectionEvent
+	for len(events) < 2 {
+		select {
+		case e := <-sniffer.Connections():
+			events = append(events, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the idle connection to close, got %v", events)
+		}
+	}
+
+	if events[0].Type != ConnectionOpened || events[1].Type != ConnectionClosed || events[1].Frames != 2 {
+		t.Errorf("Expected the connection to open and close, got %v", events)
+	}
+
+	if status := sniffer.Status(); status != SnifferRunning {
+		t.Errorf("Expected the Sniffer to keep running, got %s", status)
+	}
+}
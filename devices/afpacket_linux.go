@@ -8,13 +8,12 @@ import (
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/afpacket"
 	"github.com/gopacket/gopacket/layers"
-	"github.com/gopacket/gopacket/pcap"
-	"golang.org/x/net/bpf"
 )
 
 // AFPacketHandle is an implementation of a gopacket PacketSource.
 type AFPacketHandle struct {
-	TPacket *afpacket.TPacket
+	TPacket  *afpacket.TPacket
+	linkType layers.LinkType
 }
 
 func newAFPacketHandle(device string, frameSize int, blockSize int, blockCount int, timeout time.Duration) (*AFPacketHandle, error) {
@@ -22,12 +21,17 @@ func newAFPacketHandle(device string, frameSize int, blockSize int, blockCount i
 	h := &AFPacketHandle{}
 
 	if device == "any" {
+		// The "any" pseudo-device mixes interfaces with different link layers,
+		// so have the kernel strip them and hand over bare IP packets
+		h.linkType = layers.LinkTypeRaw
 		h.TPacket, err = afpacket.NewTPacket(
+			afpacket.OptSocketType(afpacket.SocketDgram),
 			afpacket.OptFrameSize(frameSize),
 			afpacket.OptBlockSize(blockSize),
 			afpacket.OptNumBlocks(blockCount),
 			afpacket.OptPollTimeout(timeout))
 	} else {
+		h.linkType = interfaceLinkType(device)
 		h.TPacket, err = afpacket.NewTPacket(
 			afpacket.OptInterface(device),
 			afpacket.OptFrameSize(frameSize),
@@ -50,28 +54,33 @@ func (h *AFPacketHandle) ReadPacketData() (data []byte, ci gopacket.CaptureInfo,
 
 // SetBPFFilter is an implementation of a gopacket PacketSource's SetBPFFilter method.
 func (h *AFPacketHandle) SetBPFFilter(filter string, frameSize int) (_ error) {
-	pcapBPF, err := pcap.CompileBPFFilter(h.LinkType(), frameSize, filter)
+	instructions, err := compileBPF(h.LinkType(), frameSize, filter)
 	if err != nil {
 		return err
 	}
 
-	instructions := make([]bpf.RawInstruction, 0, len(pcapBPF))
-	for _, ins := range pcapBPF {
-		rawins := bpf.RawInstruction{
-			Op: ins.Code,
-			Jt: ins.Jt,
-			Jf: ins.Jf,
-			K:  ins.K,
-		}
-		instructions = append(instructions, rawins)
-	}
-
 	return h.TPacket.SetBPF(instructions)
 }
 
 // LinkType is an implementation of a gopacket PacketSource's LinkType method.
+// Packets from the "any" device are raw IP, as are those from interfaces without a
+// hardware address such as tun devices; everything else is Ethernet.
 func (h *AFPacketHandle) LinkType() layers.LinkType {
-	return layers.LinkTypeEthernet
+	return h.linkType
+}
+
+// CaptureStats returns the kernel's packet and drop counters for the socket.
+func (h *AFPacketHandle) CaptureStats() (CaptureStats, error) {
+	v2, v3, err := h.TPacket.SocketStats()
+	if err != nil {
+		return CaptureStats{}, err
+	}
+
+	// Only the counters for the socket's TPACKET version are set
+	return CaptureStats{
+		Received: uint64(v2.Packets() + v3.Packets()),
+		Dropped:  uint64(v2.Drops() + v3.Drops()),
+	}, nil
 }
 
 // Close is an implementation of a gopacket PacketSource's Close method.
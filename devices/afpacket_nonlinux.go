@@ -34,5 +34,10 @@ func (h *AFPacketHandle) LinkType() layers.LinkType {
 	return layers.LinkTypeEthernet
 }
 
+// CaptureStats is an implementation of StatsHandle's CaptureStats method.
+func (h *AFPacketHandle) CaptureStats() (CaptureStats, error) {
+	return CaptureStats{}, fmt.Errorf(af_nolinux)
+}
+
 // Close is an implementation of a gopacket PacketSource's Close method.
 func (h *AFPacketHandle) Close() {}
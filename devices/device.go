@@ -3,19 +3,28 @@ package devices
 import (
 	"errors"
 	"fmt"
+	"net"
 	"runtime"
 	"strconv"
 	"strings"
 
-	"github.com/gopacket/gopacket/pcap"
+	"github.com/gopacket/gopacket/layers"
 )
 
 var deviceAnySupported = runtime.GOOS == "linux"
 
+// device is an interface available for capture.
+type device struct {
+	name        string
+	description string
+	addresses   []string
+}
+
 // ListDeviceNames returns a list of available network adapters. The printDescription
 // parameter will include the adapter name and printIP will include the IP assigned to it.
+// Listing adapters needs libpcap, so it returns ErrNoLibpcap when built without cgo.
 func ListDeviceNames(printDescription, printIP bool) ([]string, error) {
-	devices, err := pcap.FindAllDevs()
+	devices, err := listDevices()
 	if err != nil {
 		return nil, err
 	}
@@ -24,35 +33,22 @@ func ListDeviceNames(printDescription, printIP bool) ([]string, error) {
 
 	for _, dev := range devices {
 		var b strings.Builder
-		b.WriteString(dev.Name)
+		b.WriteString(dev.name)
 
 		if printDescription {
 			desc := "No description available"
-			if len(dev.Description) > 0 {
-				desc = dev.Description
+			if len(dev.description) > 0 {
+				desc = dev.description
 			}
 
 			b.WriteString(": ")
 			b.WriteString(desc)
 		}
 
-		if printIP && len(dev.Addresses) > 0 {
-			var addresses strings.Builder
-			for i, address := range []pcap.InterfaceAddress(dev.Addresses) {
-				if i > 0 {
-					addresses.WriteByte(' ')
-				}
-
-				addresses.WriteString(address.IP.String())
-			}
-
-			if addresses.Len() == 0 {
-				b.WriteString(" [No assigned IP address]")
-			} else {
-				b.WriteString(" [")
-				b.WriteString(addresses.String())
-				b.WriteString("]")
-			}
+		if printIP && len(dev.addresses) > 0 {
+			b.WriteString(" [")
+			b.WriteString(strings.Join(dev.addresses, " "))
+			b.WriteString("]")
 		}
 
 		list = append(list, b.String())
@@ -89,3 +85,15 @@ func FindDeviceByName(name string) (string, error) {
 
 	return name, nil
 }
+
+// interfaceLinkType guesses the link layer of packets captured from a raw socket on an
+// interface. Layer 3 interfaces such as tun devices and WireGuard tunnels have no hardware
+// address and deliver bare IP packets; everything else, including Linux loopback, is Ethernet.
+func interfaceLinkType(device string) layers.LinkType {
+	iface, err := net.InterfaceByName(device)
+	if err != nil || len(iface.HardwareAddr) > 0 || iface.Flags&net.FlagLoopback != 0 {
+		return layers.LinkTypeEthernet
+	}
+
+	return layers.LinkTypeRaw
+}
@@ -1,14 +1,22 @@
 package devices
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"time"
 
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/layers"
-	"github.com/gopacket/gopacket/pcap"
 )
 
+// BlockForever is a read timeout that makes live handles wait for packets indefinitely.
+const BlockForever = -time.Millisecond * 10
+
+// ErrNoLibpcap is returned by libpcap backed handles and BPF expression compilation
+// when built without cgo.
+var ErrNoLibpcap = errors.New("libpcap support requires cgo")
+
 // DeviceHandle is an implementation of the gopacket PacketDataSource.
 // A custom handle is used so that we can stub out different device types
 // across platforms.
@@ -19,38 +27,50 @@ type DeviceHandle interface {
 	Close()
 }
 
-// OpenPcap opens a DeviceHandle for a live PCAP session on a given interface.
-func OpenPcap(device, filter string, timeout time.Duration) (*pcap.Handle, error) {
-	h, err := pcap.OpenLive(device, 1600, true, timeout)
-	if err != nil {
-		return nil, err
-	}
+// CaptureStats are the packet counters kept by the kernel or capture library for a live
+// handle, counted since the handle was opened.
+type CaptureStats struct {
+	Received  uint64 // packets seen by the capture
+	Dropped   uint64 // packets dropped because the capture buffer was full
+	IfDropped uint64 // packets dropped by the interface or its driver, where known
+}
 
-	err = h.SetBPFFilter(filter)
-	if err != nil {
-		h.Close()
-		return nil, err
-	}
+// StatsHandle is a DeviceHandle that can report CaptureStats.
+type StatsHandle interface {
+	DeviceHandle
 
-	return h, nil
+	CaptureStats() (CaptureStats, error)
 }
 
-// OpenFile opens a DeviceHandle for an offline PCAP session with a given input file.
-func OpenFile(file, filter string) (*pcap.Handle, error) {
-	h, err := pcap.OpenOffline(file)
-	if err != nil {
-		return nil, err
+// ErrNoStats is returned by HandleStats for handles without capture statistics, such as
+// offline files and MemoryHandles.
+var ErrNoStats = errors.New("capture statistics not available")
+
+// HandleStats returns the CaptureStats of a live pcap handle or a StatsHandle.
+func HandleStats(h DeviceHandle) (CaptureStats, error) {
+	if sh, ok := h.(StatsHandle); ok {
+		return sh.CaptureStats()
 	}
 
-	err = h.SetBPFFilter(filter)
-	if err != nil {
-		h.Close()
-		return nil, err
+	if stats, ok, err := libpcapStats(h); ok {
+		return stats, err
 	}
 
-	return h, nil
+	return CaptureStats{}, ErrNoStats
 }
 
+// ErrFilter indicates a BPF filter expression could not be compiled or applied to a handle.
+type ErrFilter struct {
+	Filter string
+	Err    error
+}
+
+func (e ErrFilter) Error() string {
+	return fmt.Sprintf("invalid BPF filter %q: %v", e.Filter, e.Err)
+}
+
+func (e ErrFilter) Unwrap() error { return e.Err }
+
 // OpenAFPacket opens a DeviceHandle for live capture via AF_Packet on a given interface.
 // The buffer size depends on system memory, with frame and block sizes calculated from
 // the size of the buffer, system page size, and a default snaplen of 1600. Generally
@@ -71,7 +91,7 @@ func OpenAFPacket(device, filter string, bufferSize int, timeout time.Duration)
 	err = h.SetBPFFilter(filter, frameSize)
 	if err != nil {
 		h.Close()
-		return nil, err
+		return nil, ErrFilter{Filter: filter, Err: err}
 	}
 
 	return h, nil
@@ -90,7 +110,7 @@ func OpenPFRing(device, filter string, snaplen uint32, timeout time.Duration) (*
 	err = h.SetBPFFilter(filter)
 	if err != nil {
 		h.Close()
-		return nil, err
+		return nil, ErrFilter{Filter: filter, Err: err}
 	}
 
 	return h, nil
@@ -0,0 +1,134 @@
+package devices
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// ErrHandleFinished is returned when writing to a MemoryHandle after Finish or Close.
+var ErrHandleFinished = errors.New("memory handle is finished")
+
+type memoryPacket struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+// MemoryHandle is a pure-Go DeviceHandle fed with packets by the caller, for tests and for
+// embedding zanarkand behind another capture source. Packets are queued on a channel and
+// read in order; once Finish is called and the queue drains, ReadPacketData returns io.EOF.
+// It is safe for concurrent use.
+type MemoryHandle struct {
+	linkType layers.LinkType
+	packets  chan memoryPacket
+
+	finished   chan struct{}
+	finishOnce sync.Once
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMemoryHandle returns an empty MemoryHandle for packets of the given link type,
+// buffering up to size packets before writes block.
+func NewMemoryHandle(linkType layers.LinkType, size int) *MemoryHandle {
+	return &MemoryHandle{
+		linkType: linkType,
+		packets:  make(chan memoryPacket, size),
+		finished: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// NewMemoryHandleFromPackets returns a finished MemoryHandle that replays raw packets of
+// the given link type, such as Ethernet frames, and then returns io.EOF.
+func NewMemoryHandleFromPackets(linkType layers.LinkType, packets ...[]byte) *MemoryHandle {
+	h := NewMemoryHandle(linkType, len(packets))
+	for _, p := range packets {
+		_ = h.WritePacketData(p, gopacket.CaptureInfo{})
+	}
+	h.Finish()
+
+	return h
+}
+
+// WritePacketData queues raw packet bytes. A zero Timestamp is replaced with the current
+// time, and zero lengths with the length of data. It blocks while the buffer is full.
+func (h *MemoryHandle) WritePacketData(data []byte, ci gopacket.CaptureInfo) error {
+	if ci.Timestamp.IsZero() {
+		ci.Timestamp = time.Now()
+	}
+	if ci.CaptureLength == 0 {
+		ci.CaptureLength = len(data)
+	}
+	if ci.Length == 0 {
+		ci.Length = len(data)
+	}
+
+	select {
+	case <-h.finished:
+		return ErrHandleFinished
+	case <-h.done:
+		return ErrHandleFinished
+	default:
+	}
+
+	// Finish and Close interrupt a write blocked on a full buffer
+	select {
+	case h.packets <- memoryPacket{data: data, ci: ci}:
+		return nil
+	case <-h.finished:
+		return ErrHandleFinished
+	case <-h.done:
+		return ErrHandleFinished
+	}
+}
+
+// WritePacket queues a decoded gopacket Packet, keeping its capture metadata.
+func (h *MemoryHandle) WritePacket(p gopacket.Packet) error {
+	return h.WritePacketData(p.Data(), p.Metadata().CaptureInfo)
+}
+
+// Finish marks the end of the packets. Packets already queued are still read,
+// after which ReadPacketData returns io.EOF. Writers blocked on a full buffer
+// return ErrHandleFinished.
+func (h *MemoryHandle) Finish() {
+	h.finishOnce.Do(func() { close(h.finished) })
+}
+
+// ReadPacketData is an implementation of a gopacket PacketSource's ReadPacketData method.
+// It blocks until a packet is written, and returns io.EOF once the handle is finished and
+// drained, or closed.
+func (h *MemoryHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	select {
+	case p := <-h.packets:
+		return p.data, p.ci, nil
+
+	case <-h.finished:
+		// Drain what was queued before Finish
+		select {
+		case p := <-h.packets:
+			return p.data, p.ci, nil
+		default:
+			return nil, gopacket.CaptureInfo{}, io.EOF
+		}
+
+	case <-h.done:
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+}
+
+// LinkType is an implementation of a gopacket PacketSource's LinkType method.
+func (h *MemoryHandle) LinkType() layers.LinkType {
+	return h.linkType
+}
+
+// Close is an implementation of a gopacket PacketSource's Close method.
+// Pending packets are discarded and blocked writers return ErrHandleFinished.
+func (h *MemoryHandle) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
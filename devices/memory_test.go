@@ -0,0 +1,101 @@
+package devices
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+func TestMemoryHandle(t *testing.T) {
+	h := NewMemoryHandleFromPackets(layers.LinkTypeEthernet, []byte{1, 2, 3}, []byte{4, 5})
+
+	data, ci, err := h.ReadPacketData()
+	if err != nil || !bytes.Equal(data, []byte{1, 2, 3}) {
+		t.Fatalf("Unexpected first packet %v: %v", data, err)
+	}
+
+	if ci.CaptureLength != 3 || ci.Length != 3 || ci.Timestamp.IsZero() {
+		t.Errorf("Expected capture info to be filled in, got %+v", ci)
+	}
+
+	if _, _, err := h.ReadPacketData(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := h.ReadPacketData(); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF once drained, got %v", err)
+	}
+
+	if err := h.WritePacketData([]byte{6}, gopacket.CaptureInfo{}); !errors.Is(err, ErrHandleFinished) {
+		t.Errorf("Expected ErrHandleFinished writing after Finish, got %v", err)
+	}
+}
+
+func TestMemoryHandleClose(t *testing.T) {
+	h := NewMemoryHandle(layers.LinkTypeEthernet, 0)
+
+	read := make(chan error, 1)
+	go func() {
+		_, _, err := h.ReadPacketData()
+		read <- err
+	}()
+
+	h.Close()
+
+	select {
+	case err := <-read:
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("Expected io.EOF after Close, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close didn't unblock ReadPacketData")
+	}
+
+	if err := h.WritePacketData([]byte{1}, gopacket.CaptureInfo{}); !errors.Is(err, ErrHandleFinished) {
+		t.Errorf("Expected ErrHandleFinished writing after Close, got %v", err)
+	}
+}
+
+func TestMemoryHandleFinishBlockedWriter(t *testing.T) {
+	h := NewMemoryHandle(layers.LinkTypeEthernet, 1)
+	if err := h.WritePacketData([]byte{1}, gopacket.CaptureInfo{}); err != nil {
+		t.Fatal(err)
+	}
+
+	write := make(chan error, 1)
+	go func() { write <- h.WritePacketData([]byte{2}, gopacket.CaptureInfo{}) }()
+
+	finished := make(chan struct{})
+	go func() {
+		h.Finish()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("Finish stalled behind a writer blocked on a full buffer")
+	}
+
+	select {
+	case err := <-write:
+		if !errors.Is(err, ErrHandleFinished) {
+			t.Errorf("Expected ErrHandleFinished for the blocked writer, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Finish didn't unblock the writer")
+	}
+
+	if data, _, err := h.ReadPacketData(); err != nil || !bytes.Equal(data, []byte{1}) {
+		t.Errorf("Expected the queued packet before io.EOF, got %v: %v", data, err)
+	}
+
+	if _, _, err := h.ReadPacketData(); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF once drained, got %v", err)
+	}
+}
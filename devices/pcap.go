@@ -0,0 +1,100 @@
+//go:build cgo
+
+package devices
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcap"
+	"golang.org/x/net/bpf"
+)
+
+// OpenPcap opens a DeviceHandle for a live PCAP session on a given interface. The handle
+// is a *pcap.Handle, for a type assertion where libpcap's own methods are needed.
+func OpenPcap(device, filter string, timeout time.Duration) (DeviceHandle, error) {
+	h, err := pcap.OpenLive(device, 1600, true, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.SetBPFFilter(filter)
+	if err != nil {
+		h.Close()
+		return nil, ErrFilter{Filter: filter, Err: err}
+	}
+
+	return h, nil
+}
+
+// OpenFile opens a DeviceHandle for an offline PCAP session with a given input file. The
+// handle is a *pcap.Handle, as for OpenPcap.
+func OpenFile(file, filter string) (DeviceHandle, error) {
+	h, err := pcap.OpenOffline(file)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.SetBPFFilter(filter)
+	if err != nil {
+		h.Close()
+		return nil, ErrFilter{Filter: filter, Err: err}
+	}
+
+	return h, nil
+}
+
+// listDevices returns the interfaces libpcap can capture on.
+func listDevices() ([]device, error) {
+	devs, err := pcap.FindAllDevs()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]device, 0, len(devs))
+	for _, dev := range devs {
+		d := device{name: dev.Name, description: dev.Description}
+		for _, address := range dev.Addresses {
+			d.addresses = append(d.addresses, address.IP.String())
+		}
+		list = append(list, d)
+	}
+
+	return list, nil
+}
+
+// libpcapStats returns the CaptureStats of a live pcap handle. The bool is false for
+// any other handle.
+func libpcapStats(h DeviceHandle) (CaptureStats, bool, error) {
+	ph, ok := h.(*pcap.Handle)
+	if !ok {
+		return CaptureStats{}, false, nil
+	}
+
+	stats, err := ph.Stats()
+	if err != nil {
+		return CaptureStats{}, true, fmt.Errorf("%w: %v", ErrNoStats, err)
+	}
+
+	return CaptureStats{
+		Received:  uint64(stats.PacketsReceived),
+		Dropped:   uint64(stats.PacketsDropped),
+		IfDropped: uint64(stats.PacketsIfDropped),
+	}, true, nil
+}
+
+// compileBPF compiles a BPF filter expression for a link type with libpcap's compiler.
+func compileBPF(linkType layers.LinkType, snaplen int, filter string) ([]bpf.RawInstruction, error) {
+	pcapBPF, err := pcap.CompileBPFFilter(linkType, snaplen, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	instructions := make([]bpf.RawInstruction, 0, len(pcapBPF))
+	for _, ins := range pcapBPF {
+		instructions = append(instructions, bpf.RawInstruction{Op: ins.Code, Jt: ins.Jt, Jf: ins.Jf, K: ins.K})
+	}
+
+	return instructions, nil
+}
@@ -0,0 +1,33 @@
+//go:build !cgo
+
+package devices
+
+import (
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+// OpenPcap is unavailable without cgo and always returns ErrNoLibpcap.
+func OpenPcap(device, filter string, timeout time.Duration) (DeviceHandle, error) {
+	return nil, ErrNoLibpcap
+}
+
+// OpenFile is unavailable without cgo and always returns ErrNoLibpcap.
+// Use OpenReader to read capture files in pure Go.
+func OpenFile(file, filter string) (DeviceHandle, error) {
+	return nil, ErrNoLibpcap
+}
+
+func listDevices() ([]device, error) {
+	return nil, ErrNoLibpcap
+}
+
+func libpcapStats(h DeviceHandle) (CaptureStats, bool, error) {
+	return CaptureStats{}, false, nil
+}
+
+func compileBPF(linkType layers.LinkType, snaplen int, filter string) ([]bpf.RawInstruction, error) {
+	return nil, ErrNoLibpcap
+}
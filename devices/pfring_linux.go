@@ -1,4 +1,4 @@
-//go:build linux
+//go:build linux && cgo
 
 package devices
 
@@ -12,7 +12,8 @@ import (
 
 // PFRingHandle is an implementation of a gopacket PacketSource.
 type PFRingHandle struct {
-	Ring *pfring.Ring
+	Ring     *pfring.Ring
+	linkType layers.LinkType
 }
 
 func newPFRingHandle(device string, snaplen uint32, timeout time.Duration) (*PFRingHandle, error) {
@@ -36,7 +37,7 @@ func newPFRingHandle(device string, snaplen uint32, timeout time.Duration) (*PFR
 		return nil, err
 	}
 
-	return &PFRingHandle{Ring: ring}, nil
+	return &PFRingHandle{Ring: ring, linkType: interfaceLinkType(device)}, nil
 }
 
 // ReadPacketData is an implementation of a gopacket PacketSource's ReadPacketData method.
@@ -50,8 +51,19 @@ func (h *PFRingHandle) SetBPFFilter(filter string) error {
 }
 
 // LinkType is an implementation of a gopacket PacketSource's LinkType method.
+// Packets from interfaces without a hardware address, such as tun devices, are raw IP.
 func (h *PFRingHandle) LinkType() layers.LinkType {
-	return layers.LinkTypeEthernet
+	return h.linkType
+}
+
+// CaptureStats returns the ring's packet and drop counters.
+func (h *PFRingHandle) CaptureStats() (CaptureStats, error) {
+	stats, err := h.Ring.Stats()
+	if err != nil {
+		return CaptureStats{}, err
+	}
+
+	return CaptureStats{Received: stats.Received, Dropped: stats.Dropped}, nil
 }
 
 // Close is an implementation of a gopacket PacketSource's Close method.
@@ -1,4 +1,4 @@
-//go:build !linux
+//go:build !linux || !cgo
 
 package devices
 
@@ -10,9 +10,9 @@ import (
 	"github.com/gopacket/gopacket/layers"
 )
 
-const pf_nolinux = "PF_RING handles are only available on Linux"
+const pf_nolinux = "PF_RING handles are only available on Linux with cgo"
 
-// PFRingHandle is a stub for non-Linux platforms.
+// PFRingHandle is a stub for non-Linux platforms and builds without cgo.
 type PFRingHandle struct{}
 
 func newPFRingHandle(device string, snaplen uint32, timeout time.Duration) (*PFRingHandle, error) {
@@ -34,5 +34,10 @@ func (h *PFRingHandle) LinkType() layers.LinkType {
 	return layers.LinkTypeEthernet
 }
 
+// CaptureStats is an implementation of StatsHandle's CaptureStats method.
+func (h *PFRingHandle) CaptureStats() (CaptureStats, error) {
+	return CaptureStats{}, fmt.Errorf(pf_nolinux)
+}
+
 // Close is an implementation of a gopacket PacketSource's Close method.
 func (h *PFRingHandle) Close() {}
@@ -0,0 +1,192 @@
+package devices
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcapgo"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/net/bpf"
+)
+
+// Magic numbers used to detect the capture format and compression of a reader.
+var (
+	magicGzip   = []byte{0x1F, 0x8B}
+	magicZstd   = []byte{0x28, 0xB5, 0x2F, 0xFD}
+	magicPcapNG = []byte{0x0A, 0x0D, 0x0D, 0x0A}
+	magicPcap   = [][]byte{
+		{0xD4, 0xC3, 0xB2, 0xA1}, // microseconds, little endian
+		{0xA1, 0xB2, 0xC3, 0xD4}, // microseconds, big endian
+		{0x4D, 0x3C, 0xB2, 0xA1}, // nanoseconds, little endian
+		{0xA1, 0xB2, 0x3C, 0x4D}, // nanoseconds, big endian
+	}
+)
+
+// packetReader is the common interface of the pcapgo readers.
+type packetReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// ReaderHandle is a pure-Go DeviceHandle reading a pcap or pcapng capture from an io.Reader,
+// such as stdin, an HTTP body, or embedded test data. Gzip and zstd compressed captures are
+// decompressed transparently.
+type ReaderHandle struct {
+	reader  packetReader
+	closers []io.Closer
+	match   func(data []byte) bool
+}
+
+// OpenReader opens a DeviceHandle for an offline capture read from r. The capture format
+// and compression are detected from the first bytes of the stream. If r is an io.Closer,
+// it is closed with the handle.
+//
+// A non-empty filter is compiled with libpcap's BPF compiler, which needs cgo, and run in a
+// pure-Go BPF virtual machine. Pass an empty filter and use SetBPFProgram or SetFilterFunc
+// to avoid libpcap entirely.
+func OpenReader(r io.Reader, filter string) (*ReaderHandle, error) {
+	h := new(ReaderHandle)
+	if c, ok := r.(io.Closer); ok {
+		h.closers = append(h.closers, c)
+	}
+
+	if err := h.open(r); err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	if filter != "" {
+		if err := h.SetBPFFilter(filter); err != nil {
+			h.Close()
+			return nil, ErrFilter{Filter: filter, Err: err}
+		}
+	}
+
+	return h, nil
+}
+
+func (h *ReaderHandle) open(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	// Compressed captures can only be wrapped once, a gzipped zstd stream isn't a capture
+	for decompressed := false; ; decompressed = true {
+		magic, err := br.Peek(4)
+		if err != nil {
+			return fmt.Errorf("reading capture header: %w", err)
+		}
+
+		switch {
+		case bytes.HasPrefix(magic, magicGzip) && !decompressed:
+			z, err := gzip.NewReader(br)
+			if err != nil {
+				return fmt.Errorf("opening gzip capture: %w", err)
+			}
+			h.closers = append(h.closers, z)
+			br = bufio.NewReader(z)
+
+		case bytes.Equal(magic, magicZstd) && !decompressed:
+			z, err := zstd.NewReader(br)
+			if err != nil {
+				return fmt.Errorf("opening zstd capture: %w", err)
+			}
+			h.closers = append(h.closers, z.IOReadCloser())
+			br = bufio.NewReader(z)
+
+		case bytes.Equal(magic, magicPcapNG):
+			h.reader, err = pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+			return err
+
+		case isPcapMagic(magic):
+			h.reader, err = pcapgo.NewReader(br)
+			return err
+
+		default:
+			return fmt.Errorf("unknown capture format with magic %X", magic)
+		}
+	}
+}
+
+func isPcapMagic(magic []byte) bool {
+	for _, m := range magicPcap {
+		if bytes.Equal(magic, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetBPFFilter compiles a BPF filter expression for the capture's link type and applies it
+// to every packet read. Compilation needs libpcap and returns ErrNoLibpcap without cgo;
+// the filter itself runs in pure Go.
+func (h *ReaderHandle) SetBPFFilter(filter string) error {
+	instructions, err := compileBPF(h.LinkType(), 65535, filter)
+	if err != nil {
+		return err
+	}
+
+	program, allDecoded := bpf.Disassemble(instructions)
+	if !allDecoded {
+		return fmt.Errorf("unsupported BPF instructions in filter")
+	}
+
+	return h.SetBPFProgram(program)
+}
+
+// SetBPFProgram applies an assembled BPF program to every packet read, replacing any
+// filter. Packets the program returns 0 for are skipped. It doesn't need libpcap.
+func (h *ReaderHandle) SetBPFProgram(program []bpf.Instruction) error {
+	vm, err := bpf.NewVM(program)
+	if err != nil {
+		return err
+	}
+
+	h.match = func(data []byte) bool {
+		n, err := vm.Run(data)
+		return err == nil && n > 0
+	}
+
+	return nil
+}
+
+// SetFilterFunc sets a function that decides whether a packet is returned, replacing any
+// BPF filter. A nil function accepts every packet.
+func (h *ReaderHandle) SetFilterFunc(match func(data []byte) bool) {
+	h.match = match
+}
+
+// ReadPacketData is an implementation of a gopacket PacketSource's ReadPacketData method.
+// It returns io.EOF once the capture is exhausted.
+func (h *ReaderHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		data, ci, err := h.reader.ReadPacketData()
+		if err != nil {
+			return nil, ci, err
+		}
+
+		if h.match == nil || h.match(data) {
+			return data, ci, nil
+		}
+	}
+}
+
+// LinkType is an implementation of a gopacket PacketSource's LinkType method.
+func (h *ReaderHandle) LinkType() layers.LinkType {
+	if h.reader == nil {
+		return layers.LinkTypeNull
+	}
+	return h.reader.LinkType()
+}
+
+// Close is an implementation of a gopacket PacketSource's Close method.
+func (h *ReaderHandle) Close() {
+	// Close decompressors before the underlying reader
+	for i := len(h.closers) - 1; i >= 0; i-- {
+		_ = h.closers[i].Close()
+	}
+	h.closers = nil
+}
@@ -0,0 +1,175 @@
+package devices
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcapgo"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/net/bpf"
+)
+
+func testCapture(t *testing.T, ng bool, packets ...[]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	ts := time.Unix(1580625008, 0)
+
+	if ng {
+		w, err := pcapgo.NewNgWriter(&buf, layers.LinkTypeEthernet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, p := range packets {
+			if err := w.WritePacket(gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(p), Length: len(p)}, p); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		return buf.Bytes()
+	}
+
+	w := pcapgo.NewWriter(&buf)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range packets {
+		if err := w.WritePacket(gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(p), Length: len(p)}, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func TestOpenReader(t *testing.T) {
+	packets := [][]byte{bytes.Repeat([]byte{1}, 60), bytes.Repeat([]byte{2}, 80), bytes.Repeat([]byte{3}, 100)}
+
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, _ = w.Write(data)
+		_ = w.Close()
+		return buf.Bytes()
+	}
+
+	zstded := func(data []byte) []byte {
+		w, _ := zstd.NewWriter(nil)
+		defer w.Close()
+		return w.EncodeAll(data, nil)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"pcap", testCapture(t, false, packets...)},
+		{"pcapng", testCapture(t, true, packets...)},
+		{"pcap.gz", gzipped(testCapture(t, false, packets...))},
+		{"pcapng.zst", zstded(testCapture(t, true, packets...))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := OpenReader(bytes.NewReader(tt.data), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer h.Close()
+
+			if h.LinkType() != layers.LinkTypeEthernet {
+				t.Errorf("Expected Ethernet link type, got %s", h.LinkType())
+			}
+
+			// Skip the second packet
+			h.SetFilterFunc(func(data []byte) bool { return data[0] != 2 })
+
+			var read [][]byte
+			for {
+				data, _, err := h.ReadPacketData()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				read = append(read, data)
+			}
+
+			if len(read) != 2 || !bytes.Equal(read[0], packets[0]) || !bytes.Equal(read[1], packets[2]) {
+				t.Errorf("Unexpected packets read: %v", read)
+			}
+		})
+	}
+
+	if _, err := OpenReader(bytes.NewReader([]byte("not a capture")), ""); err == nil {
+		t.Error("Expected an error for an unknown capture format")
+	}
+}
+
+func TestReaderHandleBPFProgram(t *testing.T) {
+	packets := [][]byte{bytes.Repeat([]byte{1}, 60), bytes.Repeat([]byte{2}, 80), bytes.Repeat([]byte{3}, 100)}
+
+	h, err := OpenReader(bytes.NewReader(testCapture(t, false, packets...)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	// Keep packets longer than 64 bytes
+	err = h.SetBPFProgram([]bpf.Instruction{
+		bpf.LoadExtension{Num: bpf.ExtLen},
+		bpf.JumpIf{Cond: bpf.JumpGreaterThan, Val: 64, SkipFalse: 1},
+		bpf.RetConstant{Val: 65535},
+		bpf.RetConstant{Val: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var read [][]byte
+	for {
+		data, _, err := h.ReadPacketData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = append(read, data)
+	}
+
+	if len(read) != 2 || !bytes.Equal(read[0], packets[1]) || !bytes.Equal(read[1], packets[2]) {
+		t.Errorf("Unexpected packets read: %v", read)
+	}
+}
+
+func TestReaderInvalidBPFFilter(t *testing.T) {
+	_, err := OpenReader(bytes.NewReader(testCapture(t, false, bytes.Repeat([]byte{1}, 60))), "tcp portrange nonsense")
+
+	var filterErr ErrFilter
+	if !errors.As(err, &filterErr) {
+		t.Fatalf("Expected an ErrFilter, got %v", err)
+	}
+
+	if filterErr.Filter != "tcp portrange nonsense" {
+		t.Errorf("Expected the filter in the error, got %q", filterErr.Filter)
+	}
+
+	// Without cgo the compiler is missing rather than failing, either way it's wrapped
+	if inner := errors.Unwrap(err); inner == nil || inner != filterErr.Err {
+		t.Errorf("Expected errors.Unwrap to reach the compile failure, got %v", inner)
+	}
+}
@@ -0,0 +1,140 @@
+package zanarkand
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// DirectionStrategy decides whether a Frame is inbound or outbound from its metadata.
+// It returns FrameIngress, FrameEgress, or 0 when it can't tell.
+type DirectionStrategy interface {
+	Direction(meta *FrameMeta) FlowDirection
+}
+
+// DirectionFunc adapts a function to a DirectionStrategy.
+type DirectionFunc func(meta *FrameMeta) FlowDirection
+
+// Direction calls fn(meta).
+func (fn DirectionFunc) Direction(meta *FrameMeta) FlowDirection {
+	return fn(meta)
+}
+
+// DefaultDirectionStrategy is used by Frames without a strategy from their Sniffer.
+// It checks the server ports in DefaultPortRanges, then falls back to private addresses.
+var DefaultDirectionStrategy DirectionStrategy = DirectionChain(PortDirection(), PrivateAddressDirection())
+
+// DirectionChain tries each strategy in order, returning the first decided direction.
+func DirectionChain(strategies ...DirectionStrategy) DirectionStrategy {
+	return DirectionFunc(func(meta *FrameMeta) FlowDirection {
+		for _, s := range strategies {
+			if d := s.Direction(meta); d != 0 {
+				return d
+			}
+		}
+		return 0
+	})
+}
+
+// PortDirection treats the side of the connection using a server port as the server.
+// Frames from a server port are ingress, frames to one are egress. With no ranges,
+// DefaultPortRanges is used.
+func PortDirection(ranges ...PortRange) DirectionStrategy {
+	if len(ranges) == 0 {
+		ranges = DefaultPortRanges
+	}
+	ranges = append([]PortRange(nil), ranges...)
+
+	isServer := func(e gopacket.Endpoint) bool {
+		port, ok := endpointPort(e)
+		if !ok {
+			return false
+		}
+
+		for _, r := range ranges {
+			if r.Contains(port) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return DirectionFunc(func(meta *FrameMeta) FlowDirection {
+		src, dst := meta.Transport.Endpoints()
+		return classify(isServer(dst), isServer(src))
+	})
+}
+
+// LocalAddressDirection treats the given addresses as the client's, such as the public
+// address of a VPS or the interface addresses of the capturing host. Frames to a local
+// address are ingress, frames from one are egress.
+func LocalAddressDirection(addrs ...netip.Addr) DirectionStrategy {
+	local := make(map[netip.Addr]struct{}, len(addrs))
+	for _, addr := range addrs {
+		local[addr.Unmap()] = struct{}{}
+	}
+
+	isLocal := func(e gopacket.Endpoint) bool {
+		addr := endpointAddr(e)
+		if !addr.IsValid() {
+			return false
+		}
+
+		_, ok := local[addr]
+		return ok
+	}
+
+	return DirectionFunc(func(meta *FrameMeta) FlowDirection {
+		src, dst := meta.Flow.Endpoints()
+		return classify(isLocal(src), isLocal(dst))
+	})
+}
+
+// InitiatorDirection treats the side that sent the first SYN as the client. It can only
+// decide for connections whose handshake was captured by the Sniffer.
+func InitiatorDirection() DirectionStrategy {
+	return DirectionFunc(func(meta *FrameMeta) FlowDirection {
+		if !meta.Handshake {
+			return 0
+		}
+
+		if meta.Initiator {
+			return FrameEgress
+		}
+		return FrameIngress
+	})
+}
+
+// PrivateAddressDirection treats private, loopback and link-local addresses as the
+// client's, see isPrivate. It can't decide when both or neither side is private.
+func PrivateAddressDirection() DirectionStrategy {
+	return DirectionFunc(func(meta *FrameMeta) FlowDirection {
+		src, dst := meta.Flow.Endpoints()
+		return classify(isPrivate(endpointAddr(src)), isPrivate(endpointAddr(dst)))
+	})
+}
+
+// classify returns the direction of a Frame given which of its ends are the client.
+func classify(srcClient, dstClient bool) FlowDirection {
+	// Check for inbound first since that's the majority
+	if dstClient && !srcClient {
+		return FrameIngress
+	}
+
+	if srcClient && !dstClient {
+		return FrameEgress
+	}
+
+	return 0
+}
+
+// endpointPort returns the port of a TCP flow endpoint.
+func endpointPort(e gopacket.Endpoint) (uint16, bool) {
+	if e.EndpointType() != layers.EndpointTCPPort || len(e.Raw()) != 2 {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint16(e.Raw()), true
+}
@@ -0,0 +1,120 @@
+package zanarkand
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+func testMeta(src, dst string, srcPort, dstPort uint16) *FrameMeta {
+	srcAddr, dstAddr := netip.MustParseAddr(src), netip.MustParseAddr(dst)
+
+	flow, _ := gopacket.FlowFromEndpoints(layers.NewIPEndpoint(srcAddr.AsSlice()), layers.NewIPEndpoint(dstAddr.AsSlice()))
+	transport, _ := gopacket.FlowFromEndpoints(layers.NewTCPPortEndpoint(layers.TCPPort(srcPort)), layers.NewTCPPortEndpoint(layers.TCPPort(dstPort)))
+
+	return &FrameMeta{Flow: flow, Transport: transport}
+}
+
+func TestDirectionStrategies(t *testing.T) {
+	vps := netip.MustParseAddr("198.51.100.20")
+
+	handshake := func(meta *FrameMeta, initiator bool) *FrameMeta {
+		meta.Handshake, meta.Initiator = true, initiator
+		return meta
+	}
+
+	tests := []struct {
+		name      string
+		strategy  DirectionStrategy
+		meta      *FrameMeta
+		direction FlowDirection
+	}{
+		{"port ingress", PortDirection(), testMeta("203.0.113.7", "198.51.100.20", 55021, 50000), FrameIngress},
+		{"port egress", PortDirection(), testMeta("198.51.100.20", "203.0.113.7", 50000, 55021), FrameEgress},
+		{"port unknown", PortDirection(), testMeta("198.51.100.20", "203.0.113.7", 50000, 443), 0},
+		{"port custom range", PortDirection(PortRange{Low: 7000, High: 7001}), testMeta("192.168.1.10", "192.168.1.2", 7001, 50000), FrameIngress},
+		{"local ingress", LocalAddressDirection(vps), testMeta("203.0.113.7", "198.51.100.20", 443, 50000), FrameIngress},
+		{"local egress", LocalAddressDirection(vps), testMeta("::ffff:198.51.100.20", "::ffff:203.0.113.7", 50000, 443), FrameEgress},
+		{"local unknown", LocalAddressDirection(vps), testMeta("203.0.113.7", "192.168.1.2", 443, 50000), 0},
+		{"initiator egress", InitiatorDirection(), handshake(testMeta("192.168.1.2", "192.168.1.10", 50000, 7000), true), FrameEgress},
+		{"initiator ingress", InitiatorDirection(), handshake(testMeta("192.168.1.10", "192.168.1.2", 7000, 50000), false), FrameIngress},
+		{"initiator unknown", InitiatorDirection(), testMeta("192.168.1.10", "192.168.1.2", 7000, 50000), 0},
+		{"private ingress", PrivateAddressDirection(), testMeta("203.0.113.7", "10.0.0.2", 443, 50000), FrameIngress},
+		{"private unknown", PrivateAddressDirection(), testMeta("192.168.1.10", "192.168.1.2", 7000, 50000), 0},
+		{"chain falls back", DirectionChain(PortDirection(), PrivateAddressDirection()), testMeta("203.0.113.7", "10.0.0.2", 443, 50000), FrameIngress},
+		{"chain prefers first", DirectionChain(PortDirection(), PrivateAddressDirection()), testMeta("10.0.0.2", "203.0.113.7", 55021, 50000), FrameIngress},
+		{"default public client", DefaultDirectionStrategy, testMeta("198.51.100.20", "203.0.113.7", 50000, 55021), FrameEgress},
+	}
+
+	for _, tt := range tests {
+		if d := tt.strategy.Direction(tt.meta); d != tt.direction {
+			t.Errorf("%s: expected direction %d, got %d", tt.name, tt.direction, d)
+		}
+	}
+}
+
+func TestSnifferInitiatorDirection(t *testing.T) {
+	clientIP := net.IP{198, 51, 100, 20}
+	request := wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPong}, ID: 1})
+	response := wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: 1})
+
+	// Both ends are public and the server isn't on a game port
+	client := tcpPackets(t, clientIP, testServerIP, 50000, 7000, request)
+	server := tcpReply(t, testServerIP, clientIP, 7000, 50000, response)
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, 8)
+	defer handle.Close()
+
+	sniffer, err := NewSnifferFromHandle(handle, WithDirectionStrategy(InitiatorDirection()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+	defer sniffer.Stop()
+
+	for !sniffer.IsActive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, p := range [][]byte{client[0], server[0], client[1], server[1]} {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frames := make(chan *Frame, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			frame, err := sniffer.NextFrame()
+			if err != nil {
+				return
+			}
+			frames <- frame
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case frame := <-frames:
+			want := FrameIngress
+			if src, _ := frame.Meta().Transport.Endpoints(); src == layers.NewTCPPortEndpoint(50000) {
+				want = FrameEgress
+			}
+
+			if !frame.Meta().Handshake || frame.Direction() != want {
+				t.Errorf("Expected direction %d for %s, got %d", want, frame.Meta().Transport, frame.Direction())
+			}
+
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a frame")
+		}
+	}
+}
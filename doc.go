@@ -1,9 +1,9 @@
 /*
 Package zanarkand is an FFXIV network packet capture and reassembly library.
 
-It reassembles TCP streams carrying FFXIV IPC traffic, decompresses ZLIB-encoded
-frame bodies, and dispatches decoded messages to subscribers via channels or
-callbacks. Capture sources include live interfaces (pcap, afpacket, pfring) and
+It reassembles TCP streams carrying FFXIV IPC traffic, decompresses frame bodies
+through a pluggable registry, and dispatches decoded messages to subscribers via
+channels or callbacks. Capture sources include live interfaces (pcap, afpacket, pfring) and
 offline pcap files.
 
 # Wire format
@@ -52,6 +52,20 @@ Keepalive message layout (segments 7/8):
 	│ Header   │(u32) │(u32) │
 	└──────────┴──────┴──────┘
 
+# Encoding
+
+Every decoded type has a MarshalBinary counterpart producing wire-exact bytes,
+so Decode(MarshalBinary(x)) round-trips. NewFrame bundles messages into a Frame
+with correct Length and Count fields and an optionally ZLIB-compressed body,
+which is handy for test fixtures, fuzz corpora and mock servers:
+
+	frame, err := zanarkand.NewFrame(1, zanarkand.FrameCompressionZlib, &zanarkand.GameEventMessage{
+		GenericHeader: zanarkand.GenericHeader{Segment: zanarkand.GameEvent},
+		Opcode:        0x0232,
+		Body:          payload,
+	})
+	wire, err := frame.MarshalBinary()
+
 # Quick start
 
 Create a Sniffer, attach a subscriber, and process frames:
@@ -89,6 +103,33 @@ Use a callback-style subscriber for lower overhead:
 	  "afpacket"— Linux AF_PACKET (Linux only)
 	  "pfring"  — ntop PF_RING (Linux only, requires C headers)
 
+Live captures and "file" compile their filter with libpcap, so they need cgo;
+without it they fail with devices.ErrNoLibpcap. Readers and handles below build
+and run with CGO_ENABLED=0.
+
+NewSnifferFromReader reads a pcap or pcapng capture from any io.Reader, such as
+stdin, an HTTP body or embedded test data, using pure-Go readers. Gzip and zstd
+compression is detected automatically, and the default port ranges are matched in
+pure Go:
+
+	f, _ := os.Open("session.pcapng.gz")
+	sniffer, err := zanarkand.NewSnifferFromReader(f)
+
+NewSnifferFromHandle accepts any devices.DeviceHandle. devices.MemoryHandle is
+fed packets directly, so a Sniffer and its subscribers can be exercised in tests
+without libpcap or privileges:
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, 64)
+	sniffer, err := zanarkand.NewSnifferFromHandle(handle)
+
+	handle.WritePacketData(ethernetFrame, gopacket.CaptureInfo{})
+	handle.Finish() // Start returns io.EOF once the queue drains
+
+Packets are decoded by the link type each handle reports: Ethernet (including
+802.1Q VLAN tags), Linux cooked SLL and SLL2 as captured on the "any" device,
+raw IP as seen on tun interfaces, and BSD loopback. Other link types fail with
+ErrUnsupportedLinkType when the Sniffer is created.
+
 # Sniffer lifecycle
 
 Sniffers are context-aware:
@@ -103,8 +144,75 @@ Sniffers are context-aware:
 
 	// Graceful stop
 	sniffer.Stop() // or cancel()
+	sniffer.Wait() // nil once stopped, io.EOF once a file is exhausted
+
+SnifferState tracks the lifecycle. A new Sniffer is SnifferStopped; Start moves it to
+SnifferRunning, Pause and Resume switch between SnifferRunning and SnifferPaused, and
+Stop returns it to SnifferStopped, ready to Start again. Offline captures end in
+SnifferFinished, and Close ends in SnifferClosed, closing the capture handle. Starting
+a Sniffer from any other state returns an ErrInvalidState.
+
+Stop and Close may be called more than once, and Stop before Start makes that Start
+return straight away. Restart stops a running Sniffer, waits for it, and starts it
+again in a new goroutine. Done returns a channel closed when the current run ends,
+and Wait returns its result. NextFrame may be called before Start, and returns
+io.EOF once the run has ended and its buffered Frames are read; ProcessFrames then
+returns nil.
+
+Nothing is read from the capture handle while the Sniffer is stopped. Packets
+captured meanwhile are left to the capture library: a live handle keeps what its
+buffer holds and hands it to the next run, which may then see the tail of
+connections it never saw open, and drops the rest. At most one packet read for a
+run that stopped first carries over to the next.
+
+ProcessFrames hands its FrameHandler a reader over one whole message, starting at
+its GenericHeader, so it can go straight to a GenericMessage's Decode. This is a
+breaking change: earlier versions had already read the GenericHeader, so handlers
+written for them should Discard its 16 bytes first.
+
+Subscriber Close methods stop the Sniffer and wait for Subscribe to return before
+closing their channels, so they never race a delivery. Subscribe on a closed
+subscriber returns ErrSubscriberClosed.
+
+# Pausing
+
+Pause holds Frames back from NextFrame, ProcessFrames and subscribers, for
+overlays that only care about some activities. Packets are still captured and
+reassembled, and Frame boundaries still tracked, so connections stay open and
+Resume carries on without gaps or resyncs. The PauseMode decides what happens to
+the Frames read meanwhile:
+
+	sniffer.Pause(zanarkand.PauseDiscard) // drop them
+	sniffer.Pause(zanarkand.PauseBuffer)  // keep up to WithPauseBuffer bytes
+	sniffer.Resume()                      // replay anything kept, in order
+
+Stats counts the Frames buffered and discarded while paused. Anything still
+buffered when the Sniffer stops is discarded. Connections stay open across a
+pause, but not across a Stop.
+
+# Connections
+
+Sniffer.Connections() receives a ConnectionEvent when a TCP connection carrying
+Frames opens, on its first decoded Frame, and when it closes after a FIN or RST,
+going idle for WithIdleTimeout (2 minutes by default), or the end of the capture.
+Events carry the flow, first and last packet timestamps, byte and Frame totals,
+and the ConnectionType (lobby, zone or chat) from the Frame headers, for
+detecting session boundaries, zone changes and logouts:
+
+	for e := range sniffer.Connections() {
+		if e.Type == zanarkand.ConnectionClosed && e.Connection == zanarkand.ConnectionZone {
+			log.Printf("left zone after %v", e.LastSeen.Sub(e.FirstSeen))
+		}
+	}
+
+Like Errors(), the channel drops events when full; WithConnectionHandler sets a
+callback that sees every event.
 
-SnifferState tracks the lifecycle: SnifferStopped → SnifferRunning → SnifferFinished (file mode).
+Frame.Meta() describes where a Frame came from: its network and transport
+flows, the StreamID of its connection (shared with ConnectionEvent), its byte
+Offset in the stream, and the capture timestamps of the first and last packets
+holding it. Comparing LastSeen with Frame.Timestamp gives the server to capture
+latency, and the timestamps locate a Frame's packets in a recording.
 
 # Configuration
 
@@ -115,12 +223,72 @@ Use functional options to tune buffer sizes:
 		zanarkand.WithErrorBufferSize(10),
 	)
 
+The capture filter defaults to the live game server port ranges. Replace or
+extend them for private servers, or supply a raw BPF expression:
+
+	sniffer, err := zanarkand.NewSniffer("pcap", "eth0",
+		zanarkand.WithExtraPortRanges(zanarkand.PortRange{Low: 7000, High: 7010}),
+	)
+
+	sniffer, err := zanarkand.NewSniffer("pcap", "eth0",
+		zanarkand.WithBPFFilter("tcp port 55023 and host 203.0.113.7"),
+	)
+
+Filters are compiled when the handle is opened; failures are returned as an
+ErrInvalidFilter wrapping the compiler error.
+
 For GameEvent subscribers, filter by opcode:
 
 	sub := zanarkand.NewGameEventSubscriber(
 		zanarkand.WithOpcodes(0x031F, 0x0232), // only status effects and actor cast
 	)
 
+Opcodes change with most patches, so rather than hardcoding them, load a
+community opcode list with the opcodes package and filter by name:
+
+	db, err := opcodes.LoadFile("opcodes.json")
+	table, _ := db.Table("Global", "") // latest version
+
+	sub := zanarkand.NewGameEventSubscriber(
+		zanarkand.WithOpcodeTable(table),
+		zanarkand.WithOpcodeNames("ActorCast", "StatusEffectList"),
+	)
+
+Names are looked up in the Zone or Chat list matching each Frame's connection
+type. Subscribe returns ErrNoOpcodeTable if names are given without a table.
+
+# Typed payloads
+
+Rather than hand-rolling binary.Read over GameEventMessage.Body, describe the
+payload layout with `ipc` struct tags and register it against its opcode:
+
+	type ActorCast struct {
+		ActionID uint16  `ipc:"0"`
+		CastTime float32 `ipc:"8"`
+		TargetID uint32  `ipc:"12"`
+	}
+
+	zanarkand.RegisterPayload(0x0232, ActorCast{})
+
+	for msg := range sub.IngressEvents {
+		v, err := msg.Decoded()
+		if cast, ok := v.(*ActorCast); ok {
+			fmt.Println(cast.ActionID)
+		}
+	}
+
+Offsets, array counts, fixed-size strings and endianness are all set with tags;
+see PayloadRegistry. Bodies too short for a field return an ErrNotEnoughData
+naming the field.
+
+For hot paths, cmd/zanarkand-gen generates structs with reflection-free
+UnmarshalBinary and MarshalBinary methods from a JSON or YAML schema of field
+offsets, so layouts that shift between patches are updated in one place:
+
+	//go:generate go run github.com/ayyaruq/zanarkand/cmd/zanarkand-gen -schema packets.json
+
+examples/craft_state uses it for its EventPlay structs.
+
 # Subscriber types
 
 All subscribers implement the Subscriber interface:
@@ -147,27 +315,173 @@ must outlive the callback.
 
 Subscribers auto-start the Sniffer if it is not already running.
 
+# Sharing a Sniffer
+
+Each subscriber above consumes frames directly from the Sniffer, so running two
+of them on one Sniffer splits the traffic between them. To deliver every message
+to several consumers, use a Broker. It reads each Frame once, decodes each message
+once, and fans the resulting Envelope out to every matching Subscription:
+
+	broker := zanarkand.NewBroker()
+
+	casts := broker.Add(
+		zanarkand.WithSegments(zanarkand.GameEvent),
+		zanarkand.WithDirection(zanarkand.FrameIngress),
+		zanarkand.WithMessageFilter(zanarkand.OpcodeFilter(0x0232)),
+	)
+	pings := broker.Add(zanarkand.WithSegments(zanarkand.ServerPing, zanarkand.ServerPong))
+
+	go broker.Subscribe(context.Background(), sniffer)
+
+	for e := range casts.Events {
+		msg := e.Message.(*zanarkand.GameEventMessage)
+		fmt.Printf("cast from actor %d\n", msg.SourceActor)
+	}
+
+Subscriptions have their own buffer, filters and BackpressurePolicy, and may be
+added or closed while the Broker is running. A blocking Subscription that falls
+behind holds up the rest; WithSubscriptionBackpressure lets it drop or spill
+instead. Envelopes are shared between Subscriptions and must not be modified.
+Messages that fail to decode are reported on Errors() and skipped.
+
+# Compression
+
+Frame bodies are decompressed through a DecompressorRegistry keyed by the
+Frame's Compressor. None and ZLib are built in; Oodle is not bundled, so
+plug in an implementation (or a fake for tests) before sniffing:
+
+	zanarkand.RegisterDecompressor(zanarkand.FrameCompressionOodle, oodle)
+
+Use WithDecompressors to give a Sniffer its own registry instead of
+DefaultDecompressors. Frames with an unregistered compression type are
+skipped, and an ErrUnsupportedCompression is reported on Sniffer.Errors().
+Frames a registered Decompressor fails on are skipped the same way, reporting
+an ErrDecodingFailure.
+
 # Direction inference
 
-Frame.Direction() infers ingress/egress by checking whether the source or
-destination IP falls within private address ranges (RFC 1918, loopback,
-link-local). Returns FrameIngress (1), FrameEgress (2), or 0 for undetermined.
+Frame.Direction() returns FrameIngress (1), FrameEgress (2), or 0 for
+undetermined, as decided by the Sniffer's DirectionStrategy. The built-in
+strategies are:
+
+	PortDirection           — the side using a server port is the server
+	LocalAddressDirection   — the given addresses are the client's
+	InitiatorDirection      — the side that sent the first SYN is the client
+	PrivateAddressDirection — private addresses are the client's
+
+By default a Sniffer checks its configured port ranges, then falls back to
+private addresses: RFC 1918, IPv6 unique local (fc00::/7), CGNAT shared space
+(100.64.0.0/10), loopback and link-local, with IPv4-mapped IPv6 addresses
+treated as IPv4. Use WithDirectionStrategy when the client has a public
+address or the server runs on the LAN:
+
+	sniffer, err := zanarkand.NewSniffer("afpacket", "eth0",
+		zanarkand.WithDirectionStrategy(zanarkand.DirectionChain(
+			zanarkand.LocalAddressDirection(netip.MustParseAddr("198.51.100.20")),
+			zanarkand.InitiatorDirection(),
+		)),
+	)
+
+# Recording
+
+WithRecording tees every packet that passes the capture filter to a pcap or
+pcapng file, for replaying sessions later with the "file" mode:
+
+	sniffer, err := zanarkand.NewSniffer("pcap", "eth0",
+		zanarkand.WithRecording("session.pcapng",
+			zanarkand.WithRotateSize(256<<20),
+			zanarkand.WithRotateInterval(time.Hour),
+		),
+	)
+
+Rotated files are numbered before the extension (session.1.pcapng, ...).
+pcapng recordings include the interface and filter, and each reassembly error
+is attached as a comment to the packet that caused it.
 
 # Error handling
 
-The package defines four typed errors, all implementing Unwrap() for use with
+The package defines typed errors, all implementing Unwrap() for use with
 errors.Is and errors.As:
 
-	ErrNotEnoughData    — payload shorter than declared length
-	ErrDecodingFailure  — a specific message could not be decoded
-	ErrUnknownInput     — unrecognised capture mode
-	ErrReassemblyError  — TCP stream reassembly problem
+	ErrNotEnoughData          — payload shorter than declared length
+	ErrDecodingFailure        — a specific message could not be decoded
+	ErrUnknownInput           — unrecognised capture mode
+	ErrReassemblyError        — TCP stream reassembly problem
+	ErrInvalidFilter          — capture filter could not be built or compiled
+	ErrUnsupportedCompression — no Decompressor registered for a Frame
+	ErrUnregisteredPayload    — no payload type registered for an opcode
+	ErrRecordingFailure       — captured packets could not be written to a recording
+	ErrUnsupportedLinkType    — no decoder for the capture handle's link type
+	ErrStreamGap              — bytes missing from a TCP stream
+	ErrInvalidFrameLength     — Frame header length too short or over WithMaxFrameSize
+	ErrStreamResync           — bytes skipped to find the next Frame in a stream
+	ErrDropped                — a Frame or message dropped by a BackpressurePolicy
+	ErrInvalidState           — a Sniffer lifecycle call its state doesn't allow
 
 Reassembly errors are reported on a buffered channel accessible via
 Sniffer.Errors(). Errors are dropped silently when the channel is full.
 
+TCP streams are reassembled with gopacket's reassembly package, tracking
+connection state and accepting packets regardless of checksum. Out-of-order
+segments are buffered up to the limits set by WithReassemblyBufferLimits; when
+data is lost, an ErrStreamGap carrying the flow and missing byte count is
+reported, the partial Frame is dropped, and decoding resumes at the next Frame.
+Frames may span any number of TCP segments. A Frame header whose length is
+shorter than the header or longer than WithMaxFrameSize (1 MiB by default) is
+reported as an ErrInvalidFrameLength and skipped. Whenever bytes have to be
+skipped to find the next Frame's magic, an ErrStreamResync reports the flow,
+the stream offset and the number of bytes skipped. A stream keeps decoding
+after all of these, and only stops once the connection is closed.
+
+# Backpressure
+
+By default a slow consumer stalls reassembly: once the data buffer is full,
+streams wait for NextFrame, and subscriber channels are unbuffered. Live tools
+that prefer losing data to falling behind can choose a BackpressurePolicy for
+the Sniffer, and a buffered channel and policy for each subscriber:
+
+	sniffer, err := zanarkand.NewSniffer("pcap", "eth0",
+		zanarkand.WithBackpressure(zanarkand.BackpressureDropOldest),
+	)
+
+	sub := zanarkand.NewGameEventSubscriber(
+		zanarkand.WithEventBackpressure(zanarkand.BackpressureSpill, 256),
+	)
+
+BackpressureDropNewest and BackpressureDropOldest discard values, and
+BackpressureSpill queues them in a temporary file, set by WithSpillQueue, and
+delivers them in order. Drops are counted in Stats or by the subscriber's
+Dropped method, and reported on Errors() as an ErrDropped.
+
+# Statistics
+
+Sniffer.Stats() returns a snapshot of the Sniffer's counters: packets seen and
+skipped, bytes reassembled and resynced, Frames decoded, decompression failures,
+errors and connection events dropped from full channels, the data buffer's
+occupancy and time spent blocked on it, Frames buffered and discarded while
+paused, and open streams. Live pcap, AF_PACKET
+and PF_RING handles add the kernel's receive and drop counters in Capture; other
+handles set CaptureErr to devices.ErrNoStats. The counters are atomic, so Stats
+can be polled from any goroutine.
+
+WithObserver sets an Observer notified of every message handed to a
+ProcessFrames handler, the decompressed size of each Frame, and every error
+reported on Errors(), dropped or not. The metrics subpackage uses it, along
+with Stats, to serve Prometheus metrics:
+
+	collector := metrics.NewCollector()
+	sniffer, err := zanarkand.NewSniffer("afpacket", "eth0", zanarkand.WithObserver(collector))
+	collector.Watch(sniffer)
+
+	http.Handle("/metrics", collector.Handler())
+
 # Debugging and profiling
 
+WithLogger sets a log/slog Logger for the Sniffer, its streams and subscribers.
+Records carry the stream ID, flows and stream offset, and the opcode where there
+is one: connections opening and closing are logged at Info, gaps, resyncs and
+decode failures at Warn, and new streams and dropped errors at Debug.
+
 Pass -assembly_debug_log to your binary for verbose per-packet assembly logging
 (gopacket built-in flag).
 
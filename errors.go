@@ -2,16 +2,25 @@ package zanarkand
 
 import (
 	"fmt"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
 )
 
 // ErrNotEnoughData occurs when the Length field is longer than the payload.
+// Field names the payload struct field being decoded, if any.
 type ErrNotEnoughData struct {
 	Expected int
 	Received int
+	Field    string
 	Err      error
 }
 
 func (e ErrNotEnoughData) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("not enough data for field %s: expected %d bytes but received %d", e.Field, e.Expected, e.Received)
+	}
+
 	return fmt.Sprintf("not enough data: expected %d bytes but received %d: %v", e.Expected, e.Received, e.Err)
 }
 
@@ -28,6 +37,16 @@ func (e ErrDecodingFailure) Error() string {
 
 func (e *ErrDecodingFailure) Unwrap() error { return e.Err }
 
+// ErrInvalidState indicates a Sniffer lifecycle method was called in a state that doesn't allow it.
+type ErrInvalidState struct {
+	Op    string
+	State SnifferState
+}
+
+func (e ErrInvalidState) Error() string {
+	return fmt.Sprintf("cannot %s a %s sniffer", e.Op, e.State)
+}
+
 // ErrUnknownInput indicates the provided mode for a sniffer is not a known type.
 type ErrUnknownInput struct {
 	Err error
@@ -49,3 +68,110 @@ func (e ErrReassemblyError) Error() string {
 }
 
 func (e *ErrReassemblyError) Unwrap() error { return e.Err }
+
+// ErrInvalidFilter indicates the capture filter could not be built or compiled.
+type ErrInvalidFilter struct {
+	Filter string
+	Err    error
+}
+
+func (e ErrInvalidFilter) Error() string {
+	return fmt.Sprintf("invalid capture filter %q: %v", e.Filter, e.Err)
+}
+
+func (e ErrInvalidFilter) Unwrap() error { return e.Err }
+
+// ErrUnsupportedCompression indicates a Frame uses a compression type with no registered Decompressor.
+type ErrUnsupportedCompression struct {
+	Compression Compressor
+}
+
+func (e ErrUnsupportedCompression) Error() string {
+	return fmt.Sprintf("unsupported frame compression: %s (%d)", e.Compression, uint8(e.Compression))
+}
+
+// ErrUnregisteredPayload indicates no payload type is registered for a GameEvent opcode.
+type ErrUnregisteredPayload struct {
+	Opcode uint16
+}
+
+func (e ErrUnregisteredPayload) Error() string {
+	return fmt.Sprintf("no payload type registered for opcode 0x%X", e.Opcode)
+}
+
+// ErrRecordingFailure indicates captured packets could not be written to a recording file.
+type ErrRecordingFailure struct {
+	Path string
+	Err  error
+}
+
+func (e ErrRecordingFailure) Error() string {
+	return fmt.Sprintf("recording to %s failed: %v", e.Path, e.Err)
+}
+
+func (e *ErrRecordingFailure) Unwrap() error { return e.Err }
+
+// ErrUnsupportedLinkType indicates a capture handle uses a link layer that can't be decoded.
+type ErrUnsupportedLinkType struct {
+	LinkType layers.LinkType
+}
+
+func (e ErrUnsupportedLinkType) Error() string {
+	return fmt.Sprintf("unsupported link type: %s (%d)", e.LinkType, uint16(e.LinkType))
+}
+
+// ErrStreamGap indicates bytes missing from a reassembled TCP stream, from packet loss in
+// the capture or a flush of out-of-order data. Any partial Frame is dropped and decoding
+// resumes at the next Frame.
+type ErrStreamGap struct {
+	Flow      gopacket.Flow
+	Transport gopacket.Flow
+	Bytes     int
+}
+
+func (e ErrStreamGap) Error() string {
+	return fmt.Sprintf("stream gap: %d bytes missing from %s %s", e.Bytes, e.Flow, e.Transport)
+}
+
+// ErrInvalidFrameLength indicates a Frame header with a length shorter than the header
+// itself or longer than the configured maximum. The bytes are skipped until the next Frame.
+type ErrInvalidFrameLength struct {
+	Length uint32
+	Max    int
+}
+
+func (e ErrInvalidFrameLength) Error() string {
+	return fmt.Sprintf("invalid frame length %d: must be between %d and %d bytes", e.Length, frameHeaderLength, e.Max)
+}
+
+// ErrStreamResync indicates bytes that weren't part of a Frame were skipped to find the
+// next one. Offset is the position of the first skipped byte in the direction's stream.
+type ErrStreamResync struct {
+	Flow      gopacket.Flow
+	Transport gopacket.Flow
+	Offset    int64
+	Skipped   int
+}
+
+func (e ErrStreamResync) Error() string {
+	return fmt.Sprintf("stream resync: skipped %d bytes at offset %d of %s %s", e.Skipped, e.Offset, e.Flow, e.Transport)
+}
+
+// ErrDropped indicates a Frame or message was dropped because its consumer fell behind,
+// under the BackpressurePolicy of the named queue. Err is set when a value couldn't be
+// spilled or read back from a spill queue.
+type ErrDropped struct {
+	Queue  string
+	Policy BackpressurePolicy
+	Err    error
+}
+
+func (e ErrDropped) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("dropped from %s queue (%s): %v", e.Queue, e.Policy, e.Err)
+	}
+
+	return fmt.Sprintf("dropped from full %s queue (%s)", e.Queue, e.Policy)
+}
+
+func (e *ErrDropped) Unwrap() error { return e.Err }
@@ -5,26 +5,6 @@ import (
 	"strconv"
 )
 
-// CraftState represents the Event data for updating the crafting window.
-type CraftState struct {
-	U1                uint32 `json:"-"` // junk from memcpy of other packets
-	U3                uint32 `json:"-"` // junk from memcpy of other packets
-	U4                uint32 `json:"-"` // junk from memcpy of other packets
-	ActionID          uint32 `json:"-"` // disable JSON so we can override it
-	U2                uint32 `json:"-"` // junk from memcpy of other packets
-	Step              uint32
-	Progress          uint32
-	ProgressDiff      int32
-	Quality           uint32
-	QualityDiff       int32
-	HQChance          uint32
-	Durability        uint32
-	DurabilityDiff    int32
-	CurrentCondition  uint32     `json:"-"` // disable JSON so we can override it
-	PreviousCondition uint32     `json:"-"` // disable JSON so we can override it
-	U6                [17]uint32 `json:"-"` // junk from memcpy of other packets
-}
-
 func (CraftState) isEventPlay32Data() {}
 
 // MarshalJSON will override the way Actions and Conditions are shown in JSON
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 )
@@ -11,18 +10,7 @@ var EventIDs = map[string]uint32{
 	"CraftState": 0xA0001,
 }
 
-// EventPlayHeader is the shared header for the different EventPlay Messages.
-type EventPlayHeader struct {
-	ActorID    uint64
-	EventID    uint32
-	Scene      uint16
-	Pad1       uint16 `json:"-"`
-	Flags      uint32
-	P1         uint32
-	ParamCount byte
-	Pad2       [3]byte `json:"-"`
-	P2         uint32
-}
+//go:generate go run ../../cmd/zanarkand-gen -schema packets.json -out packets_gen.go
 
 // EventPlay32 is the 32-byte variant of the EventPlay Messages.
 type EventPlay32 struct {
@@ -32,12 +20,11 @@ type EventPlay32 struct {
 
 // UnmarshalBytes will take a raw binary slice from a Message and unmarshal it into an EventPlay32 struct.
 func (e *EventPlay32) UnmarshalBytes(data []byte) error {
-	headerSize := binary.Size(e.EventPlayHeader)
-	if len(data) != (headerSize + 128) {
-		return fmt.Errorf("unexpected length: received %d, expected %d", len(data), headerSize+128)
+	if len(data) != (EventPlayHeaderSize + 128) {
+		return fmt.Errorf("unexpected length: received %d, expected %d", len(data), EventPlayHeaderSize+128)
 	}
 
-	err := binary.Read(bytes.NewReader(data[:headerSize]), binary.LittleEndian, &e.EventPlayHeader)
+	err := e.EventPlayHeader.UnmarshalBinary(data[:EventPlayHeaderSize])
 	if err != nil {
 		return err
 	}
@@ -45,7 +32,7 @@ func (e *EventPlay32) UnmarshalBytes(data []byte) error {
 	switch e.EventID {
 	case EventIDs["CraftState"]:
 		craftState := new(CraftState)
-		err := binary.Read(bytes.NewReader(data[headerSize:]), binary.LittleEndian, craftState)
+		err := craftState.UnmarshalBinary(data[EventPlayHeaderSize:])
 		if err != nil {
 			return err
 		}
@@ -53,9 +40,8 @@ func (e *EventPlay32) UnmarshalBytes(data []byte) error {
 
 	default:
 		event := new(GenericEventPlay32Data)
-		err := binary.Read(bytes.NewReader(data[headerSize:]), binary.LittleEndian, event)
-		if err != nil {
-			return err
+		for i := range event {
+			event[i] = binary.LittleEndian.Uint32(data[EventPlayHeaderSize+i*4:])
 		}
 		e.Data = event
 	}
@@ -10,9 +10,11 @@ import (
 	"syscall"
 
 	"github.com/ayyaruq/zanarkand"
+	"github.com/ayyaruq/zanarkand/opcodes"
 )
 
 // OpcodeEventPlay32 is the opcode we want to filter on, updated for patch 5.21 hotfix.
+// It's only used when no opcode file is given.
 const OpcodeEventPlay32 = 0x03AF
 
 func main() {
@@ -26,9 +28,36 @@ func fakeMain() int {
 
 	// Load inputs
 	var inet = flag.String("i", "en0", "The network interface to capture from")
+	var opcodeFile = flag.String("opcodes", "", "An opcode JSON or YAML file to look up EventPlay32 in")
+	var region = flag.String("region", "Global", "The game region to use from the opcode file")
 
 	flag.Parse()
 
+	// Find the opcode for the current patch
+	var opcodeEventPlay32 uint16 = OpcodeEventPlay32
+	if *opcodeFile != "" {
+		db, err := opcodes.LoadFile(*opcodeFile)
+		if err != nil {
+			log.Fatal(err)
+			return 1
+		}
+
+		table, ok := db.Table(*region, "")
+		if !ok {
+			log.Printf("No opcodes for region %s in %s", *region, *opcodeFile)
+			return 1
+		}
+
+		op, ok := table.Opcode(opcodes.Server, opcodes.Zone, "EventPlay32")
+		if !ok {
+			log.Printf("No EventPlay32 opcode for %s %s", table.Region, table.Version)
+			return 1
+		}
+
+		log.Printf("Using EventPlay32 opcode 0x%04X from %s %s", op, table.Region, table.Version)
+		opcodeEventPlay32 = op
+	}
+
 	// Setup the Sniffer
 	sniffer, err := zanarkand.NewSniffer("pcap", *inet)
 	if err != nil {
@@ -37,7 +66,7 @@ func fakeMain() int {
 	}
 
 	// Create our message receiver channel
-	subscriber := zanarkand.NewGameEventSubscriber()
+	subscriber := zanarkand.NewGameEventSubscriber(zanarkand.WithOpcodes(opcodeEventPlay32))
 
 	// Close when we're done
 	defer func(sniffer *zanarkand.Sniffer) {
@@ -59,7 +88,7 @@ func fakeMain() int {
 	for {
 		select {
 		case inbound := <-subscriber.IngressEvents:
-			if inbound.Opcode == OpcodeEventPlay32 {
+			if inbound.Opcode == opcodeEventPlay32 {
 				event := new(EventPlay32)
 				err := event.UnmarshalBytes(inbound.Body)
 				if err != nil {
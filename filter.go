@@ -0,0 +1,95 @@
+package zanarkand
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// PortRange is an inclusive range of TCP ports carrying FFXIV traffic.
+type PortRange struct {
+	Low  uint16
+	High uint16
+}
+
+// DefaultPortRanges are the server port ranges used by the live game servers.
+var DefaultPortRanges = []PortRange{
+	{Low: 54992, High: 54994},
+	{Low: 55006, High: 55007},
+	{Low: 55021, High: 55040},
+	{Low: 55296, High: 55551},
+}
+
+// Contains reports whether port falls within the range.
+func (r PortRange) Contains(port uint16) bool {
+	return port >= r.Low && port <= r.High
+}
+
+// String returns the range in BPF portrange notation.
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Low, r.High)
+}
+
+// WithPortRanges replaces the default capture port ranges.
+func WithPortRanges(ranges ...PortRange) Option {
+	return func(c *snifferConfig) { c.portRanges = append([]PortRange(nil), ranges...) }
+}
+
+// WithExtraPortRanges adds port ranges to capture in addition to the configured ones,
+// such as a private test server listening on a non-standard port.
+func WithExtraPortRanges(ranges ...PortRange) Option {
+	return func(c *snifferConfig) { c.portRanges = append(c.portRanges, ranges...) }
+}
+
+// WithBPFFilter sets a raw BPF filter expression, overriding any port ranges.
+// The expression is compiled when the capture handle is opened, and a failure
+// is returned from NewSniffer as an ErrInvalidFilter wrapping the compiler error.
+func WithBPFFilter(filter string) Option {
+	return func(c *snifferConfig) { c.bpfFilter = filter }
+}
+
+// buildFilter returns the BPF expression for the configured ranges, or the raw filter if one is set.
+func (c *snifferConfig) buildFilter() (string, error) {
+	if c.bpfFilter != "" {
+		return c.bpfFilter, nil
+	}
+
+	if len(c.portRanges) == 0 {
+		return "", ErrInvalidFilter{Err: fmt.Errorf("no port ranges configured")}
+	}
+
+	parts := make([]string, 0, len(c.portRanges))
+	for _, r := range c.portRanges {
+		if r.Low == 0 || r.Low > r.High {
+			return "", ErrInvalidFilter{Err: fmt.Errorf("invalid port range %s", r)}
+		}
+
+		parts = append(parts, "tcp portrange "+r.String())
+	}
+
+	return strings.Join(parts, " or "), nil
+}
+
+// portMatcher returns a pure-Go packet filter equivalent to the port range BPF expression.
+func (c *snifferConfig) portMatcher(decoder gopacket.Decoder) func(data []byte) bool {
+	ranges := append([]PortRange(nil), c.portRanges...)
+
+	return func(data []byte) bool {
+		packet := gopacket.NewPacket(data, decoder, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if !ok {
+			return false
+		}
+
+		for _, r := range ranges {
+			if r.Contains(uint16(tcp.SrcPort)) || r.Contains(uint16(tcp.DstPort)) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
@@ -0,0 +1,124 @@
+package zanarkand
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+func TestPortRangeOptions(t *testing.T) {
+	private := PortRange{Low: 7000, High: 7010}
+	extra := PortRange{Low: 9000, High: 9000}
+
+	tests := []struct {
+		name string
+		opts []Option
+		want []PortRange
+	}{
+		{"defaults", nil, DefaultPortRanges},
+		{"replaced", []Option{WithPortRanges(private)}, []PortRange{private}},
+		{"extra", []Option{WithExtraPortRanges(private)}, append(append([]PortRange(nil), DefaultPortRanges...), private)},
+		{"replaced then extra", []Option{WithPortRanges(private), WithExtraPortRanges(extra)}, []PortRange{private, extra}},
+		{"extra then replaced", []Option{WithExtraPortRanges(extra), WithPortRanges(private)}, []PortRange{private}},
+	}
+
+	for _, tt := range tests {
+		cfg := newSnifferConfig(tt.opts...)
+
+		if len(cfg.portRanges) != len(tt.want) {
+			t.Fatalf("%s: expected ranges %v, got %v", tt.name, tt.want, cfg.portRanges)
+		}
+		for i := range tt.want {
+			if cfg.portRanges[i] != tt.want[i] {
+				t.Errorf("%s: expected ranges %v, got %v", tt.name, tt.want, cfg.portRanges)
+			}
+		}
+	}
+
+	// Extending must not touch the defaults
+	_ = newSnifferConfig(WithExtraPortRanges(extra))
+	if DefaultPortRanges[len(DefaultPortRanges)-1] == extra {
+		t.Error("WithExtraPortRanges modified DefaultPortRanges")
+	}
+}
+
+func TestBuildFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		want    string
+		invalid bool
+	}{
+		{"defaults", nil, "tcp portrange 54992-54994 or tcp portrange 55006-55007 or tcp portrange 55021-55040 or tcp portrange 55296-55551", false},
+		{"single port", []Option{WithPortRanges(PortRange{Low: 7000, High: 7000})}, "tcp portrange 7000-7000", false},
+		{"raw filter", []Option{WithPortRanges(), WithBPFFilter("tcp port 55023")}, "tcp port 55023", false},
+		{"no ranges", []Option{WithPortRanges()}, "", true},
+		{"zero port", []Option{WithPortRanges(PortRange{Low: 0, High: 10})}, "", true},
+		{"reversed", []Option{WithPortRanges(PortRange{Low: 7010, High: 7000})}, "", true},
+		{"invalid extra", []Option{WithExtraPortRanges(PortRange{Low: 9, High: 1})}, "", true},
+	}
+
+	for _, tt := range tests {
+		cfg := newSnifferConfig(tt.opts...)
+		got, err := cfg.buildFilter()
+
+		var invalid ErrInvalidFilter
+		if tt.invalid {
+			if !errors.As(err, &invalid) {
+				t.Errorf("%s: expected an ErrInvalidFilter, got %q, %v", tt.name, got, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected filter %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestPortMatcher(t *testing.T) {
+	cfg := newSnifferConfig(WithPortRanges(PortRange{Low: 7000, High: 7010}))
+	match := cfg.portMatcher(layers.LinkTypeEthernet)
+
+	tests := []struct {
+		name   string
+		packet []byte
+		want   bool
+	}{
+		{"server port", tcpStream(t, 7005)[0], true},
+		{"client port", tcpPackets(t, testClientIP, testServerIP, 7010, 80)[0], true},
+		{"outside range", tcpStream(t, 7011)[0], false},
+		{"not TCP", bytes.Repeat([]byte{0}, 60), false},
+	}
+
+	for _, tt := range tests {
+		if got := match(tt.packet); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestInvalidBPFFilter(t *testing.T) {
+	capture := pcapFile(t, tcpStream(t, 55021))
+
+	_, err := NewSnifferFromReader(bytes.NewReader(capture), WithBPFFilter("tcp portrange nonsense"))
+
+	var invalid ErrInvalidFilter
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected an ErrInvalidFilter, got %v", err)
+	}
+
+	if invalid.Filter != "tcp portrange nonsense" {
+		t.Errorf("Expected the filter in the error, got %q", invalid.Filter)
+	}
+
+	// Without cgo the compiler is missing rather than failing, either way it's wrapped
+	if inner := errors.Unwrap(err); inner == nil || inner != invalid.Err {
+		t.Errorf("Expected errors.Unwrap to reach the compile failure, got %v", inner)
+	}
+}
@@ -3,10 +3,15 @@ package zanarkand
 
 import (
 	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"net"
+	"io"
+	"math"
+	"net/netip"
 	"time"
 
 	"github.com/gopacket/gopacket"
@@ -15,24 +20,14 @@ import (
 const frameHeaderLength = 40
 const frameMagicLE uint64 = 0xE2465DFF41A05252
 
-var (
-	privateBlock10  = mustParseCIDR("10.0.0.0/8")
-	privateBlock172 = mustParseCIDR("172.16.0.0/12")
-	privateBlock192 = mustParseCIDR("192.168.0.0/16")
-)
-
-func mustParseCIDR(s string) *net.IPNet {
-	_, ipnet, err := net.ParseCIDR(s)
-	if err != nil {
-		panic(err)
-	}
-	return ipnet
-}
+// Shared address space for carrier-grade NAT, RFC 6598
+var sharedBlock100 = netip.MustParsePrefix("100.64.0.0/10")
 
 const (
-	FrameCompressionNone  = 0
-	FrameCompressionZlib  = 1
-	FrameCompressionOodle = 2
+	FrameCompressionNone         = 0
+	FrameCompressionZlib         = 1
+	FrameCompressionOodle        = 2
+	FrameCompressionOodleTrained = 3
 )
 
 type Compressor uint8
@@ -50,7 +45,8 @@ type FlowDirection int
 // Frame is an FFXIV bundled message encapsulation layer.
 // Currently, bytes 4:7, 8:15, 32, and 34:39 are unknown.
 type Frame struct {
-	Magic       uint64     `json:"-"`              // [0:8] - mainly to verify magic bytes
+	Magic       uint64     `json:"-"` // [0:8] - mainly to verify magic bytes
+	reserved0   uint64     // [8:16]
 	Timestamp   time.Time  `json:"-"`              // [16:24] - timestamp in milliseconds since epoch
 	Length      uint32     `json:"size"`           // [24:28]
 	Connection  uint16     `json:"connectionType"` // [28:30] - 0 lobby, 1 zone, 2 chat
@@ -61,7 +57,8 @@ type Frame struct {
 	reserved3   uint16     // [38:40]
 	Body        []byte     `json:"-"`
 
-	meta FrameMeta
+	meta      FrameMeta
+	direction DirectionStrategy
 }
 
 func (c Compressor) String() string {
@@ -72,6 +69,8 @@ func (c Compressor) String() string {
 		return "ZLib"
 	case FrameCompressionOodle:
 		return "Oodle"
+	case FrameCompressionOodleTrained:
+		return "OodleTrained"
 	default:
 		return "Unknown"
 	}
@@ -79,7 +78,26 @@ func (c Compressor) String() string {
 
 // FrameMeta represents metadata from the IP and TCP layers on the Frame.
 type FrameMeta struct {
-	Flow gopacket.Flow
+	Flow      gopacket.Flow // network layer addresses
+	Transport gopacket.Flow // TCP ports
+
+	// StreamID identifies the TCP connection, and is shared by both of its directions.
+	// IDs are unique per Sniffer, and match ConnectionEvent.StreamID.
+	StreamID uint64
+
+	// Offset is the position of the Frame in its direction of the stream, counting
+	// from the first byte captured.
+	Offset int64
+
+	// FirstSeen and LastSeen are the capture timestamps of the first and last packets
+	// holding the Frame.
+	FirstSeen time.Time
+	LastSeen  time.Time
+
+	// Handshake is true when the SYN opening the connection was captured,
+	// and Initiator when that SYN was sent by the Frame's source.
+	Handshake bool
+	Initiator bool
 }
 
 // Decode a frame from byte data
@@ -101,29 +119,122 @@ func (f *Frame) Decode(p []byte) error {
 	f.Compression = Compressor(p[33])
 	f.Count = binary.LittleEndian.Uint16(p[30:32])
 
+	// Unknown fields, kept so the Frame can be encoded back to the same bytes
+	f.reserved0 = binary.LittleEndian.Uint64(p[8:16])
+	f.reserved1 = p[32]
+	f.reserved2 = binary.LittleEndian.Uint32(p[34:38])
+	f.reserved3 = binary.LittleEndian.Uint16(p[38:40])
+
+	if f.Length < frameHeaderLength {
+		return ErrInvalidFrameLength{Length: f.Length, Max: len(p)}
+	}
+
+	if int(f.Length) > len(p) {
+		return ErrNotEnoughData{Expected: int(f.Length), Received: len(p)}
+	}
+
 	f.Body = p[frameHeaderLength:f.Length]
 
 	return nil
 }
 
-// Direction outputs if the Frame is inbound or outbound.
-func (f *Frame) Direction() FlowDirection {
-	src, dst := f.meta.Flow.Endpoints()
-	srcIP := net.ParseIP(src.String())
-	dstIP := net.ParseIP(dst.String())
+// MarshalBinary encodes the Frame into wire format. The Length field is derived
+// from the Body, and a zero Magic is written as the FFXIV frame magic.
+func (f *Frame) MarshalBinary() ([]byte, error) {
+	if maxBody := int64(math.MaxUint32 - frameHeaderLength); int64(len(f.Body)) > maxBody {
+		return nil, fmt.Errorf("frame body of %d bytes: at most %d fit", len(f.Body), maxBody)
+	}
+
+	p := make([]byte, frameHeaderLength+len(f.Body))
+
+	magic := f.Magic
+	if magic == 0 {
+		magic = frameMagicLE
+	}
+
+	binary.LittleEndian.PutUint64(p[0:8], magic)
+	binary.LittleEndian.PutUint64(p[8:16], f.reserved0)
+
+	if !f.Timestamp.IsZero() {
+		binary.LittleEndian.PutUint64(p[16:24], uint64(f.Timestamp.UnixMilli()))
+	}
+
+	binary.LittleEndian.PutUint32(p[24:28], uint32(len(p)))
+	binary.LittleEndian.PutUint16(p[28:30], f.Connection)
+	binary.LittleEndian.PutUint16(p[30:32], f.Count)
+	p[32] = f.reserved1
+	p[33] = byte(f.Compression)
+	binary.LittleEndian.PutUint32(p[34:38], f.reserved2)
+	binary.LittleEndian.PutUint16(p[38:40], f.reserved3)
+
+	copy(p[frameHeaderLength:], f.Body)
+
+	return p, nil
+}
+
+// NewFrame builds a Frame carrying the encoded msgs, with the Body compressed as requested.
+// Only FrameCompressionNone and FrameCompressionZlib can be encoded. The Timestamp is set
+// to the current time with millisecond precision, as on the wire. A Frame holds at most
+// 65535 messages and 4 GiB, the limits of its Count and Length fields.
+func NewFrame(connection uint16, compression Compressor, msgs ...encoding.BinaryMarshaler) (*Frame, error) {
+	if len(msgs) > math.MaxUint16 {
+		return nil, fmt.Errorf("frame of %d messages: at most %d fit", len(msgs), math.MaxUint16)
+	}
 
-	// Check for inbound first since that's the majority
-	if isPrivate(dstIP) && !isPrivate(srcIP) {
-		return FrameIngress
+	var body bytes.Buffer
+
+	var w io.Writer = &body
+	var z *zlib.Writer
+
+	switch compression {
+	case FrameCompressionNone:
+	case FrameCompressionZlib:
+		z = zlib.NewWriter(&body)
+		w = z
+	default:
+		return nil, ErrUnsupportedCompression{Compression: compression}
+	}
+
+	for _, msg := range msgs {
+		data, err := msg.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
 	}
 
-	// Next up, outbound
-	if isPrivate(srcIP) && !isPrivate(dstIP) {
-		return FrameEgress
+	if z != nil {
+		if err := z.Close(); err != nil {
+			return nil, err
+		}
 	}
 
-	// If we get here, wtf is up with the src and dst
-	return 0
+	if maxBody := int64(math.MaxUint32 - frameHeaderLength); int64(body.Len()) > maxBody {
+		return nil, fmt.Errorf("frame body of %d bytes: at most %d fit", body.Len(), maxBody)
+	}
+
+	return &Frame{
+		Magic:       frameMagicLE,
+		Timestamp:   time.UnixMilli(time.Now().UnixMilli()),
+		Length:      uint32(frameHeaderLength + body.Len()),
+		Connection:  connection,
+		Count:       uint16(len(msgs)),
+		Compression: compression,
+		Body:        body.Bytes(),
+	}, nil
+}
+
+// Direction outputs if the Frame is inbound or outbound, using the DirectionStrategy of
+// the Sniffer that read it, or DefaultDirectionStrategy.
+func (f *Frame) Direction() FlowDirection {
+	if f.direction == nil {
+		return DefaultDirectionStrategy.Direction(&f.meta)
+	}
+
+	return f.direction.Direction(&f.meta)
 }
 
 // MarshalJSON provides an override for timestamp handling for encoding/JSON
@@ -158,27 +269,44 @@ func (f *Frame) String() string {
 		f.Magic, f.Timestamp.Unix(), f.Length, f.Count, f.Compression.String(), f.Connection)
 }
 
-func discardUntilValid(r *bufio.Reader) error {
+// discardUntilValid skips bytes until r is at the magic of a Frame, returning the
+// number of bytes skipped.
+func discardUntilValid(r *bufio.Reader) (int, error) {
+	skipped := 0
+
 	for {
 		header, err := r.Peek(8)
 		if err != nil {
-			return err
+			return skipped, err
 		}
 
 		if validateMagic(header) {
-			return nil
+			return skipped, nil
 		}
 
 		_, _ = r.Discard(1)
+		skipped++
 	}
 }
 
-func isPrivate(ip net.IP) bool {
+// endpointAddr converts an IPv4 or IPv6 flow endpoint to an address without going
+// through its string form. Other endpoints return the zero Addr.
+func endpointAddr(e gopacket.Endpoint) netip.Addr {
+	addr, _ := netip.AddrFromSlice(e.Raw())
+	return addr.Unmap()
+}
+
+// isPrivate reports whether ip is an address on the client's side of the connection:
+// RFC 1918 and IPv6 unique local (fc00::/7), CGNAT shared space, loopback or link-local.
+// IPv4-mapped IPv6 addresses are classified as their IPv4 address.
+func isPrivate(ip netip.Addr) bool {
+	ip = ip.Unmap()
+
 	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
 		return true
 	}
 
-	return privateBlock10.Contains(ip) || privateBlock172.Contains(ip) || privateBlock192.Contains(ip)
+	return ip.IsPrivate() || sharedBlock100.Contains(ip)
 }
 
 func validateMagic(header []byte) bool {
@@ -3,9 +3,13 @@ package zanarkand
 import (
 	"bufio"
 	"bytes"
+	"encoding"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"io"
-	"net"
+	"math"
+	"net/netip"
 	"testing"
 	"time"
 
@@ -72,6 +76,21 @@ func TestFrameDecode(t *testing.T) {
 	}
 }
 
+func TestFrameDecodeLength(t *testing.T) {
+	short := append([]byte(nil), zlibFrameTestBlob...)
+	binary.LittleEndian.PutUint32(short[24:28], 12)
+
+	var invalid ErrInvalidFrameLength
+	if err := new(Frame).Decode(short); !errors.As(err, &invalid) || invalid.Length != 12 {
+		t.Errorf("Expected ErrInvalidFrameLength for a 12 byte Frame, got %v", err)
+	}
+
+	var notEnough ErrNotEnoughData
+	if err := new(Frame).Decode(zlibFrameTestBlob[:60]); !errors.As(err, &notEnough) || notEnough.Expected != 92 {
+		t.Errorf("Expected ErrNotEnoughData for a truncated Frame, got %v", err)
+	}
+}
+
 func TestFrameMarshal(t *testing.T) {
 	var sentinel = `{"data":[120,156,51,96,96,96,40,139,80,19,88,51,69,81,128,25,200,22,97,112,101,100,96,96,101,216,116,43,62,6,200,101,136,217,200,192,192,97,242,130,217,95,212,129,17,196,7,0,205,193,8,40],"timestamp":1549785778,"size":92,"connectionType":0,"count":1,"compression":1}`
 
@@ -108,14 +127,21 @@ func TestFrameStringer(t *testing.T) {
 func TestFrameDiscard(t *testing.T) {
 	reader := bufio.NewReader(bytes.NewReader(headerTestBlob))
 
-	err := discardUntilValid(reader)
-	if err != nil {
-		t.Error("Expected no errors with discarding")
+	skipped, err := discardUntilValid(reader)
+	if err != nil || skipped != 0 {
+		t.Errorf("Expected no errors with discarding, skipped %d: %v", skipped, err)
+	}
+
+	reader = bufio.NewReader(bytes.NewReader(append([]byte{1, 2, 3}, headerTestBlob...)))
+
+	skipped, err = discardUntilValid(reader)
+	if err != nil || skipped != 3 {
+		t.Errorf("Expected to skip 3 bytes, skipped %d: %v", skipped, err)
 	}
 
 	reader = bufio.NewReader(bytes.NewReader(badJujuTestBlob))
 
-	err = discardUntilValid(reader)
+	_, err = discardUntilValid(reader)
 	if err != io.EOF {
 		t.Error("Unexpected error with discarding")
 	}
@@ -135,36 +161,160 @@ func TestFrameValidatePredicate(t *testing.T) {
 }
 
 func TestFlowDirection(t *testing.T) {
-	loopback := net.ParseIP("127.0.0.1")
-	private := net.ParseIP("192.168.1.100")
-	public := net.ParseIP("124.150.157.158")
-
-	if !isPrivate(loopback) {
-		t.Error("Expected loopback to be private")
+	tests := []struct {
+		addr    string
+		private bool
+	}{
+		{"127.0.0.1", true},
+		{"192.168.1.100", true},
+		{"10.1.2.3", true},
+		{"172.20.0.1", true},
+		{"169.254.10.1", true},
+		{"100.64.0.1", true},
+		{"100.127.255.254", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd12:3456:789a::1", true},
+		{"::ffff:192.168.1.100", true},
+		{"124.150.157.158", false},
+		{"100.128.0.1", false},
+		{"172.32.0.1", false},
+		{"2001:db8::1", false},
+		{"::ffff:124.150.157.158", false},
 	}
 
-	if !isPrivate(private) {
-		t.Error("Expected 192.168.1.100 to be private")
+	for _, tt := range tests {
+		if isPrivate(netip.MustParseAddr(tt.addr)) != tt.private {
+			t.Errorf("Expected isPrivate(%s) to be %v", tt.addr, tt.private)
+		}
 	}
 
-	if isPrivate(public) {
-		t.Error("Expected 124.150.157.158 to not be private")
+	flows := []struct {
+		src, dst  string
+		direction FlowDirection
+	}{
+		{"192.168.1.100", "124.150.157.158", FrameEgress},
+		{"124.150.157.158", "192.168.1.100", FrameIngress},
+		{"127.0.0.1", "192.168.1.100", 0},
+		{"2404:6800:4006::1", "fd00::20", FrameIngress},
+		{"fd00::20", "2404:6800:4006::1", FrameEgress},
+		{"124.150.157.158", "100.72.1.5", FrameIngress},
+		{"::ffff:124.150.157.158", "::ffff:10.0.0.2", FrameIngress},
+		{"2001:db8::1", "2404:6800:4006::1", 0},
 	}
 
 	f := new(Frame)
+	for _, tt := range flows {
+		src := layers.NewIPEndpoint(netip.MustParseAddr(tt.src).AsSlice())
+		dst := layers.NewIPEndpoint(netip.MustParseAddr(tt.dst).AsSlice())
+		f.meta.Flow, _ = gopacket.FlowFromEndpoints(src, dst)
+
+		if f.Direction() != tt.direction {
+			t.Errorf("Expected %s->%s to have direction %d, got %d", tt.src, tt.dst, tt.direction, f.Direction())
+		}
+	}
+}
+
+func TestFrameEncode(t *testing.T) {
+	frame := new(Frame)
+	if err := frame.Decode(zlibFrameTestBlob); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := frame.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(encoded, zlibFrameTestBlob) {
+		t.Errorf("Expected re-encoded frame to match the original bytes, got %v", encoded)
+	}
+}
+
+func TestNewFrameRoundTrip(t *testing.T) {
+	ping := &KeepaliveMessage{
+		GenericHeader: GenericHeader{SourceActor: 1, TargetActor: 2, Segment: ServerPing},
+		ID:            42,
+		Timestamp:     time.Unix(1485430850, 0),
+	}
+
+	event := &GameEventMessage{
+		GenericHeader: GenericHeader{SourceActor: 3, TargetActor: 4, Segment: GameEvent},
+		Opcode:        0x0232,
+		ServerID:      7,
+		Timestamp:     time.Unix(1580625008, 0),
+		Body:          []byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+
+	for _, compression := range []Compressor{FrameCompressionNone, FrameCompressionZlib} {
+		frame, err := NewFrame(1, compression, ping, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encoded, err := frame.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded := new(Frame)
+		if err := decoded.Decode(encoded); err != nil {
+			t.Fatal(err)
+		}
+
+		if int(decoded.Length) != len(encoded) || decoded.Length != frame.Length {
+			t.Errorf("%v: expected frame length %d, got %d", compression, len(encoded), decoded.Length)
+		}
+
+		if decoded.Count != 2 || decoded.Connection != 1 || decoded.Compression != compression {
+			t.Errorf("%v: unexpected decoded frame: %s", compression, decoded)
+		}
+
+		if !decoded.Timestamp.Equal(frame.Timestamp) {
+			t.Errorf("%v: expected timestamp %v, got %v", compression, frame.Timestamp, decoded.Timestamp)
+		}
+
+		var messages []GenericMessage
+		err = forEachMessage(decoded, DefaultDecompressors, func(_ *Frame, header *GenericHeader, r *bufio.Reader) error {
+			var msg GenericMessage = new(KeepaliveMessage)
+			if header.Segment == GameEvent {
+				msg = new(GameEventMessage)
+			}
+
+			messages = append(messages, msg)
+			return msg.Decode(r)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gotPing := messages[0].(*KeepaliveMessage)
+		if gotPing.ID != 42 || gotPing.Length != 24 || !gotPing.Timestamp.Equal(ping.Timestamp) {
+			t.Errorf("%v: unexpected keepalive: %s", compression, gotPing)
+		}
+
+		gotEvent := messages[1].(*GameEventMessage)
+		if gotEvent.Opcode != 0x0232 || gotEvent.Length != 40 || !bytes.Equal(gotEvent.Body, event.Body) {
+			t.Errorf("%v: unexpected game event: %s", compression, gotEvent)
+		}
+	}
+
+	if _, err := NewFrame(1, FrameCompressionOodle, ping); err == nil {
+		t.Error("Expected an error encoding an Oodle frame")
+	}
 
-	f.meta.Flow = gopacket.NewFlow(layers.EndpointIPv4, private, public)
-	if f.Direction() != FrameEgress {
-		t.Error("Expected 192.168.1.100->124.150.157.158 to be Egress")
+	// Count would wrap to 0
+	msgs := make([]encoding.BinaryMarshaler, math.MaxUint16+1)
+	for i := range msgs {
+		msgs[i] = ping
 	}
 
-	f.meta.Flow = gopacket.NewFlow(layers.EndpointIPv4, public, private)
-	if f.Direction() != FrameIngress {
-		t.Error("Expected 124.150.157.158->192.168.1.100 to be Ingress")
+	if _, err := NewFrame(1, FrameCompressionNone, msgs...); err == nil {
+		t.Error("Expected an error encoding more messages than Count holds")
 	}
 
-	f.meta.Flow = gopacket.NewFlow(layers.EndpointIPv4, loopback, private)
-	if f.Direction() != 0 {
-		t.Error("Expected local traffic to get funky")
+	frame, err := NewFrame(1, FrameCompressionNone, msgs[1:]...)
+	if err != nil || frame.Count != math.MaxUint16 {
+		t.Errorf("Expected a Frame of %d messages, got %v", math.MaxUint16, err)
 	}
 }
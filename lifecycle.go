@@ -0,0 +1,272 @@
+package zanarkand
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gopacket/gopacket"
+)
+
+// run is a single Start of a Sniffer. Done and Wait follow the current run, or the
+// next one if the Sniffer hasn't been started yet.
+type run struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started bool
+	done    chan struct{}
+	err     error
+}
+
+func newRun() *run {
+	return &run{cancel: func() {}, done: make(chan struct{})}
+}
+
+func (r *run) ended() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// begin moves a stopped Sniffer to running, returning the new run. A nil run with a nil
+// error means Stop was called first, and there's nothing to do.
+func (s *Sniffer) begin(ctx context.Context) (*run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != SnifferStopped {
+		return nil, ErrInvalidState{Op: "start", State: s.state}
+	}
+
+	r := s.run
+	if !r.started && r.ended() {
+		// Stopped before it was started
+		r.started = true
+		return nil, nil
+	}
+
+	if r.started {
+		r = newRun()
+		s.run = r
+	}
+
+	r.started = true
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	s.state = SnifferRunning
+
+	return r, nil
+}
+
+// end records the result of a run and wakes anything waiting on it.
+func (s *Sniffer) end(r *run, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != SnifferClosed {
+		s.state = SnifferStopped
+		if errors.Is(err, io.EOF) {
+			s.state = SnifferFinished
+		}
+	}
+
+	// Cancel first, so anything still sending the run's Frames gives up
+	r.cancel()
+	s.gate.reset()
+
+	r.err = err
+	close(r.done)
+}
+
+// Stop a running or paused Sniffer. It returns straight away; use Wait or Done to know
+// when the Sniffer has flushed its connections and Start has returned. The capture
+// handle isn't read again until the next Start. Stopping a Sniffer
+// that hasn't been started yet makes its first Start return nil without capturing.
+// Calling Stop more than once is a no-op.
+func (s *Sniffer) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stop()
+}
+
+// stop cancels the current run, or the first one if it hasn't started. s.mu must be held.
+func (s *Sniffer) stop() {
+	switch s.state {
+	case SnifferRunning, SnifferPaused:
+		s.run.cancel()
+
+	case SnifferStopped:
+		if r := s.run; !r.started && !r.ended() {
+			close(r.done)
+		}
+	}
+}
+
+// Restart stops a running or paused Sniffer, waits for it to flush, then starts it again
+// with ctx in a new goroutine. A stopped Sniffer is just started. Use Done or Wait to
+// follow the new run. Packets captured while stopped are left to the capture handle's
+// buffer, so the new run may read some from before it started. Finished and closed Sniffers can't be restarted, and return an
+// ErrInvalidState.
+func (s *Sniffer) Restart(ctx context.Context) error {
+	s.mu.RLock()
+	r, state := s.run, s.state
+	s.mu.RUnlock()
+
+	if state == SnifferRunning || state == SnifferPaused {
+		s.Stop()
+		<-r.done
+	}
+
+	next, err := s.begin(ctx)
+	if next == nil && err == nil {
+		// Stopped before it was first started, which begin has now consumed
+		next, err = s.begin(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	go func() { _ = s.capture(next) }()
+
+	return nil
+}
+
+// startIfStopped starts a stopped Sniffer with ctx in a new goroutine, for subscribers.
+func (s *Sniffer) startIfStopped(ctx context.Context) {
+	if r, _ := s.begin(ctx); r != nil {
+		go func() { _ = s.capture(r) }()
+	}
+}
+
+// Done returns a channel that's closed when the current run of the Sniffer ends, as Start
+// returns. Before the Sniffer is started, it's the channel for the first run.
+func (s *Sniffer) Done() <-chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.run.done
+}
+
+// Wait blocks until the current run of the Sniffer ends, and returns its result: nil once
+// stopped, or io.EOF once an offline capture is exhausted.
+func (s *Sniffer) Wait() error {
+	s.mu.RLock()
+	r := s.run
+	s.mu.RUnlock()
+
+	<-r.done
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return r.err
+}
+
+// Close stops the Sniffer, waits for it to flush, then closes the capture handle. A closed
+// Sniffer can't be started again. Calling Close more than once is a no-op.
+func (s *Sniffer) Close() {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.stop()
+		r := s.run
+		s.state = SnifferClosed
+		s.mu.Unlock()
+
+		<-r.done
+		s.data.shutdown()
+		s.handle.Close()
+		s.packets.close()
+	})
+}
+
+// packetReader reads packets from a Sniffer's source one at a time as each run asks for
+// them, so nothing is read from the handle while the Sniffer is stopped.
+type packetReader struct {
+	source  *gopacket.PacketSource
+	want    chan struct{}
+	out     chan gopacket.Packet // closed once the source is exhausted
+	done    chan struct{}        // closed with the Sniffer
+	once    sync.Once
+	stop    sync.Once
+	pending bool // a packet has been asked for but not received; only used by capture
+}
+
+func newPacketReader(source *gopacket.PacketSource) *packetReader {
+	return &packetReader{
+		source: source,
+		want:   make(chan struct{}, 1),
+		out:    make(chan gopacket.Packet),
+		done:   make(chan struct{}),
+	}
+}
+
+// next asks for the next packet, unless one is already on its way, and returns the
+// channel it's delivered on. A packet asked for by a run that stopped first goes to the
+// next run instead.
+func (p *packetReader) next() <-chan gopacket.Packet {
+	p.once.Do(func() { go p.read() })
+
+	if !p.pending {
+		p.pending = true
+		p.want <- struct{}{}
+	}
+
+	return p.out
+}
+
+// received records that the packet asked for by next has arrived.
+func (p *packetReader) received() {
+	p.pending = false
+}
+
+func (p *packetReader) close() {
+	p.stop.Do(func() { close(p.done) })
+}
+
+// read delivers a packet for each request, retrying errors the way gopacket's
+// PacketSource.Packets does, and closes out once the source is exhausted or closed.
+func (p *packetReader) read() {
+	for {
+		select {
+		case <-p.want:
+		case <-p.done:
+			return
+		}
+
+		for {
+			packet, err := p.source.NextPacket()
+			if err == nil {
+				select {
+				case p.out <- packet:
+				case <-p.done:
+					return
+				}
+				break
+			}
+
+			if packetSourceEnded(err) {
+				close(p.out)
+				return
+			}
+
+			// Timeouts and other errors are retried after a moment, unless the Sniffer closes
+			select {
+			case <-time.After(5 * time.Millisecond):
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
+
+// packetSourceEnded reports whether a read error means no more packets will come.
+func packetSourceEnded(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.ErrNoProgress) ||
+		errors.Is(err, io.ErrClosedPipe) || errors.Is(err, io.ErrShortBuffer) || errors.Is(err, syscall.EBADF) ||
+		strings.Contains(err.Error(), "use of closed file")
+}
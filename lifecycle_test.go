@@ -0,0 +1,425 @@
+package zanarkand
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+func pingFrame(t *testing.T, id uint32) []byte {
+	return wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: id})
+}
+
+func writePackets(t *testing.T, handle *devices.MemoryHandle, packets [][]byte) {
+	t.Helper()
+
+	for _, p := range packets {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// waitFrame reads a Frame with NextFrame, failing the test after a timeout.
+func waitFrame(t *testing.T, s *Sniffer) *Frame {
+	t.Helper()
+
+	frames := make(chan *Frame, 1)
+	errs := make(chan error, 1)
+	go func() {
+		frame, err := s.NextFrame()
+		if err != nil {
+			errs <- err
+			return
+		}
+		frames <- frame
+	}()
+
+	select {
+	case frame := <-frames:
+		return frame
+	case err := <-errs:
+		t.Fatalf("NextFrame failed: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a frame")
+	}
+
+	return nil
+}
+
+func waitState(t *testing.T, s *Sniffer, state SnifferState) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Status() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s, got %s", state, s.Status())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSnifferLifecycle(t *testing.T) {
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, 16)
+
+	sniffer, err := NewSnifferFromHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Safe before Start, and waits for the first run
+	next := make(chan *Frame, 1)
+	go func() {
+		if frame, err := sniffer.NextFrame(); err == nil {
+			next <- frame
+		}
+	}()
+
+	if err := sniffer.Resume(); !errors.As(err, new(ErrInvalidState)) {
+		t.Errorf("Expected an ErrInvalidState resuming a stopped Sniffer, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- sniffer.Start(context.Background()) }()
+	waitState(t, sniffer, SnifferRunning)
+
+	var invalid ErrInvalidState
+	if err := sniffer.Start(context.Background()); !errors.As(err, &invalid) || invalid.State != SnifferRunning {
+		t.Errorf("Expected an ErrInvalidState starting a running Sniffer, got %v", err)
+	}
+
+	// See pause_test.go for what happens to Frames meanwhile
+	if err := sniffer.Pause(PauseDiscard); err != nil {
+		t.Fatal(err)
+	}
+	if err := sniffer.Pause(PauseBuffer); err != nil {
+		t.Errorf("Expected pausing twice to switch modes, got %v", err)
+	}
+	if !sniffer.IsActive() || sniffer.Status() != SnifferPaused {
+		t.Errorf("Expected a paused Sniffer, got %s", sniffer.Status())
+	}
+
+	if err := sniffer.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sniffer.Resume(); err != nil {
+		t.Errorf("Expected resuming twice to be a no-op, got %v", err)
+	}
+
+	writePackets(t, handle, tcpStream(t, 55021, pingFrame(t, 1)))
+
+	select {
+	case frame := <-next:
+		if id := binary.LittleEndian.Uint32(frame.Body[16:20]); id != 1 {
+			t.Errorf("Expected ping 1, got %d", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a frame")
+	}
+
+	// Stop is idempotent, and ends the run for Done, Wait and NextFrame
+	sniffer.Stop()
+	sniffer.Stop()
+
+	select {
+	case <-sniffer.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Done")
+	}
+
+	if err := sniffer.Wait(); err != nil {
+		t.Errorf("Expected Wait to return nil once stopped, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Expected Start to return nil once stopped, got %v", err)
+	}
+	if _, err := sniffer.NextFrame(); err != io.EOF {
+		t.Errorf("Expected io.EOF from NextFrame once stopped, got %v", err)
+	}
+	if sniffer.Status() != SnifferStopped {
+		t.Errorf("Expected a stopped Sniffer, got %s", sniffer.Status())
+	}
+
+	// Restart picks up on a new connection
+	if err := sniffer.Restart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !sniffer.IsActive() {
+		t.Errorf("Expected a running Sniffer after Restart, got %s", sniffer.Status())
+	}
+
+	writePackets(t, handle, tcpStream(t, 55022, pingFrame(t, 2)))
+	if id := binary.LittleEndian.Uint32(waitFrame(t, sniffer).Body[16:20]); id != 2 {
+		t.Errorf("Expected ping 2, got %d", id)
+	}
+
+	// Restarting a running Sniffer ends its run first
+	first := sniffer.Done()
+	if err := sniffer.Restart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-first:
+	default:
+		t.Error("Expected Restart to end the previous run")
+	}
+
+	sniffer.Close()
+	sniffer.Close()
+
+	if sniffer.Status() != SnifferClosed {
+		t.Errorf("Expected a closed Sniffer, got %s", sniffer.Status())
+	}
+	if err := sniffer.Start(context.Background()); !errors.As(err, &invalid) || invalid.State != SnifferClosed {
+		t.Errorf("Expected an ErrInvalidState starting a closed Sniffer, got %v", err)
+	}
+	if _, _, err := handle.ReadPacketData(); err != io.EOF {
+		t.Errorf("Expected Close to close the handle, got %v", err)
+	}
+}
+
+func TestSnifferStopBeforeStart(t *testing.T) {
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, 1)
+	defer handle.Close()
+
+	sniffer, err := NewSnifferFromHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sniffer.Stop()
+
+	select {
+	case <-sniffer.Done():
+	default:
+		t.Error("Expected Stop to end the first run")
+	}
+
+	if err := sniffer.Start(context.Background()); err != nil {
+		t.Errorf("Expected Start to return nil after Stop, got %v", err)
+	}
+	if sniffer.Status() != SnifferStopped {
+		t.Errorf("Expected a stopped Sniffer, got %s", sniffer.Status())
+	}
+
+	// The next Start captures as usual
+	done := make(chan error, 1)
+	go func() { done <- sniffer.Start(context.Background()) }()
+	waitState(t, sniffer, SnifferRunning)
+
+	sniffer.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Expected Start to return nil once stopped, got %v", err)
+	}
+}
+
+func TestSnifferWaitFinished(t *testing.T) {
+	packets := tcpStream(t, 55021, pingFrame(t, 1), pingFrame(t, 2))
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, len(packets))
+	defer handle.Close()
+
+	writePackets(t, handle, packets)
+	handle.Finish()
+
+	sniffer, err := NewSnifferFromHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sniffer.Restart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sniffer.Wait(); err != io.EOF {
+		t.Errorf("Expected Wait to return io.EOF, got %v", err)
+	}
+	if sniffer.Status() != SnifferFinished {
+		t.Errorf("Expected a finished Sniffer, got %s", sniffer.Status())
+	}
+
+	var invalid ErrInvalidState
+	if err := sniffer.Restart(context.Background()); !errors.As(err, &invalid) || invalid.State != SnifferFinished {
+		t.Errorf("Expected an ErrInvalidState restarting a finished Sniffer, got %v", err)
+	}
+
+	// Frames buffered before the end are still handed over
+	count := 0
+	err = sniffer.ProcessFrames(func(*Frame, *GenericHeader, *bufio.Reader) error {
+		count++
+		return nil
+	})
+	if err != nil || count != 2 {
+		t.Errorf("Expected 2 messages and no error, got %d and %v", count, err)
+	}
+}
+
+func TestSubscriberCloseWhileSending(t *testing.T) {
+	packets := tcpStream(t, 55021, pingFrame(t, 1), pingFrame(t, 2), pingFrame(t, 3))
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, len(packets))
+	defer handle.Close()
+
+	sniffer, err := NewSnifferFromHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing reads the unbuffered channel, so Subscribe blocks on the first ping
+	sub := NewKeepaliveSubscriber()
+	done := make(chan error, 1)
+	go func() { done <- sub.Subscribe(context.Background(), sniffer) }()
+
+	waitState(t, sniffer, SnifferRunning)
+	writePackets(t, handle, packets)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sniffer.Stats().FramesDecoded == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for a frame")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	sub.Close(sniffer)
+	sub.Close(sniffer)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Subscribe to return nil once closed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Subscribe to return")
+	}
+
+	if _, ok := <-sub.Events; ok {
+		t.Error("Expected the Events channel to be closed")
+	}
+
+	if err := sub.Subscribe(context.Background(), sniffer); !errors.Is(err, ErrSubscriberClosed) {
+		t.Errorf("Expected ErrSubscriberClosed, got %v", err)
+	}
+
+	// Closing before Subscribe starts the Sniffer doesn't block either
+	events := NewGameEventSubscriber()
+	events.Close(sniffer)
+	if err := events.Subscribe(context.Background(), sniffer); !errors.Is(err, ErrSubscriberClosed) {
+		t.Errorf("Expected ErrSubscriberClosed, got %v", err)
+	}
+}
+
+func TestSnifferStopWithoutReader(t *testing.T) {
+	var frames [][]byte
+	for i := uint32(1); i <= 8; i++ {
+		frames = append(frames, pingFrame(t, i))
+	}
+
+	for _, stop := range []string{"Stop", "Close"} {
+		t.Run(stop, func(t *testing.T) {
+			handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, 16)
+
+			sniffer, err := NewSnifferFromHandle(handle, WithDataBufferSize(1), WithErrorBufferSize(16))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			go func() { _ = sniffer.Start(context.Background()) }()
+			waitState(t, sniffer, SnifferRunning)
+
+			// Nothing reads the Frames, so reassembly blocks on the full buffer
+			writePackets(t, handle, tcpStream(t, 55021, frames...))
+			for sniffer.Stats().DataQueued == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			stopped := make(chan struct{})
+			go func() {
+				if stop == "Stop" {
+					sniffer.Stop()
+					_ = sniffer.Wait()
+				} else {
+					sniffer.Close()
+				}
+				close(stopped)
+			}()
+
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s blocked on Frames nobody reads", stop)
+			}
+
+			// Every stream has finished, so nothing from this run can reach the next
+			if open := sniffer.Stats().OpenStreams; open != 0 {
+				t.Errorf("Expected no open streams once stopped, got %d", open)
+			}
+
+			sniffer.Close()
+		})
+	}
+}
+
+// countingHandle counts the packets read from a MemoryHandle.
+type countingHandle struct {
+	*devices.MemoryHandle
+	reads atomic.Int64
+}
+
+func (h *countingHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := h.MemoryHandle.ReadPacketData()
+	if err == nil {
+		h.reads.Add(1)
+	}
+	return data, ci, err
+}
+
+func TestSnifferStoppedDoesNotRead(t *testing.T) {
+	handle := &countingHandle{MemoryHandle: devices.NewMemoryHandle(layers.LinkTypeEthernet, 16)}
+
+	sniffer, err := NewSnifferFromHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sniffer.Close()
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+	waitState(t, sniffer, SnifferRunning)
+
+	writePackets(t, handle.MemoryHandle, tcpStream(t, 55021, pingFrame(t, 1)))
+	if id := binary.LittleEndian.Uint32(waitFrame(t, sniffer).Body[16:20]); id != 1 {
+		t.Errorf("Expected ping 1, got %d", id)
+	}
+
+	sniffer.Stop()
+	_ = sniffer.Wait()
+
+	// Only the packet the last run asked for may be read while stopped
+	read := handle.reads.Load()
+	packets := tcpStream(t, 55022, pingFrame(t, 2))
+	writePackets(t, handle.MemoryHandle, packets)
+	time.Sleep(50 * time.Millisecond)
+
+	if got := handle.reads.Load() - read; got > 1 {
+		t.Errorf("Expected at most 1 of %d packets read while stopped, got %d", len(packets), got)
+	}
+
+	// The next run picks the rest up from the handle
+	if err := sniffer.Restart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if id := binary.LittleEndian.Uint32(waitFrame(t, sniffer).Body[16:20]); id != 2 {
+		t.Errorf("Expected ping 2, got %d", id)
+	}
+}
@@ -0,0 +1,27 @@
+package zanarkand
+
+import (
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+)
+
+// linkDecoder returns the decoder for packets captured with a given link type.
+//
+// gopacket covers Ethernet (including 802.1Q VLAN tags), Linux cooked captures
+// (SLL and SLL2, used by the "any" device), raw IP and BSD loopback (Null/Loop),
+// but has no decoder for the IPv4- and IPv6-only raw link types some tun
+// interfaces report, so those are mapped to their network layers here.
+func linkDecoder(linkType layers.LinkType) (gopacket.Decoder, error) {
+	switch linkType {
+	case layers.LinkTypeIPv4:
+		return layers.LayerTypeIPv4, nil
+	case layers.LinkTypeIPv6:
+		return layers.LayerTypeIPv6, nil
+	}
+
+	if int(linkType) >= len(layers.LinkTypeMetadata) || layers.LinkTypeMetadata[linkType].DecodeWith == nil {
+		return nil, ErrUnsupportedLinkType{LinkType: linkType}
+	}
+
+	return linkType, nil
+}
@@ -0,0 +1,146 @@
+package zanarkand
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+// linkFrame wraps an IPv4 packet in the link layer header for linkType.
+func linkFrame(linkType layers.LinkType, ethernet []byte) []byte {
+	ip := ethernet[14:]
+
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		return ethernet
+
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4:
+		return ip
+
+	case layers.LinkTypeLinuxSLL:
+		header := make([]byte, 16)
+		binary.BigEndian.PutUint16(header[2:4], 1) // ARPHRD_ETHER
+		binary.BigEndian.PutUint16(header[4:6], 6)
+		copy(header[6:12], ethernet[6:12])
+		binary.BigEndian.PutUint16(header[14:16], uint16(layers.EthernetTypeIPv4))
+		return append(header, ip...)
+
+	case layers.LinkTypeLinuxSLL2:
+		header := make([]byte, 20)
+		binary.BigEndian.PutUint16(header[0:2], uint16(layers.EthernetTypeIPv4))
+		binary.BigEndian.PutUint32(header[4:8], 2)  // interface index
+		binary.BigEndian.PutUint16(header[8:10], 1) // ARPHRD_ETHER
+		header[11] = 6
+		copy(header[12:18], ethernet[6:12])
+		return append(header, ip...)
+
+	case layers.LinkTypeNull:
+		// BSD loopback uses the host byte order
+		header := make([]byte, 4)
+		binary.LittleEndian.PutUint32(header, 2) // AF_INET
+		return append(header, ip...)
+
+	case layers.LinkTypeLoop:
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, 2)
+		return append(header, ip...)
+	}
+
+	panic("unhandled link type " + linkType.String())
+}
+
+// vlanFrame inserts an 802.1Q tag into an Ethernet frame.
+func vlanFrame(ethernet []byte) []byte {
+	tagged := append([]byte(nil), ethernet[:12]...)
+	tagged = binary.BigEndian.AppendUint16(tagged, uint16(layers.EthernetTypeDot1Q))
+	tagged = binary.BigEndian.AppendUint16(tagged, 100) // VLAN ID
+	return append(tagged, ethernet[12:]...)
+}
+
+func TestSnifferLinkTypes(t *testing.T) {
+	wire := wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: 123456789})
+
+	tests := []struct {
+		name     string
+		linkType layers.LinkType
+		wrap     func([]byte) []byte
+	}{
+		{"Ethernet", layers.LinkTypeEthernet, nil},
+		{"VLAN", layers.LinkTypeEthernet, vlanFrame},
+		{"SLL", layers.LinkTypeLinuxSLL, nil},
+		{"SLL2", layers.LinkTypeLinuxSLL2, nil},
+		{"Raw", layers.LinkTypeRaw, nil},
+		{"IPv4", layers.LinkTypeIPv4, nil},
+		{"Null", layers.LinkTypeNull, nil},
+		{"Loop", layers.LinkTypeLoop, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle := devices.NewMemoryHandle(tt.linkType, 8)
+			defer handle.Close()
+
+			sniffer, err := NewSnifferFromHandle(handle)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			go func() { _ = sniffer.Start(context.Background()) }()
+			defer sniffer.Stop()
+
+			for !sniffer.IsActive() {
+				time.Sleep(time.Millisecond)
+			}
+
+			for _, p := range tcpStream(t, 55021, wire) {
+				if tt.wrap != nil {
+					p = tt.wrap(p)
+				} else {
+					p = linkFrame(tt.linkType, p)
+				}
+
+				if err := handle.WritePacketData(p, gopacket.CaptureInfo{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			frames := make(chan *Frame, 1)
+			go func() {
+				if frame, err := sniffer.NextFrame(); err == nil {
+					frames <- frame
+				}
+			}()
+
+			select {
+			case frame := <-frames:
+				if frame.Count != 1 || !bytes.Equal(frame.Body, wire[frameHeaderLength:]) {
+					t.Errorf("Unexpected frame: %s", frame)
+				}
+
+				if frame.Direction() != FrameIngress {
+					t.Errorf("Expected ingress direction, got %d", frame.Direction())
+				}
+
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for a frame")
+			}
+		})
+	}
+}
+
+func TestSnifferUnsupportedLinkType(t *testing.T) {
+	_, err := NewSnifferFromHandle(devices.NewMemoryHandle(layers.LinkTypeAX25, 0))
+
+	var unsupported ErrUnsupportedLinkType
+	if !errors.As(err, &unsupported) || unsupported.LinkType != layers.LinkTypeAX25 {
+		t.Errorf("Expected ErrUnsupportedLinkType, got %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package zanarkand
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// discardLogger is used when no logger is configured.
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// WithLogger sets a logger for capture, reassembly and decoding events. Streams being
+// created are logged at Debug, connections opening and closing at Info, and lost,
+// skipped or undecodable data at Warn, along with the stream ID, flows and offsets
+// involved. Errors dropped because the Errors channel is full are logged at Debug.
+// Nothing is logged by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *snifferConfig) { c.logger = logger }
+}
+
+// frameAttrs returns the attributes locating a Frame in its stream.
+func frameAttrs(frame *Frame) []any {
+	meta := frame.Meta()
+
+	return []any{
+		"stream", meta.StreamID,
+		"flow", meta.Flow.String(),
+		"transport", meta.Transport.String(),
+		"offset", meta.Offset,
+	}
+}
+
+// logDecodeFailure logs a message a subscriber couldn't decode.
+func (s *Sniffer) logDecodeFailure(frame *Frame, header *GenericHeader, err error) {
+	s.logger.Warn("message decode failed", append(frameAttrs(frame), "segment", header.Segment, "length", header.Length, "error", err)...)
+}
+
+// opcodeAttr formats a GameEvent opcode the way opcode lists write them.
+func opcodeAttr(opcode uint16) slog.Attr {
+	return slog.String("opcode", fmt.Sprintf("0x%04X", opcode))
+}
@@ -0,0 +1,150 @@
+package zanarkand
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+// recordHandler keeps every record logged through it, along with inherited attributes.
+type recordHandler struct {
+	mu      *sync.Mutex
+	records *[]map[string]slog.Value
+	attrs   []slog.Attr
+}
+
+func newRecordHandler() *recordHandler {
+	return &recordHandler{mu: new(sync.Mutex), records: new([]map[string]slog.Value)}
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	rec := map[string]slog.Value{"msg": slog.StringValue(r.Message), "level": slog.StringValue(r.Level.String())}
+	for _, a := range h.attrs {
+		rec[a.Key] = a.Value
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		rec[a.Key] = a.Value
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.records = append(*h.records, rec)
+
+	return nil
+}
+
+func (h *recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &recordHandler{mu: h.mu, records: h.records, attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...)}
+}
+
+func (h *recordHandler) WithGroup(string) slog.Handler { return h }
+
+// find returns the first record with the given message.
+func (h *recordHandler) find(msg string) map[string]slog.Value {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, rec := range *h.records {
+		if rec["msg"].String() == msg {
+			return rec
+		}
+	}
+
+	return nil
+}
+
+func TestSnifferLogger(t *testing.T) {
+	ping := wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: 1})
+	packets := tcpStream(t, 55021, append(bytes.Repeat([]byte{0xAA}, 5), ping...))
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, len(packets))
+	defer handle.Close()
+
+	logs := newRecordHandler()
+	sniffer, err := NewSnifferFromHandle(handle, WithLogger(slog.New(logs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- sniffer.Start(context.Background()) }()
+
+	for !sniffer.IsActive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, p := range packets {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frames := make(chan *Frame, 1)
+	go func() {
+		if frame, err := sniffer.NextFrame(); err == nil {
+			frames <- frame
+		}
+	}()
+
+	var frame *Frame
+	select {
+	case frame = <-frames:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a frame")
+	}
+
+	// Stopping closes the connection
+	sniffer.Stop()
+	<-done
+
+	deadline := time.Now().Add(5 * time.Second)
+	for logs.find("connection closed") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the connection to close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stream := frame.Meta().StreamID
+	tests := []struct {
+		msg   string
+		level slog.Level
+		attrs map[string]any
+	}{
+		{"sniffer started", slog.LevelInfo, nil},
+		{"stream created", slog.LevelDebug, map[string]any{"stream": stream}},
+		{"stream resync", slog.LevelWarn, map[string]any{"stream": stream, "offset": int64(0), "skipped": int64(5), "flow": frame.Meta().Flow.String()}},
+		{"connection opened", slog.LevelInfo, map[string]any{"stream": stream, "connection": "zone"}},
+		{"connection closed", slog.LevelInfo, map[string]any{"stream": stream, "frames": uint64(1)}},
+		{"sniffer stopped", slog.LevelInfo, nil},
+	}
+
+	for _, tt := range tests {
+		rec := logs.find(tt.msg)
+		if rec == nil {
+			t.Errorf("Expected a %q record", tt.msg)
+			continue
+		}
+
+		if rec["level"].String() != tt.level.String() {
+			t.Errorf("%s: expected level %s, got %s", tt.msg, tt.level, rec["level"])
+		}
+
+		for k, want := range tt.attrs {
+			if got, ok := rec[k]; !ok || got.Any() != want {
+				t.Errorf("%s: expected %s=%v, got %v", tt.msg, k, want, got)
+			}
+		}
+	}
+}
@@ -27,6 +27,8 @@ const (
 	EncryptRecv = 10
 )
 
+const messageHeaderLength = 16
+
 // GenericMessage is an interface for other Message types to make the Framer generic.
 type GenericMessage interface {
 	Decode(*bufio.Reader) error
@@ -60,15 +62,36 @@ func (m *GenericHeader) Decode(r *bufio.Reader) error {
 		return ErrNotEnoughData{Expected: 16, Received: lengthBytes, Err: err}
 	}
 
+	m.unmarshal(data)
+
+	_, _ = r.Discard(16)
+
+	return nil
+}
+
+// unmarshal fills the GenericHeader from at least 16 bytes of data without consuming them.
+func (m *GenericHeader) unmarshal(data []byte) {
 	m.Length = binary.LittleEndian.Uint32(data[0:4])
 	m.SourceActor = binary.LittleEndian.Uint32(data[4:8])
 	m.TargetActor = binary.LittleEndian.Uint32(data[8:12])
 	m.Segment = binary.LittleEndian.Uint16(data[12:14])
 	m.padding = binary.LittleEndian.Uint16(data[14:16])
+}
 
-	_, _ = r.Discard(16)
+// MarshalBinary encodes the GenericHeader into its 16 byte wire format.
+func (m *GenericHeader) MarshalBinary() ([]byte, error) {
+	data := make([]byte, messageHeaderLength)
+	m.marshal(data)
+	return data, nil
+}
 
-	return nil
+// marshal writes the GenericHeader into the first 16 bytes of data.
+func (m *GenericHeader) marshal(data []byte) {
+	binary.LittleEndian.PutUint32(data[0:4], m.Length)
+	binary.LittleEndian.PutUint32(data[4:8], m.SourceActor)
+	binary.LittleEndian.PutUint32(data[8:12], m.TargetActor)
+	binary.LittleEndian.PutUint16(data[12:14], m.Segment)
+	binary.LittleEndian.PutUint16(data[14:16], m.padding)
 }
 
 // String is a stringer for the GenericHeader of a Message.
@@ -87,6 +110,8 @@ type GameEventMessage struct {
 	Timestamp time.Time `json:"-"`        // [24:28]
 	padding3  uint32    // [28:32]
 	Body      []byte    `json:"-"`
+
+	payloads *PayloadRegistry
 }
 
 // Reset zeroes the GameEventMessage so it can be reused.
@@ -123,13 +148,51 @@ func (m *GameEventMessage) Decode(r *bufio.Reader) error {
 	m.GenericHeader = header
 	m.reserved = binary.LittleEndian.Uint16(data[0:2])
 	m.Opcode = binary.LittleEndian.Uint16(data[2:4])
+	m.padding2 = binary.LittleEndian.Uint16(data[4:6])
 	m.ServerID = binary.LittleEndian.Uint16(data[6:8])
 	m.Timestamp = time.Unix(int64(binary.LittleEndian.Uint32(data[8:12])), 0)
+	m.padding3 = binary.LittleEndian.Uint32(data[12:16])
 	m.Body = data[16:]
 
 	return nil
 }
 
+// MarshalBinary encodes the GameEventMessage into wire format.
+// The Length field is derived from the Body.
+func (m *GameEventMessage) MarshalBinary() ([]byte, error) {
+	data := make([]byte, messageHeaderLength+16+len(m.Body))
+
+	header := m.GenericHeader
+	header.Length = uint32(len(data))
+	header.marshal(data)
+
+	binary.LittleEndian.PutUint16(data[16:18], m.reserved)
+	binary.LittleEndian.PutUint16(data[18:20], m.Opcode)
+	binary.LittleEndian.PutUint16(data[20:22], m.padding2)
+	binary.LittleEndian.PutUint16(data[22:24], m.ServerID)
+
+	if !m.Timestamp.IsZero() {
+		binary.LittleEndian.PutUint32(data[24:28], uint32(m.Timestamp.Unix()))
+	}
+
+	binary.LittleEndian.PutUint32(data[28:32], m.padding3)
+	copy(data[32:], m.Body)
+
+	return data, nil
+}
+
+// Decoded decodes the Body into a new value of the type registered for the Opcode, returning
+// a pointer to it. Messages from a subscriber given WithPayloads use that registry, otherwise
+// DefaultPayloads is used. Unregistered opcodes return an ErrUnregisteredPayload.
+func (m *GameEventMessage) Decoded() (any, error) {
+	registry := m.payloads
+	if registry == nil {
+		registry = DefaultPayloads
+	}
+
+	return registry.Decode(m.Opcode, m.Body)
+}
+
 // MarshalJSON provides an override for timestamp handling for encoding/JSON
 func (m *GameEventMessage) MarshalJSON() ([]byte, error) {
 	type Alias GameEventMessage
@@ -201,6 +264,24 @@ func (m *KeepaliveMessage) Decode(r *bufio.Reader) error {
 	return nil
 }
 
+// MarshalBinary encodes the KeepaliveMessage into wire format.
+// The Length field is always written as 24.
+func (m *KeepaliveMessage) MarshalBinary() ([]byte, error) {
+	data := make([]byte, messageHeaderLength+8)
+
+	header := m.GenericHeader
+	header.Length = uint32(len(data))
+	header.marshal(data)
+
+	binary.LittleEndian.PutUint32(data[16:20], m.ID)
+
+	if !m.Timestamp.IsZero() {
+		binary.LittleEndian.PutUint32(data[20:24], uint32(m.Timestamp.Unix()))
+	}
+
+	return data, nil
+}
+
 // MarshalJSON provides an override for timestamp handling for encoding/JSON
 func (m *KeepaliveMessage) MarshalJSON() ([]byte, error) {
 	type Alias KeepaliveMessage
@@ -293,3 +293,41 @@ func TestKeepaliveStringer(t *testing.T) {
 		t.Errorf("Unexpected string, got %s, expected %s", stringy, sentinel)
 	}
 }
+
+func TestGameEventEncode(t *testing.T) {
+	z, _ := zlib.NewReader(bytes.NewReader(compressedGameEventBlob))
+	raw, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := new(GameEventMessage)
+	if err := message.Decode(bufio.NewReader(bytes.NewReader(raw))); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := message.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(encoded, raw) {
+		t.Errorf("Expected re-encoded GameEvent to match the original bytes, got %v, expected %v", encoded, raw)
+	}
+}
+
+func TestKeepaliveEncode(t *testing.T) {
+	message := KeepaliveMessage{}
+	if err := message.Decode(bufio.NewReader(bytes.NewReader(decompressedKeepaliveBlob))); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := message.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(encoded, decompressedKeepaliveBlob) {
+		t.Errorf("Expected re-encoded Keepalive to match the original bytes, got %v", encoded)
+	}
+}
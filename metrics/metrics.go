@@ -0,0 +1,302 @@
+// Package metrics exports the capture and decode health of a zanarkand Sniffer as
+// Prometheus metrics.
+//
+// A Collector is both the Sniffer's Observer, counting messages, compression and
+// errors as they're handled, and a prometheus.Collector reading Sniffer.Stats on
+// each scrape:
+//
+//	collector := metrics.NewCollector()
+//
+//	sniffer, err := zanarkand.NewSniffer("afpacket", "eth0", zanarkand.WithObserver(collector))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	collector.Watch(sniffer)
+//
+//	http.Handle("/metrics", collector.Handler())
+//	go http.ListenAndServe(":9100", nil)
+//
+// All metrics are prefixed with zanarkand_. Compare zanarkand_capture_dropped_packets_total
+// and zanarkand_data_blocked_seconds_total against zanarkand_frames_total to tell a
+// broken or overloaded capture from a quiet one.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/ayyaruq/zanarkand"
+)
+
+const namespace = "zanarkand"
+
+// Metrics read from Sniffer.Stats on each scrape
+var (
+	upDesc             = newDesc("up", "Whether the Sniffer is capturing.")
+	packetsDesc        = newDesc("packets_total", "Packets read from the capture handle.")
+	packetsSkippedDesc = newDesc("packets_skipped_total", "Packets skipped for not being TCP.")
+	assembledDesc      = newDesc("assembled_bytes_total", "TCP payload bytes reassembled in order.")
+	framesDesc         = newDesc("frames_total", "Frames read from reassembled streams.")
+	resyncDesc         = newDesc("resync_bytes_total", "Bytes skipped looking for the start of a Frame.")
+	decompressDesc     = newDesc("decompression_failures_total", "Frames that couldn't be decompressed.")
+	errorsDroppedDesc  = newDesc("errors_dropped_total", "Errors dropped because the error channel was full.")
+	eventsDroppedDesc  = newDesc("connection_events_dropped_total", "Connection events dropped because the channel was full.")
+	queuedDesc         = newDesc("data_queued_frames", "Frames waiting to be read.")
+	capacityDesc       = newDesc("data_capacity_frames", "Size of the Frame buffer.")
+	blockedDesc        = newDesc("data_blocked_seconds_total", "Time reassembly spent waiting for room in the Frame buffer.")
+	dataDroppedDesc    = newDesc("data_dropped_frames_total", "Frames dropped under the Sniffer's BackpressurePolicy.")
+	dataSpilledDesc    = newDesc("data_spilled_frames_total", "Frames written to the Sniffer's spill queue.")
+	pauseBufferedDesc  = newDesc("pause_buffered_frames", "Frames held for replay while the Sniffer is paused.")
+	pauseDiscardedDesc = newDesc("pause_discarded_frames_total", "Frames discarded while the Sniffer was paused.")
+	streamsDesc        = newDesc("open_streams", "TCP connections being reassembled.")
+	receivedDesc       = newDesc("capture_received_packets_total", "Packets received by the kernel or capture library.")
+	droppedDesc        = newDesc("capture_dropped_packets_total", "Packets dropped by the kernel or capture library for lack of buffer space.")
+	ifDroppedDesc      = newDesc("capture_interface_dropped_packets_total", "Packets dropped by the network interface or its driver.")
+)
+
+func newDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, nil, nil)
+}
+
+// Collector is a prometheus.Collector and zanarkand.Observer for a single Sniffer.
+type Collector struct {
+	mu      sync.RWMutex
+	sniffer *zanarkand.Sniffer
+
+	messages     *prometheus.CounterVec
+	errors       *prometheus.CounterVec
+	compressed   *prometheus.CounterVec
+	decompressed *prometheus.CounterVec
+	ratio        *prometheus.HistogramVec
+}
+
+// NewCollector creates a Collector. Pass it to zanarkand.WithObserver, then Watch the
+// resulting Sniffer.
+func NewCollector() *Collector {
+	return &Collector{
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_total",
+			Help:      "Messages handed to ProcessFrames handlers, by segment, GameEvent opcode and direction.",
+		}, []string{"segment", "opcode", "direction"}),
+
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "errors_total",
+			Help:      "Errors reported by the Sniffer, by kind, including dropped errors.",
+		}, []string{"kind"}),
+
+		compressed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "frame_compressed_bytes_total",
+			Help:      "Frame body bytes before decompression, by compression type.",
+		}, []string{"compression"}),
+
+		decompressed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "frame_decompressed_bytes_total",
+			Help:      "Frame body bytes after decompression, by compression type.",
+		}, []string{"compression"}),
+
+		ratio: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "frame_compression_ratio",
+			Help:      "Decompressed over compressed size of Frame bodies, by compression type.",
+			Buckets:   []float64{1, 1.5, 2, 3, 4, 6, 8, 12, 16},
+		}, []string{"compression"}),
+	}
+}
+
+// Watch sets the Sniffer whose Stats are exported. Until it's called, only the
+// observed metrics are collected.
+func (c *Collector) Watch(s *zanarkand.Sniffer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sniffer = s
+}
+
+// Handler returns an http.Handler serving the Collector's metrics, in the OpenMetrics
+// format when the scraper asks for it.
+func (c *Collector) Handler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		upDesc, packetsDesc, packetsSkippedDesc, assembledDesc, framesDesc, resyncDesc, decompressDesc,
+		errorsDroppedDesc, eventsDroppedDesc, queuedDesc, capacityDesc, blockedDesc, dataDroppedDesc,
+		dataSpilledDesc, pauseBufferedDesc, pauseDiscardedDesc, streamsDesc, receivedDesc, droppedDesc,
+		ifDroppedDesc,
+	} {
+		ch <- d
+	}
+
+	c.messages.Describe(ch)
+	c.errors.Describe(ch)
+	c.compressed.Describe(ch)
+	c.decompressed.Describe(ch)
+	c.ratio.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	s := c.sniffer
+	c.mu.RUnlock()
+
+	if s != nil {
+		collectStats(ch, s)
+	}
+
+	c.messages.Collect(ch)
+	c.errors.Collect(ch)
+	c.compressed.Collect(ch)
+	c.decompressed.Collect(ch)
+	c.ratio.Collect(ch)
+}
+
+func collectStats(ch chan<- prometheus.Metric, s *zanarkand.Sniffer) {
+	stats := s.Stats()
+
+	counter := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v)
+	}
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+
+	up := 0.0
+	if s.IsActive() {
+		up = 1
+	}
+
+	gauge(upDesc, up)
+	counter(packetsDesc, float64(stats.PacketsSeen))
+	counter(packetsSkippedDesc, float64(stats.PacketsSkipped))
+	counter(assembledDesc, float64(stats.BytesAssembled))
+	counter(framesDesc, float64(stats.FramesDecoded))
+	counter(resyncDesc, float64(stats.BytesResynced))
+	counter(decompressDesc, float64(stats.DecompressionFailures))
+	counter(errorsDroppedDesc, float64(stats.ErrorsDropped))
+	counter(eventsDroppedDesc, float64(stats.ConnectionEventsDropped))
+	gauge(queuedDesc, float64(stats.DataQueued))
+	gauge(capacityDesc, float64(stats.DataCapacity))
+	counter(blockedDesc, stats.DataBlocked.Seconds())
+	counter(dataDroppedDesc, float64(stats.DataDropped))
+	counter(dataSpilledDesc, float64(stats.DataSpilled))
+	gauge(pauseBufferedDesc, float64(stats.PauseBuffered))
+	counter(pauseDiscardedDesc, float64(stats.PauseDiscarded))
+	gauge(streamsDesc, float64(stats.OpenStreams))
+
+	// Offline and in-memory handles have no kernel counters
+	if stats.CaptureErr == nil {
+		counter(receivedDesc, float64(stats.Capture.Received))
+		counter(droppedDesc, float64(stats.Capture.Dropped))
+		counter(ifDroppedDesc, float64(stats.Capture.IfDropped))
+	}
+}
+
+// ObserveFrame implements zanarkand.Observer.
+func (c *Collector) ObserveFrame(frame *zanarkand.Frame, decompressed int) {
+	compression := frame.Compression.String()
+	compressed := len(frame.Body)
+
+	c.compressed.WithLabelValues(compression).Add(float64(compressed))
+	c.decompressed.WithLabelValues(compression).Add(float64(decompressed))
+
+	if compressed > 0 {
+		c.ratio.WithLabelValues(compression).Observe(float64(decompressed) / float64(compressed))
+	}
+}
+
+// ObserveMessage implements zanarkand.Observer.
+func (c *Collector) ObserveMessage(frame *zanarkand.Frame, header *zanarkand.GenericHeader, opcode uint16) {
+	op := ""
+	if header.Segment == zanarkand.GameEvent {
+		op = fmt.Sprintf("0x%04X", opcode)
+	}
+
+	c.messages.WithLabelValues(segmentName(header.Segment), op, directionName(frame.Direction())).Inc()
+}
+
+// ObserveError implements zanarkand.Observer.
+func (c *Collector) ObserveError(err error) {
+	c.errors.WithLabelValues(errorKind(err)).Inc()
+}
+
+func segmentName(segment uint16) string {
+	switch segment {
+	case zanarkand.SessionInit:
+		return "session_init"
+	case zanarkand.SessionRecv:
+		return "session_recv"
+	case zanarkand.GameEvent:
+		return "game_event"
+	case zanarkand.ServerPing:
+		return "server_ping"
+	case zanarkand.ServerPong:
+		return "server_pong"
+	case zanarkand.EncryptInit:
+		return "encrypt_init"
+	case zanarkand.EncryptRecv:
+		return "encrypt_recv"
+	default:
+		return "unknown"
+	}
+}
+
+func directionName(d zanarkand.FlowDirection) string {
+	switch d {
+	case zanarkand.FrameIngress:
+		return "ingress"
+	case zanarkand.FrameEgress:
+		return "egress"
+	default:
+		return "unknown"
+	}
+}
+
+// errorKind labels an error by its zanarkand error type.
+func errorKind(err error) string {
+	var (
+		gap         zanarkand.ErrStreamGap
+		resync      zanarkand.ErrStreamResync
+		length      zanarkand.ErrInvalidFrameLength
+		compression zanarkand.ErrUnsupportedCompression
+		dropped     zanarkand.ErrDropped
+		recording   zanarkand.ErrRecordingFailure
+		reassembly  zanarkand.ErrReassemblyError
+		decoding    zanarkand.ErrDecodingFailure
+	)
+
+	switch {
+	case errors.As(err, &gap):
+		return "stream_gap"
+	case errors.As(err, &resync):
+		return "stream_resync"
+	case errors.As(err, &length):
+		return "invalid_frame_length"
+	case errors.As(err, &compression):
+		return "unsupported_compression"
+	case errors.As(err, &dropped):
+		return "dropped"
+	case errors.As(err, &recording):
+		return "recording"
+	case errors.As(err, &reassembly):
+		return "reassembly"
+	case errors.As(err, &decoding):
+		return "decoding"
+	default:
+		return "other"
+	}
+}
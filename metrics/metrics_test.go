@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand"
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+func TestCollector(t *testing.T) {
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, 1)
+	defer handle.Close()
+
+	collector := NewCollector()
+
+	sniffer, err := zanarkand.NewSnifferFromHandle(handle, zanarkand.WithObserver(collector))
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector.Watch(sniffer)
+
+	frame := &zanarkand.Frame{Compression: zanarkand.FrameCompressionZlib, Body: make([]byte, 50)}
+	collector.ObserveFrame(frame, 200)
+	collector.ObserveMessage(frame, &zanarkand.GenericHeader{Segment: zanarkand.GameEvent}, 0x0232)
+	collector.ObserveMessage(frame, &zanarkand.GenericHeader{Segment: zanarkand.ServerPing}, 0)
+	collector.ObserveError(zanarkand.ErrStreamGap{Bytes: 30})
+	collector.ObserveError(zanarkand.ErrReassemblyError{Err: io.ErrUnexpectedEOF})
+
+	rec := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`zanarkand_up 0`,
+		`zanarkand_packets_total 0`,
+		`zanarkand_data_capacity_frames 200`,
+		`zanarkand_messages_total{direction="unknown",opcode="0x0232",segment="game_event"} 1`,
+		`zanarkand_messages_total{direction="unknown",opcode="",segment="server_ping"} 1`,
+		`zanarkand_errors_total{kind="stream_gap"} 1`,
+		`zanarkand_errors_total{kind="reassembly"} 1`,
+		`zanarkand_frame_compressed_bytes_total{compression="ZLib"} 50`,
+		`zanarkand_frame_decompressed_bytes_total{compression="ZLib"} 200`,
+		`zanarkand_frame_compression_ratio_bucket{compression="ZLib",le="4"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("Expected %q in:\n%s", want, body)
+		}
+	}
+
+	// A MemoryHandle has no kernel counters
+	if strings.Contains(body, "zanarkand_capture_") {
+		t.Errorf("Unexpected capture metrics in:\n%s", body)
+	}
+}
@@ -0,0 +1,45 @@
+package zanarkand
+
+import (
+	"bufio"
+	"encoding/binary"
+)
+
+// Observer is notified of the Frames, messages and errors handled by a Sniffer, for
+// metrics and tracing. Methods are called from the capture, reassembly and ProcessFrames
+// goroutines, possibly at the same time, and should return quickly.
+type Observer interface {
+	// ObserveFrame is called once ProcessFrames has handed every message of a Frame to
+	// its handler, with the total length of the decompressed messages.
+	ObserveFrame(frame *Frame, decompressed int)
+
+	// ObserveMessage is called before each message is handed to a FrameHandler. The
+	// opcode is only set for GameEvent segments.
+	ObserveMessage(frame *Frame, header *GenericHeader, opcode uint16)
+
+	// ObserveError is called with every error reported on Sniffer.Errors, including
+	// those dropped because the channel was full.
+	ObserveError(err error)
+}
+
+// WithObserver sets an Observer for the Sniffer's Frames, messages and errors.
+func WithObserver(o Observer) Option {
+	return func(c *snifferConfig) { c.observer = o }
+}
+
+// observed wraps fn to report each message to the Observer, adding their lengths to size.
+func (s *Sniffer) observed(fn FrameHandler, size *int) FrameHandler {
+	return func(frame *Frame, header *GenericHeader, r *bufio.Reader) error {
+		var opcode uint16
+		if header.Segment == GameEvent {
+			if data, err := r.Peek(messageHeaderLength + 4); err == nil {
+				opcode = binary.LittleEndian.Uint16(data[18:20])
+			}
+		}
+
+		*size += int(header.Length)
+		s.observer.ObserveMessage(frame, header, opcode)
+
+		return fn(frame, header, r)
+	}
+}
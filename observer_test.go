@@ -0,0 +1,121 @@
+package zanarkand
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+type testObserver struct {
+	mu       sync.Mutex
+	frames   []int
+	messages []uint16 // opcode, or segment for non GameEvent messages
+	errs     []error
+}
+
+func (o *testObserver) ObserveFrame(frame *Frame, decompressed int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.frames = append(o.frames, decompressed)
+}
+
+func (o *testObserver) ObserveMessage(frame *Frame, header *GenericHeader, opcode uint16) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if header.Segment != GameEvent {
+		opcode = header.Segment
+	}
+	o.messages = append(o.messages, opcode)
+}
+
+func (o *testObserver) ObserveError(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.errs = append(o.errs, err)
+}
+
+func TestSnifferObserver(t *testing.T) {
+	event := &GameEventMessage{GenericHeader: GenericHeader{Segment: GameEvent}, Opcode: 0x0232, Body: bytes.Repeat([]byte{0xCC}, 64)}
+	ping := &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: 1}
+
+	zlib := wireFrame(t, FrameCompressionZlib, event, ping)
+	oodle := wireFrame(t, FrameCompressionNone, ping)
+	oodle[33] = FrameCompressionOodle // no Decompressor registered
+	last := wireFrame(t, FrameCompressionNone, ping)
+
+	packets := tcpStream(t, 55021, zlib, oodle, last)
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, len(packets))
+	defer handle.Close()
+
+	observer := new(testObserver)
+	sniffer, err := NewSnifferFromHandle(handle, WithObserver(observer), WithErrorBufferSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+	defer sniffer.Stop()
+
+	for !sniffer.IsActive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, p := range packets {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Stop after the last Frame's ping
+	done := make(chan struct{})
+	count := 0
+	go func() {
+		_ = sniffer.ProcessFrames(func(frame *Frame, header *GenericHeader, r *bufio.Reader) error {
+			if count++; count == 3 {
+				close(done)
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for messages")
+	}
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+
+	want := []uint16{0x0232, ServerPing, ServerPing}
+	if len(observer.messages) != len(want) {
+		t.Fatalf("Expected messages %v, got %v", want, observer.messages)
+	}
+	for i := range want {
+		if observer.messages[i] != want[i] {
+			t.Errorf("Expected messages %v, got %v", want, observer.messages)
+		}
+	}
+
+	// The last Frame may still be finishing
+	size := len(wireFrame(t, FrameCompressionNone, event, ping)) - frameHeaderLength
+	if len(observer.frames) == 0 || observer.frames[0] != size {
+		t.Errorf("Expected the first Frame to decompress to %d bytes, got %v", size, observer.frames)
+	}
+
+	var unsupported ErrUnsupportedCompression
+	if len(observer.errs) != 1 || !errors.As(observer.errs[0], &unsupported) {
+		t.Errorf("Expected an ErrUnsupportedCompression, got %v", observer.errs)
+	}
+}
@@ -0,0 +1,351 @@
+// Package opcodes loads named FFXIV IPC opcode tables.
+//
+// Opcodes are shuffled by most game patches and differ between regions, so rather
+// than hardcoding them, tables are loaded from files in the format used by community
+// opcode lists such as FFXIVOpcodes:
+//
+//	[
+//	  {
+//	    "version": "2024.07.10.0000.0000",
+//	    "region": "Global",
+//	    "lists": {
+//	      "ServerZoneIpcType": [{"name": "ActorCast", "opcode": 562}],
+//	      "ClientZoneIpcType": [{"name": "ChatHandler", "opcode": "0x0123"}]
+//	    }
+//	  }
+//	]
+//
+// The same structure may be written in YAML. Opcodes may be given as numbers or
+// as strings in decimal or 0x-prefixed hexadecimal.
+package opcodes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Direction is the sender of an IPC message.
+type Direction int
+
+// Server opcodes are sent from the game server to the client.
+// Client opcodes are sent from the client to the game server.
+const (
+	Server Direction = iota
+	Client
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Server:
+		return "Server"
+	case Client:
+		return "Client"
+	default:
+		return "Unknown"
+	}
+}
+
+// Connection is the type of game connection, matching the Frame connection field.
+type Connection int
+
+const (
+	Lobby Connection = iota
+	Zone
+	Chat
+)
+
+func (c Connection) String() string {
+	switch c {
+	case Lobby:
+		return "Lobby"
+	case Zone:
+		return "Zone"
+	case Chat:
+		return "Chat"
+	default:
+		return "Unknown"
+	}
+}
+
+// ListName returns the community list name for a direction and connection, such as "ServerZoneIpcType".
+func ListName(dir Direction, conn Connection) string {
+	return dir.String() + conn.String() + "IpcType"
+}
+
+// Format is the encoding of an opcode file.
+type Format int
+
+const (
+	JSON Format = iota
+	YAML
+)
+
+// Entry is a single named opcode.
+type Entry struct {
+	Name   string `json:"name" yaml:"name"`
+	Opcode uint16 `json:"opcode" yaml:"opcode"`
+}
+
+type listKey struct {
+	dir  Direction
+	conn Connection
+}
+
+// Table holds the opcodes for a single game version and region.
+type Table struct {
+	Version string
+	Region  string
+
+	entries  map[listKey][]Entry
+	byName   map[listKey]map[string]uint16
+	byOpcode map[listKey]map[uint16]string
+}
+
+// NewTable returns an empty Table for a game version and region.
+func NewTable(region, version string) *Table {
+	return &Table{
+		Version:  version,
+		Region:   region,
+		entries:  make(map[listKey][]Entry),
+		byName:   make(map[listKey]map[string]uint16),
+		byOpcode: make(map[listKey]map[uint16]string),
+	}
+}
+
+// Add adds named opcodes to the list for a direction and connection.
+// A name that is already present is replaced.
+func (t *Table) Add(dir Direction, conn Connection, entries ...Entry) {
+	key := listKey{dir, conn}
+
+	if t.byName[key] == nil {
+		t.byName[key] = make(map[string]uint16)
+		t.byOpcode[key] = make(map[uint16]string)
+	}
+
+	for _, e := range entries {
+		if old, ok := t.byName[key][e.Name]; ok {
+			delete(t.byOpcode[key], old)
+
+			list := t.entries[key]
+			for i := range list {
+				if list[i].Name == e.Name {
+					t.entries[key] = append(list[:i], list[i+1:]...)
+					break
+				}
+			}
+		}
+
+		t.entries[key] = append(t.entries[key], e)
+		t.byName[key][e.Name] = e.Opcode
+		t.byOpcode[key][e.Opcode] = e.Name
+	}
+}
+
+// Opcode returns the opcode for a name.
+func (t *Table) Opcode(dir Direction, conn Connection, name string) (uint16, bool) {
+	op, ok := t.byName[listKey{dir, conn}][name]
+	return op, ok
+}
+
+// Name returns the name of an opcode.
+func (t *Table) Name(dir Direction, conn Connection, opcode uint16) (string, bool) {
+	name, ok := t.byOpcode[listKey{dir, conn}][opcode]
+	return name, ok
+}
+
+// Entries returns the opcodes for a direction and connection in file order.
+func (t *Table) Entries(dir Direction, conn Connection) []Entry {
+	return append([]Entry(nil), t.entries[listKey{dir, conn}]...)
+}
+
+// Database is a collection of opcode Tables for several game versions and regions.
+type Database struct {
+	tables []*Table
+}
+
+// Add adds Tables to the Database, replacing any with the same region and version.
+func (db *Database) Add(tables ...*Table) {
+	for _, t := range tables {
+		replaced := false
+		for i, existing := range db.tables {
+			if existing.Region == t.Region && existing.Version == t.Version {
+				db.tables[i] = t
+				replaced = true
+				break
+			}
+		}
+
+		if !replaced {
+			db.tables = append(db.tables, t)
+		}
+	}
+}
+
+// Tables returns every Table in the Database.
+func (db *Database) Tables() []*Table {
+	return append([]*Table(nil), db.tables...)
+}
+
+// Table returns the Table for a region and game version. If version is empty,
+// the latest version for the region is returned. Regions are matched case-insensitively.
+func (db *Database) Table(region, version string) (*Table, bool) {
+	var candidates []*Table
+	for _, t := range db.tables {
+		if strings.EqualFold(t.Region, region) && (version == "" || t.Version == version) {
+			candidates = append(candidates, t)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	// Game versions are dates, so they sort lexically
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Version < candidates[j].Version })
+
+	return candidates[len(candidates)-1], true
+}
+
+// fileTable is the on-disk representation of a Table.
+type fileTable struct {
+	Version string                 `json:"version" yaml:"version"`
+	Region  string                 `json:"region" yaml:"region"`
+	Lists   map[string][]fileEntry `json:"lists" yaml:"lists"`
+}
+
+type fileEntry struct {
+	Name   string      `json:"name" yaml:"name"`
+	Opcode opcodeValue `json:"opcode" yaml:"opcode"`
+}
+
+// opcodeValue accepts opcodes as numbers or as decimal or hexadecimal strings.
+type opcodeValue uint16
+
+func (v *opcodeValue) set(s string) error {
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid opcode %q: %w", s, err)
+	}
+
+	*v = opcodeValue(n)
+	return nil
+}
+
+func (v *opcodeValue) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return v.set(s)
+	}
+
+	return v.set(string(data))
+}
+
+func (v *opcodeValue) UnmarshalYAML(node *yaml.Node) error {
+	return v.set(node.Value)
+}
+
+// Decode reads opcode Tables from r. The input may be a list of tables or a single table.
+func Decode(r io.Reader, format Format) (*Database, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []fileTable
+
+	switch format {
+	case JSON:
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			err = json.Unmarshal(data, &files)
+		} else {
+			files = make([]fileTable, 1)
+			err = json.Unmarshal(data, &files[0])
+		}
+
+	case YAML:
+		var doc yaml.Node
+		if err = yaml.Unmarshal(data, &doc); err == nil && len(doc.Content) > 0 {
+			if doc.Content[0].Kind == yaml.SequenceNode {
+				err = doc.Decode(&files)
+			} else {
+				files = make([]fileTable, 1)
+				err = doc.Decode(&files[0])
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown opcode file format %d", format)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("decoding opcode file: %w", err)
+	}
+
+	db := new(Database)
+	for _, f := range files {
+		db.Add(f.table())
+	}
+
+	return db, nil
+}
+
+// LoadFile reads opcode Tables from a .json, .yaml or .yml file.
+func LoadFile(path string) (*Database, error) {
+	var format Format
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		format = JSON
+	case ".yaml", ".yml":
+		format = YAML
+	default:
+		return nil, fmt.Errorf("unknown opcode file extension for %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Decode(f, format)
+}
+
+func (f fileTable) table() *Table {
+	t := NewTable(f.Region, f.Version)
+
+	for name, list := range f.Lists {
+		// Skip lists we don't know about so newer files still load
+		key, ok := parseListName(name)
+		if !ok {
+			continue
+		}
+
+		for _, e := range list {
+			t.Add(key.dir, key.conn, Entry{Name: e.Name, Opcode: uint16(e.Opcode)})
+		}
+	}
+
+	return t
+}
+
+func parseListName(name string) (listKey, bool) {
+	for _, dir := range []Direction{Server, Client} {
+		for _, conn := range []Connection{Lobby, Zone, Chat} {
+			if name == ListName(dir, conn) {
+				return listKey{dir, conn}, true
+			}
+		}
+	}
+
+	return listKey{}, false
+}
@@ -0,0 +1,131 @@
+package opcodes
+
+import (
+	"strings"
+	"testing"
+)
+
+const jsonOpcodes = `[
+  {
+    "version": "2024.01.01.0000.0000",
+    "region": "Global",
+    "lists": {
+      "ServerZoneIpcType": [{"name": "ActorCast", "opcode": 100}],
+      "ClientZoneIpcType": [{"name": "ChatHandler", "opcode": "0x0123"}]
+    }
+  },
+  {
+    "version": "2024.07.10.0000.0000",
+    "region": "Global",
+    "lists": {
+      "ServerZoneIpcType": [
+        {"name": "ActorCast", "opcode": 562},
+        {"name": "EventPlay32", "opcode": "943"}
+      ],
+      "ServerChatIpcType": [{"name": "ChatParty", "opcode": 101}],
+      "ServerUnknownIpcType": [{"name": "Mystery", "opcode": 1}]
+    }
+  }
+]`
+
+const yamlOpcodes = `
+version: "2024.07.10.0000.0000"
+region: CN
+lists:
+  ServerZoneIpcType:
+    - name: ActorCast
+      opcode: 0x0300
+  ClientLobbyIpcType:
+    - name: ReqCharList
+      opcode: "0x0003"
+`
+
+func TestDecodeJSON(t *testing.T) {
+	db, err := Decode(strings.NewReader(jsonOpcodes), JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(db.Tables()) != 2 {
+		t.Fatalf("Expected 2 tables, got %d", len(db.Tables()))
+	}
+
+	latest, ok := db.Table("global", "")
+	if !ok {
+		t.Fatal("Expected a latest Global table")
+	}
+
+	if latest.Version != "2024.07.10.0000.0000" {
+		t.Errorf("Expected the latest version, got %s", latest.Version)
+	}
+
+	if op, ok := latest.Opcode(Server, Zone, "ActorCast"); !ok || op != 562 {
+		t.Errorf("Expected ActorCast to be 562, got %d", op)
+	}
+
+	if op, ok := latest.Opcode(Server, Zone, "EventPlay32"); !ok || op != 943 {
+		t.Errorf("Expected EventPlay32 to be 943, got %d", op)
+	}
+
+	if name, ok := latest.Name(Server, Chat, 101); !ok || name != "ChatParty" {
+		t.Errorf("Expected 101 to be ChatParty, got %q", name)
+	}
+
+	if _, ok := latest.Opcode(Client, Zone, "ActorCast"); ok {
+		t.Error("Expected ActorCast to not be a client opcode")
+	}
+
+	old, ok := db.Table("Global", "2024.01.01.0000.0000")
+	if !ok {
+		t.Fatal("Expected the older Global table")
+	}
+
+	if op, ok := old.Opcode(Client, Zone, "ChatHandler"); !ok || op != 0x0123 {
+		t.Errorf("Expected hex ChatHandler opcode 0x0123, got 0x%X", op)
+	}
+}
+
+func TestDecodeYAML(t *testing.T) {
+	db, err := Decode(strings.NewReader(yamlOpcodes), YAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, ok := db.Table("CN", "2024.07.10.0000.0000")
+	if !ok {
+		t.Fatal("Expected a CN table")
+	}
+
+	if op, ok := table.Opcode(Server, Zone, "ActorCast"); !ok || op != 0x0300 {
+		t.Errorf("Expected ActorCast to be 0x0300, got 0x%X", op)
+	}
+
+	if name, ok := table.Name(Client, Lobby, 3); !ok || name != "ReqCharList" {
+		t.Errorf("Expected 3 to be ReqCharList, got %q", name)
+	}
+}
+
+func TestDecodeInvalidOpcode(t *testing.T) {
+	_, err := Decode(strings.NewReader(`{"region": "Global", "lists": {"ServerZoneIpcType": [{"name": "Bad", "opcode": "0x10000"}]}}`), JSON)
+	if err == nil {
+		t.Error("Expected an error for an opcode that doesn't fit in 16 bits")
+	}
+}
+
+func TestTableAddReplaces(t *testing.T) {
+	table := NewTable("Global", "test")
+	table.Add(Server, Zone, Entry{Name: "ActorCast", Opcode: 1}, Entry{Name: "ActorMove", Opcode: 2})
+	table.Add(Server, Zone, Entry{Name: "ActorCast", Opcode: 3})
+
+	if op, _ := table.Opcode(Server, Zone, "ActorCast"); op != 3 {
+		t.Errorf("Expected ActorCast to be replaced with 3, got %d", op)
+	}
+
+	if _, ok := table.Name(Server, Zone, 1); ok {
+		t.Error("Expected the old ActorCast opcode to be removed")
+	}
+
+	if entries := table.Entries(Server, Zone); len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got %v", entries)
+	}
+}
@@ -0,0 +1,228 @@
+package zanarkand
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// PauseMode decides what happens to Frames read while a Sniffer is paused.
+type PauseMode int
+
+const (
+	// PauseDiscard drops every Frame read while paused.
+	PauseDiscard PauseMode = iota
+
+	// PauseBuffer keeps the Frames read while paused, up to the size set by
+	// WithPauseBuffer, and replays them in order on Resume. Frames that don't fit are
+	// discarded, as are any still buffered when the Sniffer stops.
+	PauseBuffer
+)
+
+func (m PauseMode) String() string {
+	switch m {
+	case PauseDiscard:
+		return "discard"
+	case PauseBuffer:
+		return "buffer"
+	default:
+		return "unknown"
+	}
+}
+
+// defaultPauseBufferLimit bounds the Frames kept by PauseBuffer
+const defaultPauseBufferLimit = 16 << 20
+
+// WithPauseBuffer sets how many bytes of Frames a Sniffer paused with PauseBuffer keeps
+// for Resume. The default is 16 MiB.
+func WithPauseBuffer(limit int) Option {
+	return func(c *snifferConfig) { c.pauseLimit = limit }
+}
+
+// Pause stops handing Frames to NextFrame, ProcessFrames and subscribers until Resume is
+// called. Packets are still captured and reassembled, and Frames still found in each
+// stream, so connections stay open and nothing needs to resync on Resume. Depending on
+// mode, the Frames read meanwhile are discarded or buffered for Resume; either way they're
+// counted in Stats. Pausing a paused Sniffer switches its mode. Pausing one that isn't
+// running returns an ErrInvalidState.
+func (s *Sniffer) Pause(mode PauseMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.state {
+	case SnifferRunning, SnifferPaused:
+		s.state = SnifferPaused
+	default:
+		return ErrInvalidState{Op: "pause", State: s.state}
+	}
+
+	s.gate.pause(mode)
+	s.logger.Info("sniffer paused", "mode", mode.String())
+
+	return nil
+}
+
+// Resume hands Frames over again after Pause, starting with any buffered by PauseBuffer.
+// Resuming a running Sniffer is a no-op; resuming one that isn't paused returns an
+// ErrInvalidState.
+func (s *Sniffer) Resume() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.state {
+	case SnifferRunning:
+		return nil
+	case SnifferPaused:
+		s.state = SnifferRunning
+	default:
+		return ErrInvalidState{Op: "resume", State: s.state}
+	}
+
+	replay := s.gate.resume()
+	s.logger.Info("sniffer resumed", "replaying", replay)
+
+	return nil
+}
+
+// pauseGate sits between the reassembly goroutines and a Sniffer's data queue, holding
+// Frames back while paused.
+type pauseGate struct {
+	data  *queue[reassembledPacket]
+	limit int
+
+	mu        sync.Mutex
+	cond      *sync.Cond      // signalled when replaying ends or the gate is paused
+	stop      <-chan struct{} // closed when the current run stops
+	paused    bool
+	replaying bool
+	mode      PauseMode
+	buf       []reassembledPacket
+	size      int // bytes in buf
+
+	discarded atomic.Uint64
+}
+
+func newPauseGate(data *queue[reassembledPacket], limit int) *pauseGate {
+	g := &pauseGate{data: data, limit: limit}
+	g.cond = sync.NewCond(&g.mu)
+
+	return g
+}
+
+// send delivers a Frame to the data queue, or discards or buffers it while paused.
+// While the buffer is replayed, it waits for it to empty, so Frames stay in order.
+func (g *pauseGate) send(p reassembledPacket) {
+	g.mu.Lock()
+
+	for g.replaying && !g.paused {
+		g.cond.Wait()
+	}
+
+	if !g.paused {
+		stop := g.stop
+		g.mu.Unlock()
+		g.data.sendUntil(p, stop)
+		return
+	}
+
+	defer g.mu.Unlock()
+
+	if g.mode == PauseDiscard || g.size+len(p.Body) > g.limit {
+		g.discarded.Add(1)
+		return
+	}
+
+	g.buf = append(g.buf, p)
+	g.size += len(p.Body)
+}
+
+// start sets the channel closed when the run starting now stops, which unblocks senders
+// waiting for room in the data queue.
+func (g *pauseGate) start(stop <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.stop = stop
+}
+
+func (g *pauseGate) pause(mode PauseMode) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.paused, g.mode = true, mode
+	g.cond.Broadcast()
+}
+
+// resume starts replaying the buffer, returning the number of Frames in it.
+func (g *pauseGate) resume() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.paused = false
+
+	if len(g.buf) > 0 && !g.replaying {
+		g.replaying = true
+		go g.replay()
+	}
+
+	return len(g.buf)
+}
+
+// replay sends buffered Frames in order, until the buffer is empty, the gate is paused
+// again, or the run stops.
+func (g *pauseGate) replay() {
+	for {
+		g.mu.Lock()
+
+		if g.paused || len(g.buf) == 0 || g.stopped() {
+			g.replaying = false
+			if len(g.buf) == 0 {
+				g.buf = nil
+			}
+			g.cond.Broadcast()
+			g.mu.Unlock()
+			return
+		}
+
+		p := g.buf[0]
+		g.buf[0] = reassembledPacket{}
+		g.buf = g.buf[1:]
+		g.size -= len(p.Body)
+		stop := g.stop
+		g.mu.Unlock()
+
+		g.data.sendUntil(p, stop)
+	}
+}
+
+// stopped reports whether the current run has stopped. g.mu must be held.
+func (g *pauseGate) stopped() bool {
+	select {
+	case <-g.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// reset discards anything buffered and lets Frames through, at the end of a run. The run
+// must have stopped, so any replay finishes, and reset waits for it so none of the run's
+// Frames are delivered into the next one.
+func (g *pauseGate) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.discarded.Add(uint64(len(g.buf)))
+	g.paused = false
+	g.buf, g.size = nil, 0
+
+	for g.replaying {
+		g.cond.Wait()
+	}
+}
+
+// buffered returns the number of Frames waiting for Resume.
+func (g *pauseGate) buffered() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.buf)
+}
@@ -0,0 +1,197 @@
+package zanarkand
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+func TestSnifferPause(t *testing.T) {
+	size := len(pingFrame(t, 1))
+
+	tests := []struct {
+		mode      PauseMode
+		want      []uint32
+		buffered  int
+		discarded uint64
+	}{
+		{PauseDiscard, []uint32{1, 5}, 0, 3},
+		{PauseBuffer, []uint32{1, 2, 3, 5}, 2, 1}, // two pings fit
+	}
+
+	for _, tt := range tests {
+		packets := tcpStream(t, 55021, pingFrame(t, 1), pingFrame(t, 2), pingFrame(t, 3), pingFrame(t, 4), pingFrame(t, 5))
+
+		handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, len(packets))
+
+		sniffer, err := NewSnifferFromHandle(handle, WithPauseBuffer(2*size))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() { _ = sniffer.Start(context.Background()) }()
+		waitState(t, sniffer, SnifferRunning)
+
+		// The SYN-ACK and the first ping
+		writePackets(t, handle, packets[:2])
+		first := waitFrame(t, sniffer)
+
+		if err := sniffer.Pause(tt.mode); err != nil {
+			t.Fatal(err)
+		}
+
+		// Frames are still read while paused, but held back
+		writePackets(t, handle, packets[2:5])
+
+		deadline := time.Now().Add(5 * time.Second)
+		for stats := sniffer.Stats(); uint64(stats.PauseBuffered)+stats.PauseDiscarded < 3; stats = sniffer.Stats() {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: timed out waiting for Frames, got %+v", tt.mode, sniffer.Stats())
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		if stats := sniffer.Stats(); stats.DataQueued != 0 || stats.PauseBuffered != tt.buffered || stats.PauseDiscarded != tt.discarded {
+			t.Errorf("%s: expected %d buffered and %d discarded while paused, got %+v", tt.mode, tt.buffered, tt.discarded, stats)
+		}
+
+		if err := sniffer.Resume(); err != nil {
+			t.Fatal(err)
+		}
+
+		writePackets(t, handle, packets[5:])
+
+		got := []uint32{binary.LittleEndian.Uint32(first.Body[16:20])}
+		for len(got) < len(tt.want) {
+			frame := waitFrame(t, sniffer)
+			got = append(got, binary.LittleEndian.Uint32(frame.Body[16:20]))
+
+			if frame.Meta().StreamID != first.Meta().StreamID {
+				t.Errorf("%s: expected the connection to survive the pause, got stream %d after %d", tt.mode, frame.Meta().StreamID, first.Meta().StreamID)
+			}
+		}
+
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: expected pings %v, got %v", tt.mode, tt.want, got)
+				break
+			}
+		}
+
+		stats := sniffer.Stats()
+		if stats.BytesResynced != 0 || stats.PauseBuffered != 0 || stats.PauseDiscarded != tt.discarded {
+			t.Errorf("%s: expected no resync and %d discarded, got %+v", tt.mode, tt.discarded, stats)
+		}
+
+		select {
+		case err := <-sniffer.Errors():
+			t.Errorf("%s: unexpected error %v", tt.mode, err)
+		default:
+		}
+
+		// Closes the handle too
+		sniffer.Close()
+	}
+}
+
+func TestSnifferStopWhilePaused(t *testing.T) {
+	packets := tcpStream(t, 55021, pingFrame(t, 1))
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, len(packets))
+	defer handle.Close()
+
+	sniffer, err := NewSnifferFromHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+	waitState(t, sniffer, SnifferRunning)
+
+	if err := sniffer.Pause(PauseBuffer); err != nil {
+		t.Fatal(err)
+	}
+
+	writePackets(t, handle, packets)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sniffer.Stats().PauseBuffered == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for a buffered Frame")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Buffered Frames don't outlive the run
+	sniffer.Stop()
+	if err := sniffer.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := sniffer.Stats(); stats.PauseBuffered != 0 || stats.PauseDiscarded != 1 || stats.DataQueued != 0 {
+		t.Errorf("Expected the buffered Frame to be discarded, got %+v", stats)
+	}
+
+	if err := sniffer.Resume(); err == nil {
+		t.Error("Expected an error resuming a stopped Sniffer")
+	}
+}
+
+func TestSnifferStopWhileReplaying(t *testing.T) {
+	packets := tcpStream(t, 55021, pingFrame(t, 1), pingFrame(t, 2), pingFrame(t, 3), pingFrame(t, 4))
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, len(packets))
+	defer handle.Close()
+
+	sniffer, err := NewSnifferFromHandle(handle, WithDataBufferSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+	waitState(t, sniffer, SnifferRunning)
+
+	if err := sniffer.Pause(PauseBuffer); err != nil {
+		t.Fatal(err)
+	}
+
+	writePackets(t, handle, packets)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sniffer.Stats().PauseBuffered < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for buffered Frames, got %+v", sniffer.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Nothing reads, so the replay fills the data buffer and blocks
+	if err := sniffer.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	for sniffer.Stats().DataQueued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	sniffer.Stop()
+	if err := sniffer.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the Frame already buffered is left; the replay mustn't deliver any more
+	<-sniffer.dataCh
+	select {
+	case data := <-sniffer.dataCh:
+		t.Errorf("Expected the replay to end with the run, got ping %d", binary.LittleEndian.Uint32(data.Body[16:20]))
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if stats := sniffer.Stats(); stats.PauseBuffered != 0 {
+		t.Errorf("Expected nothing left to replay, got %+v", stats)
+	}
+}
@@ -0,0 +1,402 @@
+package zanarkand
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PayloadRegistry maps GameEvent opcodes to the Go struct types their bodies decode into.
+// It is safe for concurrent use.
+//
+// Struct fields are laid out with `ipc` tags giving the byte offset of the field within
+// the message body, followed by optional flags:
+//
+//	type ActorCast struct {
+//		ActionID   uint16    `ipc:"0"`
+//		SkillType  uint8     `ipc:"2"`
+//		CastTime   float32   `ipc:"8"`
+//		TargetID   uint32    `ipc:"12"`
+//		Position   [3]uint16 `ipc:"20"`         // array length comes from the type
+//		Effects    []uint32  `ipc:"28,count=4"` // slices need an explicit count
+//		Name       string    `ipc:"44,size=32"` // fixed-size, NUL-padded string
+//		Sequence   uint32    `ipc:"76,be"`      // big-endian, little-endian is the default
+//		Unknown    uint32    `ipc:"-"`          // skipped, as are untagged fields
+//	}
+//
+// Nested structs are decoded at their offset using their own tags. Arrays and slices of
+// structs are laid out back to back, or stride bytes apart if given, e.g. `ipc:"8,count=3,stride=12"`.
+// A struct can't contain itself through its tagged fields, and Register returns an error
+// for one that does.
+type PayloadRegistry struct {
+	mu    sync.RWMutex
+	types map[uint16]reflect.Type
+}
+
+// NewPayloadRegistry returns an empty PayloadRegistry.
+func NewPayloadRegistry() *PayloadRegistry {
+	return &PayloadRegistry{types: make(map[uint16]reflect.Type)}
+}
+
+// Register maps an opcode to the type of prototype, which must be a struct or a pointer
+// to one. The struct tags are validated when it is registered.
+func (r *PayloadRegistry) Register(opcode uint16, prototype any) error {
+	t := reflect.TypeOf(prototype)
+	if t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return fmt.Errorf("payload for opcode 0x%X must be a struct, got %T", opcode, prototype)
+	}
+
+	if _, err := payloadCodecFor(t); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.types[opcode] = t
+	return nil
+}
+
+// Decode decodes a GameEvent body into a new value of the type registered for opcode,
+// returning a pointer to it.
+func (r *PayloadRegistry) Decode(opcode uint16, body []byte) (any, error) {
+	r.mu.RLock()
+	t, ok := r.types[opcode]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrUnregisteredPayload{Opcode: opcode}
+	}
+
+	v := reflect.New(t)
+	if err := unmarshalPayload(body, v.Elem()); err != nil {
+		return nil, err
+	}
+
+	return v.Interface(), nil
+}
+
+// DefaultPayloads is the registry used by GameEventMessage.Decoded unless a subscriber
+// was given WithPayloads.
+var DefaultPayloads = NewPayloadRegistry()
+
+// RegisterPayload maps an opcode to a struct type in DefaultPayloads.
+func RegisterPayload(opcode uint16, prototype any) error {
+	return DefaultPayloads.Register(opcode, prototype)
+}
+
+// WithPayloads sets the registry used by GameEventMessage.Decoded for delivered messages.
+// The default is DefaultPayloads.
+func WithPayloads(r *PayloadRegistry) GameEventOption {
+	return func(c *gameEventConfig) { c.payloads = r }
+}
+
+// UnmarshalPayload decodes a message body into v, which must be a pointer to a struct
+// with `ipc` field tags as described on PayloadRegistry.
+func UnmarshalPayload(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("payload target must be a non-nil pointer to a struct, got %T", v)
+	}
+
+	return unmarshalPayload(data, rv.Elem())
+}
+
+func unmarshalPayload(data []byte, v reflect.Value) error {
+	codec, err := payloadCodecFor(v.Type())
+	if err != nil {
+		return err
+	}
+
+	return codec.decode(data, 0, v)
+}
+
+// payloadField is the compiled layout of a single tagged struct field.
+type payloadField struct {
+	name   string
+	index  int
+	offset int
+	order  binary.ByteOrder
+	kind   reflect.Kind // kind of the field, or of its elements for arrays and slices
+	size   int          // size of one element in bytes
+	count  int          // number of elements, 1 for scalars
+	slice  bool
+	str    bool
+	nested *payloadCodec
+}
+
+// payloadCodec is the compiled layout of a struct type.
+type payloadCodec struct {
+	name   string
+	fields []payloadField
+	size   int // extent of the furthest field
+}
+
+var payloadCodecs sync.Map // map[reflect.Type]*payloadCodec
+
+func payloadCodecFor(t reflect.Type) (*payloadCodec, error) {
+	return loadPayloadCodec(t, make(map[reflect.Type]bool))
+}
+
+// loadPayloadCodec returns the cached codec for t, or compiles it. compiling holds the
+// types being compiled further up, so a type that contains itself fails instead of
+// recursing forever.
+func loadPayloadCodec(t reflect.Type, compiling map[reflect.Type]bool) (*payloadCodec, error) {
+	if c, ok := payloadCodecs.Load(t); ok {
+		return c.(*payloadCodec), nil
+	}
+
+	if compiling[t] {
+		return nil, fmt.Errorf("payload type %s contains itself", t)
+	}
+	compiling[t] = true
+	defer delete(compiling, t)
+
+	c, err := compilePayloadCodec(t, compiling)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadCodecs.Store(t, c)
+	return c, nil
+}
+
+func compilePayloadCodec(t reflect.Type, compiling map[reflect.Type]bool) (*payloadCodec, error) {
+	c := &payloadCodec{name: t.Name()}
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		tag, ok := sf.Tag.Lookup("ipc")
+		if !ok || tag == "-" {
+			continue
+		}
+
+		if !sf.IsExported() {
+			return nil, fmt.Errorf("payload field %s.%s is tagged but not exported", t.Name(), sf.Name)
+		}
+
+		f, err := compilePayloadField(sf, tag, compiling)
+		if err != nil {
+			return nil, fmt.Errorf("payload field %s.%s: %w", t.Name(), sf.Name, err)
+		}
+
+		f.name = t.Name() + "." + sf.Name
+		f.index = i
+
+		stride := f.size
+		if f.nested != nil && f.size == 0 {
+			stride = f.nested.size
+		}
+
+		if end := f.offset + stride*f.count; end > c.size {
+			c.size = end
+		}
+
+		c.fields = append(c.fields, f)
+	}
+
+	return c, nil
+}
+
+func compilePayloadField(sf reflect.StructField, tag string, compiling map[reflect.Type]bool) (payloadField, error) {
+	parts := strings.Split(tag, ",")
+
+	offset, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || offset < 0 {
+		return payloadField{}, fmt.Errorf("invalid offset %q", parts[0])
+	}
+
+	f := payloadField{offset: offset, order: binary.LittleEndian, count: 1}
+
+	var size, count, stride int
+	for _, opt := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+
+		switch key {
+		case "be":
+			f.order = binary.BigEndian
+		case "le":
+			f.order = binary.LittleEndian
+		case "size", "count", "stride":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return payloadField{}, fmt.Errorf("invalid %s %q", key, value)
+			}
+
+			switch key {
+			case "size":
+				size = n
+			case "count":
+				count = n
+			case "stride":
+				stride = n
+			}
+		default:
+			return payloadField{}, fmt.Errorf("unknown tag option %q", opt)
+		}
+	}
+
+	t := sf.Type
+	switch t.Kind() {
+	case reflect.String:
+		if size == 0 {
+			return payloadField{}, fmt.Errorf("strings need a size")
+		}
+		f.kind, f.size, f.str = reflect.String, size, true
+		return f, nil
+
+	case reflect.Array:
+		f.count = t.Len()
+		t = t.Elem()
+
+	case reflect.Slice:
+		if count == 0 {
+			return payloadField{}, fmt.Errorf("slices need a count")
+		}
+		f.count, f.slice = count, true
+		t = t.Elem()
+	}
+
+	f.kind = t.Kind()
+
+	if f.kind == reflect.Struct {
+		nested, err := loadPayloadCodec(t, compiling)
+		if err != nil {
+			return payloadField{}, err
+		}
+
+		f.nested = nested
+		f.size = stride
+		return f, nil
+	}
+
+	f.size = scalarSize(f.kind)
+	if f.size == 0 {
+		return payloadField{}, fmt.Errorf("unsupported type %s", sf.Type)
+	}
+
+	return f, nil
+}
+
+func scalarSize(k reflect.Kind) int {
+	switch k {
+	case reflect.Bool, reflect.Int8, reflect.Uint8:
+		return 1
+	case reflect.Int16, reflect.Uint16:
+		return 2
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		return 4
+	case reflect.Int64, reflect.Uint64, reflect.Float64:
+		return 8
+	default:
+		return 0
+	}
+}
+
+func (c *payloadCodec) decode(data []byte, base int, v reflect.Value) error {
+	for _, f := range c.fields {
+		fv := v.Field(f.index)
+		start := base + f.offset
+
+		if f.nested != nil {
+			stride := f.size
+			if stride == 0 {
+				stride = f.nested.size
+			}
+
+			if err := f.decodeNested(data, start, stride, fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		end := start + f.size*f.count
+		if end > len(data) {
+			return ErrNotEnoughData{Expected: end, Received: len(data), Field: f.name}
+		}
+
+		switch {
+		case f.str:
+			fv.SetString(string(bytes.TrimRight(data[start:end], "\x00")))
+
+		case f.slice:
+			s := reflect.MakeSlice(fv.Type(), f.count, f.count)
+			for i := 0; i < f.count; i++ {
+				f.setScalar(s.Index(i), data[start+i*f.size:])
+			}
+			fv.Set(s)
+
+		case fv.Kind() == reflect.Array:
+			for i := 0; i < f.count; i++ {
+				f.setScalar(fv.Index(i), data[start+i*f.size:])
+			}
+
+		default:
+			f.setScalar(fv, data[start:])
+		}
+	}
+
+	return nil
+}
+
+func (f *payloadField) decodeNested(data []byte, start, stride int, fv reflect.Value) error {
+	switch {
+	case f.slice:
+		s := reflect.MakeSlice(fv.Type(), f.count, f.count)
+		for i := 0; i < f.count; i++ {
+			if err := f.nested.decode(data, start+i*stride, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		fv.Set(s)
+
+	case fv.Kind() == reflect.Array:
+		for i := 0; i < f.count; i++ {
+			if err := f.nested.decode(data, start+i*stride, fv.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return f.nested.decode(data, start, fv)
+	}
+
+	return nil
+}
+
+func (f *payloadField) setScalar(v reflect.Value, b []byte) {
+	switch f.kind {
+	case reflect.Bool:
+		v.SetBool(b[0] != 0)
+	case reflect.Uint8:
+		v.SetUint(uint64(b[0]))
+	case reflect.Int8:
+		v.SetInt(int64(int8(b[0])))
+	case reflect.Uint16:
+		v.SetUint(uint64(f.order.Uint16(b)))
+	case reflect.Int16:
+		v.SetInt(int64(int16(f.order.Uint16(b))))
+	case reflect.Uint32:
+		v.SetUint(uint64(f.order.Uint32(b)))
+	case reflect.Int32:
+		v.SetInt(int64(int32(f.order.Uint32(b))))
+	case reflect.Uint64:
+		v.SetUint(f.order.Uint64(b))
+	case reflect.Int64:
+		v.SetInt(int64(f.order.Uint64(b)))
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(f.order.Uint32(b))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(f.order.Uint64(b)))
+	}
+}
@@ -0,0 +1,183 @@
+package zanarkand
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+type testPayloadPosition struct {
+	X int16 `ipc:"0"`
+	Y int16 `ipc:"2"`
+}
+
+type testPayload struct {
+	ActionID uint16                 `ipc:"0"`
+	Flag     bool                   `ipc:"2"`
+	Delta    int8                   `ipc:"3"`
+	CastTime float32                `ipc:"4"`
+	Targets  [2]uint32              `ipc:"8"`
+	Effects  []uint16               `ipc:"16,count=3"`
+	Name     string                 `ipc:"22,size=8"`
+	Sequence uint32                 `ipc:"30,be"`
+	Origin   testPayloadPosition    `ipc:"34"`
+	Path     [2]testPayloadPosition `ipc:"38,stride=6"`
+	Ignored  uint32                 `ipc:"-"`
+	Untagged uint32
+}
+
+func testPayloadBody() []byte {
+	body := make([]byte, 50)
+	binary.LittleEndian.PutUint16(body[0:2], 0x1234)
+	body[2] = 1
+	body[3] = 0xFF
+	binary.LittleEndian.PutUint32(body[4:8], math.Float32bits(2.5))
+	binary.LittleEndian.PutUint32(body[8:12], 100)
+	binary.LittleEndian.PutUint32(body[12:16], 200)
+	binary.LittleEndian.PutUint16(body[16:18], 1)
+	binary.LittleEndian.PutUint16(body[18:20], 2)
+	binary.LittleEndian.PutUint16(body[20:22], 3)
+	copy(body[22:30], "Lalafell")
+	binary.BigEndian.PutUint32(body[30:34], 0xDEADBEEF)
+	binary.LittleEndian.PutUint16(body[34:36], 0xFFFE)
+	binary.LittleEndian.PutUint16(body[36:38], 7)
+	binary.LittleEndian.PutUint16(body[38:40], 10)
+	binary.LittleEndian.PutUint16(body[40:42], 11)
+	binary.LittleEndian.PutUint16(body[44:46], 20)
+	binary.LittleEndian.PutUint16(body[46:48], 21)
+	return body
+}
+
+func TestUnmarshalPayload(t *testing.T) {
+	var p testPayload
+	if err := UnmarshalPayload(testPayloadBody(), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.ActionID != 0x1234 || !p.Flag || p.Delta != -1 || p.CastTime != 2.5 {
+		t.Errorf("Unexpected scalar fields: %+v", p)
+	}
+
+	if p.Targets != [2]uint32{100, 200} {
+		t.Errorf("Expected targets [100 200], got %v", p.Targets)
+	}
+
+	if len(p.Effects) != 3 || p.Effects[0] != 1 || p.Effects[2] != 3 {
+		t.Errorf("Expected effects [1 2 3], got %v", p.Effects)
+	}
+
+	if p.Name != "Lalafell" {
+		t.Errorf("Expected name Lalafell, got %q", p.Name)
+	}
+
+	if p.Sequence != 0xDEADBEEF {
+		t.Errorf("Expected big-endian sequence 0xDEADBEEF, got 0x%X", p.Sequence)
+	}
+
+	if p.Origin != (testPayloadPosition{X: -2, Y: 7}) {
+		t.Errorf("Unexpected origin: %+v", p.Origin)
+	}
+
+	if p.Path != [2]testPayloadPosition{{X: 10, Y: 11}, {X: 20, Y: 21}} {
+		t.Errorf("Unexpected path: %+v", p.Path)
+	}
+}
+
+func TestUnmarshalPayloadShort(t *testing.T) {
+	var p testPayload
+	err := UnmarshalPayload(testPayloadBody()[:24], &p)
+
+	var typedErr ErrNotEnoughData
+	if !errors.As(err, &typedErr) {
+		t.Fatalf("Expected ErrNotEnoughData, got %v", err)
+	}
+
+	if typedErr.Field != "testPayload.Name" || typedErr.Expected != 30 || typedErr.Received != 24 {
+		t.Errorf("Unexpected error context: %v", err)
+	}
+}
+
+func TestPayloadRegistry(t *testing.T) {
+	registry := NewPayloadRegistry()
+
+	if err := registry.Register(0x0232, testPayload{}); err != nil {
+		t.Fatal(err)
+	}
+
+	type badPayload struct {
+		Name string `ipc:"0"`
+	}
+
+	if err := registry.Register(0x0233, &badPayload{}); err == nil {
+		t.Error("Expected an error registering a string without a size")
+	}
+
+	msg := &GameEventMessage{Opcode: 0x0232, Body: testPayloadBody(), payloads: registry}
+
+	decoded, err := msg.Decoded()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, ok := decoded.(*testPayload)
+	if !ok {
+		t.Fatalf("Expected *testPayload, got %T", decoded)
+	}
+
+	if p.ActionID != 0x1234 {
+		t.Errorf("Expected action 0x1234, got 0x%X", p.ActionID)
+	}
+
+	msg.Opcode = 0x0999
+	if _, err := msg.Decoded(); !errors.As(err, new(ErrUnregisteredPayload)) {
+		t.Errorf("Expected ErrUnregisteredPayload, got %v", err)
+	}
+}
+
+type testPayloadTree struct {
+	ID       uint16            `ipc:"0"`
+	Children []testPayloadTree `ipc:"2,count=2,stride=2"`
+}
+
+type testPayloadOuter struct {
+	Inner testPayloadInner `ipc:"0"`
+}
+
+type testPayloadInner struct {
+	Outers []testPayloadOuter `ipc:"0,count=1,stride=4"`
+}
+
+type testPayloadLinked struct {
+	ID   uint16             `ipc:"0"`
+	Next *testPayloadLinked `ipc:"2"`
+}
+
+type testPayloadUntaggedCycle struct {
+	ID   uint16 `ipc:"0"`
+	Next []testPayloadUntaggedCycle
+}
+
+func TestPayloadRegistryCycle(t *testing.T) {
+	tests := []struct {
+		name      string
+		prototype any
+		valid     bool
+	}{
+		{"slice of itself", testPayloadTree{}, false},
+		{"through another struct", testPayloadOuter{}, false},
+		{"pointer to itself", testPayloadLinked{}, false},
+		{"untagged", testPayloadUntaggedCycle{}, true},
+	}
+
+	registry := NewPayloadRegistry()
+	for i, tt := range tests {
+		err := registry.Register(uint16(0x0300+i), tt.prototype)
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected an error registering a payload containing itself", tt.name)
+		}
+	}
+}
@@ -2,94 +2,592 @@ package zanarkand
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"encoding/gob"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gopacket/gopacket"
-	"github.com/gopacket/gopacket/tcpassembly"
-	"github.com/gopacket/gopacket/tcpassembly/tcpreader"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/reassembly"
 )
 
+// Default reassembly buffer limits, in pages of out-of-order data
+const (
+	defaultMaxPagesPerConnection = 32
+	defaultMaxPagesTotal         = 192 // 32 for each of the Client/Server pairs for Lobby, Chat, and Zone
+)
+
+// defaultMaxFrameSize bounds the Length of a Frame header before its body is allocated
+const defaultMaxFrameSize = 1 << 20
+
 // reassembledPacket is a frame payload with TCP metadata
 type reassembledPacket struct {
 	Body []byte
-	Flow gopacket.Flow
+	Meta FrameMeta
+}
+
+// spilledPacket is a reassembledPacket as written to a spill queue.
+type spilledPacket struct {
+	Body                 []byte
+	FlowType, PortType   int64
+	Flow, Transport      [2][]byte
+	StreamID             uint64
+	Offset               int64
+	FirstSeen, LastSeen  time.Time
+	Handshake, Initiator bool
 }
 
-// frameStreamFactory implements tcpassembly.StreamFactory
+// packetCodec spills the reassembledPackets of a Sniffer's data queue.
+var packetCodec = spillCodec[reassembledPacket]{
+	encode: func(p reassembledPacket) ([]byte, error) {
+		m := p.Meta
+		src, dst := m.Flow.Endpoints()
+		sport, dport := m.Transport.Endpoints()
+
+		var buf bytes.Buffer
+		err := gob.NewEncoder(&buf).Encode(spilledPacket{
+			Body:      p.Body,
+			FlowType:  int64(m.Flow.EndpointType()),
+			PortType:  int64(m.Transport.EndpointType()),
+			Flow:      [2][]byte{src.Raw(), dst.Raw()},
+			Transport: [2][]byte{sport.Raw(), dport.Raw()},
+			StreamID:  m.StreamID,
+			Offset:    m.Offset,
+			FirstSeen: m.FirstSeen,
+			LastSeen:  m.LastSeen,
+			Handshake: m.Handshake,
+			Initiator: m.Initiator,
+		})
+
+		return buf.Bytes(), err
+	},
+
+	decode: func(data []byte) (reassembledPacket, error) {
+		var sp spilledPacket
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&sp); err != nil {
+			return reassembledPacket{}, err
+		}
+
+		return reassembledPacket{
+			Body: sp.Body,
+			Meta: FrameMeta{
+				Flow:      gopacket.NewFlow(gopacket.EndpointType(sp.FlowType), sp.Flow[0], sp.Flow[1]),
+				Transport: gopacket.NewFlow(gopacket.EndpointType(sp.PortType), sp.Transport[0], sp.Transport[1]),
+				StreamID:  sp.StreamID,
+				Offset:    sp.Offset,
+				FirstSeen: sp.FirstSeen,
+				LastSeen:  sp.LastSeen,
+				Handshake: sp.Handshake,
+				Initiator: sp.Initiator,
+			},
+		}, nil
+	},
+}
+
+// captureContext implements reassembly.AssemblerContext
+type captureContext gopacket.CaptureInfo
+
+func (c *captureContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+// frameStreamFactory implements reassembly.StreamFactory
 type frameStreamFactory struct {
-	dataCh chan<- reassembledPacket
-	errCh  chan<- error
+	out          *pauseGate
+	errCh        chan<- error
+	onError      func(error)           // optional, called for every error before it's sent on errCh
+	onConnection func(ConnectionEvent) // optional, called when a connection opens or closes
+	stats        *snifferStats
+	logger       *slog.Logger
+	maxFrameSize int
+	lastID       atomic.Uint64
+	running      sync.WaitGroup // halfStreams still decoding
 }
 
-// frameStream handles decoding TCP packets
+// frameStream tracks a TCP connection, handing the bytes of each direction to a halfStream
 type frameStream struct {
+	id             uint64
+	net, transport gopacket.Flow
+	fsm            *reassembly.TCPSimpleFSM
+	halves         [2]*halfStream // indexed by dirIndex
+	onConnection   func(ConnectionEvent)
+	stats          *snifferStats
+	log            *slog.Logger
+
+	mu        sync.Mutex
+	handshake bool
+	initiator reassembly.TCPFlowDirection
+
+	// Connection state, see ConnectionEvent
+	opened     bool
+	running    int // halfStreams still decoding
+	connection ConnectionType
+	firstSeen  time.Time
+	lastSeen   time.Time
+	bytes      uint64
+	frames     uint64
+}
+
+// halfStream decodes Frames from one direction of a TCP connection
+type halfStream struct {
+	conn           *frameStream
+	dir            reassembly.TCPFlowDirection
 	net, transport gopacket.Flow
-	r              tcpreader.ReaderStream
-	dataCh         chan<- reassembledPacket
+	r              streamReader
+	out            *pauseGate
 	errCh          chan<- error
+	onError        func(error)
+	stats          *snifferStats
+	log            *slog.Logger
+	maxFrameSize   int
 }
 
-// New implements StreamFactory.New(), acting as a Factory for each new Flow.
-func (f *frameStreamFactory) New(net, transport gopacket.Flow) tcpassembly.Stream {
+// New implements StreamFactory.New(), acting as a Factory for each new connection.
+func (f *frameStreamFactory) New(net, transport gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	id := f.lastID.Add(1)
 	fs := &frameStream{
-		net:       net,
-		transport: transport,
-		r:         tcpreader.NewReaderStream(),
-		dataCh:    f.dataCh,
-		errCh:     f.errCh,
+		id:           id,
+		net:          net,
+		transport:    transport,
+		fsm:          reassembly.NewTCPSimpleFSM(reassembly.TCPSimpleFSMOptions{SupportMissingEstablishment: true}),
+		onConnection: f.onConnection,
+		stats:        f.stats,
+		log:          f.logger.With("stream", id),
+		running:      2,
 	}
 
-	// Start the Stream or prepare to clench
-	go fs.run()
+	f.stats.openStreams.Add(1)
+	fs.log.Debug("stream created", "flow", net.String(), "transport", transport.String())
+
+	if ac != nil {
+		fs.firstSeen = ac.GetCaptureInfo().Timestamp
+		fs.lastSeen = fs.firstSeen
+	}
+
+	for _, dir := range []reassembly.TCPFlowDirection{reassembly.TCPDirClientToServer, reassembly.TCPDirServerToClient} {
+		h := &halfStream{
+			conn:         fs,
+			dir:          dir,
+			net:          net,
+			transport:    transport,
+			r:            streamReader{chunks: make(chan streamChunk), consumed: make(chan struct{})},
+			out:          f.out,
+			errCh:        f.errCh,
+			onError:      f.onError,
+			stats:        f.stats,
+			maxFrameSize: f.maxFrameSize,
+		}
+
+		if dir == reassembly.TCPDirServerToClient {
+			h.net, h.transport = net.Reverse(), transport.Reverse()
+		}
+		h.log = fs.log.With("flow", h.net.String(), "transport", h.transport.String())
+
+		fs.halves[dirIndex(dir)] = h
+
+		// Start the Stream or prepare to clench
+		f.running.Add(1)
+		go func() {
+			defer f.running.Done()
+			h.run()
+		}()
+	}
 
-	// ReaderStream implements tcpassembly.Stream so return a pointer to it
-	return &fs.r
+	return fs
 }
 
-// Run the stream, quickly
-func (f *frameStream) run() {
-	var reader = bufio.NewReaderSize(&f.r, 128*1024)
+func dirIndex(dir reassembly.TCPFlowDirection) int {
+	if dir == reassembly.TCPDirClientToServer {
+		return 0
+	}
+	return 1
+}
+
+// Accept implements Stream.Accept(). Packets are accepted regardless of their checksum,
+// since offloading leaves outgoing checksums unset in most captures.
+func (f *frameStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	if !f.fsm.CheckState(tcp, dir) {
+		return false
+	}
+
+	f.mu.Lock()
+	if tcp.SYN && !tcp.ACK {
+		f.handshake, f.initiator = true, dir
+	}
+	if ci.Timestamp.After(f.lastSeen) {
+		f.lastSeen = ci.Timestamp
+	}
+	f.mu.Unlock()
+
+	// Pick up connections established before the capture started
+	*start = true
+
+	return true
+}
+
+// ReassembledSG implements Stream.ReassembledSG(), passing the in-order bytes on to the
+// reader of their direction.
+func (f *frameStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, _, end, skip := sg.Info()
+	h := f.halves[dirIndex(dir)]
+
+	if skip > 0 {
+		h.log.Warn("stream gap", "bytes", skip)
+		h.report(ErrStreamGap{Flow: h.net, Transport: h.transport, Bytes: skip})
+		h.r.send(streamChunk{gap: skip})
+	}
+
+	if length, _ := sg.Lengths(); length > 0 {
+		f.mu.Lock()
+		f.bytes += uint64(length)
+		f.mu.Unlock()
+
+		f.stats.bytesAssembled.Add(uint64(length))
+
+		// The ScatterGather is reused once we return
+		h.r.send(streamChunk{
+			data:  append([]byte(nil), sg.Fetch(length)...),
+			first: sg.CaptureInfo(0).Timestamp,
+			last:  sg.CaptureInfo(length - 1).Timestamp,
+		})
+	}
+
+	if end {
+		h.r.close()
+	}
+}
+
+// ReassemblyComplete implements Stream.ReassemblyComplete(), ending both directions.
+func (f *frameStream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	for _, h := range f.halves {
+		h.r.close()
+	}
+
+	return true
+}
+
+// frameRead counts a Frame decoded from the connection, opening it on the first one.
+func (f *frameStream) frameRead(data []byte) {
+	f.stats.framesDecoded.Add(1)
+
+	f.mu.Lock()
+	f.frames++
+
+	if f.opened {
+		f.mu.Unlock()
+		return
+	}
+
+	f.opened = true
+	f.connection = connectionType(binary.LittleEndian.Uint16(data[28:30]))
+	event := f.event(ConnectionOpened)
+	f.mu.Unlock()
+
+	f.log.Info("connection opened", "connection", event.Connection.String(), "flow", event.Flow.String(), "transport", event.Transport.String())
+
+	if f.onConnection != nil {
+		f.onConnection(event)
+	}
+}
+
+// halfDone closes the connection once both directions are finished.
+func (f *frameStream) halfDone() {
+	f.mu.Lock()
+	f.running--
+
+	if f.running == 0 {
+		f.stats.openStreams.Add(-1)
+	}
+
+	if f.running > 0 || !f.opened {
+		f.mu.Unlock()
+		return
+	}
+
+	event := f.event(ConnectionClosed)
+	f.mu.Unlock()
+
+	f.log.Info("connection closed", "connection", event.Connection.String(), "bytes", event.Bytes, "frames", event.Frames,
+		"duration", event.LastSeen.Sub(event.FirstSeen))
+
+	if f.onConnection != nil {
+		f.onConnection(event)
+	}
+}
+
+// event returns a ConnectionEvent for the current state, f.mu must be held.
+func (f *frameStream) event(t ConnectionEventType) ConnectionEvent {
+	return ConnectionEvent{
+		Type:       t,
+		StreamID:   f.id,
+		Connection: f.connection,
+		Flow:       f.net,
+		Transport:  f.transport,
+		FirstSeen:  f.firstSeen,
+		LastSeen:   f.lastSeen,
+		Bytes:      f.bytes,
+		Frames:     f.frames,
+	}
+}
+
+// Run the stream, quickly. Bad data is skipped and reported, and the stream only stops
+// once it's closed.
+func (h *halfStream) run() {
+	var reader = bufio.NewReaderSize(&h.r, 128*1024)
+
+	// Keep the assembler from blocking on a stream nobody reads anymore
+	defer h.r.discard()
+	defer h.conn.halfDone()
 
 	for {
 		// Skip to start of a frame
-		err := discardUntilValid(reader)
-		if err != nil {
-			f.reportError(fmt.Errorf("error syncing Frame start position: %w", err))
-			return
+		offset := h.offset(reader)
+		h.r.release(offset)
+		skipped, err := discardUntilValid(reader)
+
+		if errors.Is(err, errStreamGap) {
+			// The gap was already reported, drop whatever partial Frame was buffered
+			reader.Reset(&h.r)
+			continue
 		}
 
-		// Grab the synced header bytes so we can make sure we have enough data
-		header, err := reader.Peek(frameHeaderLength)
 		if err != nil {
-			f.reportError(fmt.Errorf("can't peek into header bytes from buffer: %w", err))
+			if !errors.Is(err, io.EOF) {
+				h.log.Warn("frame sync failed", "offset", offset, "error", err)
+				h.reportError(fmt.Errorf("error syncing Frame start position: %w", err))
+			}
 			return
 		}
 
-		// Make a buffer for the full Frame size
-		length := binary.LittleEndian.Uint32(header[24:28])
-		data := make([]byte, int(length))
-
-		count, err := reader.Read(data)
-		if err != nil {
-			f.reportError(fmt.Errorf("can't read %d bytes from buffer: %w", length, err))
-			return
+		if skipped > 0 {
+			h.stats.bytesResynced.Add(uint64(skipped))
+			h.log.Warn("stream resync", "offset", offset, "skipped", skipped)
+			h.report(ErrStreamResync{Flow: h.net, Transport: h.transport, Offset: offset, Skipped: skipped})
 		}
 
-		if count != int(length) {
-			f.reportError(fmt.Errorf("read less data than expected: %d < %d", count, length))
+		offset += int64(skipped)
+		data, err := readFrame(reader, h.maxFrameSize)
+
+		var invalid ErrInvalidFrameLength
+		switch {
+		case err == nil:
+			h.conn.frameRead(data)
+			h.out.send(reassembledPacket{Body: data, Meta: h.meta(offset, len(data))})
+
+		case errors.Is(err, errStreamGap):
+			reader.Reset(&h.r)
+
+		case errors.As(err, &invalid):
+			// Magic bytes in the middle of something else, look for the next Frame
+			h.log.Warn("invalid frame length", "offset", offset, "length", invalid.Length, "max", invalid.Max)
+			h.report(invalid)
+			h.skip(reader)
+
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			// The stream closed partway through a Frame
+			h.log.Warn("stream closed mid-frame", "offset", offset, "error", err)
+			h.reportError(err)
 			return
+
+		default:
+			h.log.Warn("frame read failed", "offset", offset, "error", err)
+			h.reportError(err)
+			h.skip(reader)
 		}
+	}
+}
+
+// skip steps over the first byte of a bad Frame, so the next resync starts after it.
+func (h *halfStream) skip(reader *bufio.Reader) {
+	n, _ := reader.Discard(1)
+	h.stats.bytesResynced.Add(uint64(n))
+}
 
-		f.dataCh <- reassembledPacket{Body: data, Flow: f.net}
+// offset returns the position of reader in the stream.
+func (h *halfStream) offset(reader *bufio.Reader) int64 {
+	return h.r.offset - int64(reader.Buffered())
+}
+
+// readFrame reads the Frame starting at the current position of reader. The Frame may
+// span any number of TCP segments, but can't be longer than maxSize bytes.
+func readFrame(reader *bufio.Reader, maxSize int) ([]byte, error) {
+	// Grab the synced header bytes so we can make sure we have enough data
+	header, err := reader.Peek(frameHeaderLength)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't peek into header bytes from buffer: %w", err)
+	}
+
+	// Don't trust the length until it's at least plausible
+	length := binary.LittleEndian.Uint32(header[24:28])
+	if length < frameHeaderLength || int64(length) > int64(maxSize) {
+		return nil, ErrInvalidFrameLength{Length: length, Max: maxSize}
+	}
+
+	// Make a buffer for the full Frame size
+	data := make([]byte, int(length))
+
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, fmt.Errorf("can't read %d bytes from buffer: %w", length, err)
+	}
+
+	return data, nil
+}
+
+// meta returns the FrameMeta for a Frame of length bytes read at offset in the stream.
+func (h *halfStream) meta(offset int64, length int) FrameMeta {
+	first, last := h.r.seen(offset, offset+int64(length))
+
+	h.conn.mu.Lock()
+	defer h.conn.mu.Unlock()
+
+	return FrameMeta{
+		Flow:      h.net,
+		Transport: h.transport,
+		StreamID:  h.conn.id,
+		Offset:    offset,
+		FirstSeen: first,
+		LastSeen:  last,
+		Handshake: h.conn.handshake,
+		Initiator: h.conn.handshake && h.conn.initiator == h.dir,
 	}
 }
 
-func (f *frameStream) reportError(err error) {
-	if f.errCh != nil {
+func (h *halfStream) reportError(err error) {
+	h.report(ErrReassemblyError{Err: err})
+}
+
+// report calls onError and sends err on errCh, dropping it if the buffer is full.
+func (h *halfStream) report(err error) {
+	if h.onError != nil {
+		h.onError(err)
+	}
+
+	if h.errCh != nil {
 		select {
-		case f.errCh <- ErrReassemblyError{Err: err}:
+		case h.errCh <- err:
 		default:
+			h.stats.errorsDropped.Add(1)
+			h.log.Debug("error dropped", "error", err)
+		}
+	}
+}
+
+// errStreamGap is returned by a streamReader where bytes are missing from the stream.
+var errStreamGap = errors.New("gap in stream")
+
+// streamChunk is a run of reassembled bytes with the capture timestamps of its first and
+// last packets, or the number of bytes missing.
+type streamChunk struct {
+	data        []byte
+	first, last time.Time
+	gap         int
+}
+
+// chunkSpan is the position of a streamChunk in the stream.
+type chunkSpan struct {
+	start, end  int64
+	first, last time.Time
+}
+
+// streamReader is an io.Reader over the reassembled bytes of one direction of a
+// connection. Writes block until the reader has used up the bytes and comes back for more,
+// or the reader is discarded, so anything reported about them is reported before the
+// packet holding them is recorded.
+type streamReader struct {
+	chunks    chan streamChunk
+	consumed  chan struct{} // handed back for each chunk once the reader is done with it
+	pending   bool          // a chunk was received but not handed back yet
+	current   []byte
+	offset    int64       // bytes read or lost to gaps
+	spans     []chunkSpan // chunks read but not yet released
+	closeOnce sync.Once
+}
+
+func (r *streamReader) send(c streamChunk) {
+	r.chunks <- c
+	<-r.consumed
+}
+
+// done hands the last chunk back to its sender.
+func (r *streamReader) done() {
+	if r.pending {
+		r.pending = false
+		r.consumed <- struct{}{}
+	}
+}
+
+func (r *streamReader) close() {
+	r.closeOnce.Do(func() { close(r.chunks) })
+}
+
+// Read returns reassembled bytes, errStreamGap once for each gap, and io.EOF after close.
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		r.done()
+
+		c, ok := <-r.chunks
+		if !ok {
+			return 0, io.EOF
 		}
+		r.pending = true
+
+		if c.gap > 0 {
+			r.offset += int64(c.gap)
+			return 0, errStreamGap
+		}
+
+		r.current = c.data
+		r.spans = append(r.spans, chunkSpan{start: r.offset, end: r.offset + int64(len(c.data)), first: c.first, last: c.last})
+	}
+
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	r.offset += int64(n)
+
+	return n, nil
+}
+
+// seen returns the capture timestamps of the first and last packets holding the bytes
+// from start to end in the stream.
+func (r *streamReader) seen(start, end int64) (first, last time.Time) {
+	for _, s := range r.spans {
+		if start >= s.start && start < s.end {
+			first = s.first
+		}
+		if end > s.start && end <= s.end {
+			last = s.last
+		}
+	}
+
+	return first, last
+}
+
+// release forgets the chunks before offset in the stream.
+func (r *streamReader) release(offset int64) {
+	i := 0
+	for i < len(r.spans) && r.spans[i].end <= offset {
+		i++
+	}
+
+	r.spans = append(r.spans[:0], r.spans[i:]...)
+}
+
+// discard drops everything sent until the reader is closed.
+func (r *streamReader) discard() {
+	r.current = nil
+	r.done()
+
+	for range r.chunks {
+		r.consumed <- struct{}{}
 	}
 }
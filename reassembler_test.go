@@ -0,0 +1,323 @@
+package zanarkand
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+func TestSnifferStreamGap(t *testing.T) {
+	ping := func(id uint32) []byte {
+		return wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: id})
+	}
+	first, lost, last := ping(1), ping(2), ping(3)
+
+	packets := tcpStream(t, 55021, first, lost[:30], lost[30:], last)
+	packets = append(packets[:2], packets[3:]...) // drop the start of the second Frame
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, len(packets))
+	defer handle.Close()
+
+	// Flush out-of-order data as soon as a second segment is waiting
+	sniffer, err := NewSnifferFromHandle(handle, WithReassemblyBufferLimits(1, 0), WithErrorBufferSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+	defer sniffer.Stop()
+
+	for !sniffer.IsActive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, p := range packets {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frames := make(chan *Frame, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			frame, err := sniffer.NextFrame()
+			if err != nil {
+				return
+			}
+			frames <- frame
+		}
+	}()
+
+	for _, want := range [][]byte{first, last} {
+		select {
+		case frame := <-frames:
+			if !bytes.Equal(frame.Body, want[frameHeaderLength:]) {
+				t.Errorf("Unexpected frame body %X, expected %X", frame.Body, want[frameHeaderLength:])
+			}
+
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a frame")
+		}
+	}
+
+	select {
+	case err := <-sniffer.Errors():
+		var gap ErrStreamGap
+		if !errors.As(err, &gap) || gap.Bytes != 30 {
+			t.Errorf("Expected a 30 byte ErrStreamGap, got %v", err)
+		}
+
+		if src, _ := gap.Transport.Endpoints(); src != layers.NewTCPPortEndpoint(55021) {
+			t.Errorf("Expected the gap on the server's direction, got %s", gap.Transport)
+		}
+
+	default:
+		t.Error("Expected an ErrStreamGap")
+	}
+}
+
+func TestSnifferFrameLength(t *testing.T) {
+	ping := wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: 1})
+
+	header := func(length uint32) []byte {
+		h := append([]byte(nil), ping[:frameHeaderLength]...)
+		binary.LittleEndian.PutUint32(h[24:28], length)
+		return h
+	}
+
+	// A short and an oversize header before a Frame split over several segments
+	packets := tcpStream(t, 55021, header(10), header(5000), ping[:8], ping[8:45], ping[45:])
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, len(packets))
+	defer handle.Close()
+
+	sniffer, err := NewSnifferFromHandle(handle, WithMaxFrameSize(4096), WithErrorBufferSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+	defer sniffer.Stop()
+
+	for !sniffer.IsActive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, p := range packets {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frames := make(chan *Frame, 1)
+	go func() {
+		if frame, err := sniffer.NextFrame(); err == nil {
+			frames <- frame
+		}
+	}()
+
+	select {
+	case frame := <-frames:
+		if !bytes.Equal(frame.Body, ping[frameHeaderLength:]) {
+			t.Errorf("Unexpected frame body %X", frame.Body)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a frame")
+	}
+
+	// Each bogus header is reported, then the rest of it is skipped
+	want := []error{
+		ErrInvalidFrameLength{Length: 10, Max: 4096},
+		ErrStreamResync{Offset: 1, Skipped: 39},
+		ErrInvalidFrameLength{Length: 5000, Max: 4096},
+		ErrStreamResync{Offset: 41, Skipped: 39},
+	}
+
+	for _, w := range want {
+		select {
+		case err := <-sniffer.Errors():
+			if resync, ok := err.(ErrStreamResync); ok {
+				resync.Flow, resync.Transport = gopacket.Flow{}, gopacket.Flow{}
+				err = resync
+			}
+
+			if err != w {
+				t.Errorf("Expected %v, got %v", w, err)
+			}
+
+		default:
+			t.Errorf("Expected %v", w)
+		}
+	}
+}
+
+func TestSnifferStreamResync(t *testing.T) {
+	ping := func(id uint32) []byte {
+		return wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: id})
+	}
+	first, second := ping(1), ping(2)
+
+	junk := func(n int) []byte { return bytes.Repeat([]byte{0xAA}, n) }
+
+	stream := append(append(append(junk(5), first...), junk(7)...), second...)
+	packets := tcpStream(t, 55021, stream[:20], stream[20:])
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, len(packets))
+	defer handle.Close()
+
+	sniffer, err := NewSnifferFromHandle(handle, WithErrorBufferSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+	defer sniffer.Stop()
+
+	for !sniffer.IsActive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, p := range packets {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frames := make(chan *Frame, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			frame, err := sniffer.NextFrame()
+			if err != nil {
+				return
+			}
+			frames <- frame
+		}
+	}()
+
+	for _, want := range [][]byte{first, second} {
+		select {
+		case frame := <-frames:
+			if !bytes.Equal(frame.Body, want[frameHeaderLength:]) {
+				t.Errorf("Unexpected frame body %X", frame.Body)
+			}
+
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a frame")
+		}
+	}
+
+	for _, want := range []ErrStreamResync{{Offset: 0, Skipped: 5}, {Offset: int64(5 + len(first)), Skipped: 7}} {
+		select {
+		case err := <-sniffer.Errors():
+			var resync ErrStreamResync
+			if !errors.As(err, &resync) || resync.Offset != want.Offset || resync.Skipped != want.Skipped {
+				t.Errorf("Expected a resync skipping %d bytes at %d, got %v", want.Skipped, want.Offset, err)
+			}
+
+			if src, _ := resync.Flow.Endpoints(); src != layers.NewIPEndpoint(testServerIP) {
+				t.Errorf("Expected the resync on the server's flow, got %s", resync.Flow)
+			}
+
+		default:
+			t.Errorf("Expected a resync skipping %d bytes at %d", want.Skipped, want.Offset)
+		}
+	}
+}
+
+func TestSnifferFrameMeta(t *testing.T) {
+	ping := func(id uint32) []byte {
+		return wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: id})
+	}
+	first, second, reply := ping(1), ping(2), ping(3)
+
+	// The second Frame spans three packets, after 4 bytes of junk
+	stream := append(append(append([]byte(nil), first...), 0, 0, 0, 0), second...)
+	server := tcpStream(t, 55021, stream[:len(first)+10], stream[len(first)+10:len(first)+30], stream[len(first)+30:])
+	client := tcpPackets(t, testClientIP, testServerIP, 50000, 55021, reply)
+	other := tcpReply(t, testServerIP, testClientIP, 55022, 50001, ping(4))
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, 16)
+	defer handle.Close()
+
+	sniffer, err := NewSnifferFromHandle(handle, WithErrorBufferSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+	defer sniffer.Stop()
+
+	for !sniffer.IsActive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	ts := time.Unix(1580625008, 0)
+	for i, p := range append(append(server, client...), other...) {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{Timestamp: ts.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frames := make(map[uint32]FrameMeta)
+	for len(frames) < 4 {
+		next := make(chan *Frame, 1)
+		go func() {
+			if frame, err := sniffer.NextFrame(); err == nil {
+				next <- frame
+			}
+		}()
+
+		select {
+		case frame := <-next:
+			// The keepalive ID follows the 16 byte message header
+			frames[binary.LittleEndian.Uint32(frame.Body[16:20])] = *frame.Meta()
+
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a frame")
+		}
+	}
+
+	tests := []struct {
+		id          uint32
+		offset      int64
+		first, last int
+	}{
+		{1, 0, 1, 1},                     // packet 0 is the SYN-ACK
+		{2, int64(len(first)) + 4, 1, 3}, // split over three packets
+		{3, 0, 5, 5},                     // the client's direction
+		{4, 0, 7, 7},                     // another connection
+	}
+
+	for _, tt := range tests {
+		meta := frames[tt.id]
+
+		if meta.Offset != tt.offset {
+			t.Errorf("Frame %d: expected offset %d, got %d", tt.id, tt.offset, meta.Offset)
+		}
+
+		if want := ts.Add(time.Duration(tt.first) * time.Second); !meta.FirstSeen.Equal(want) {
+			t.Errorf("Frame %d: expected first seen %v, got %v", tt.id, want, meta.FirstSeen)
+		}
+
+		if want := ts.Add(time.Duration(tt.last) * time.Second); !meta.LastSeen.Equal(want) {
+			t.Errorf("Frame %d: expected last seen %v, got %v", tt.id, want, meta.LastSeen)
+		}
+	}
+
+	if frames[1].StreamID == 0 || frames[1].StreamID != frames[2].StreamID || frames[1].StreamID != frames[3].StreamID {
+		t.Errorf("Expected one StreamID for both directions, got %d, %d and %d", frames[1].StreamID, frames[2].StreamID, frames[3].StreamID)
+	}
+
+	if frames[4].StreamID == frames[1].StreamID {
+		t.Errorf("Expected a new StreamID for another connection, got %d", frames[4].StreamID)
+	}
+}
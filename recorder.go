@@ -0,0 +1,307 @@
+package zanarkand
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcapgo"
+)
+
+// RecordFormat is the file format written by WithRecording.
+type RecordFormat int
+
+const (
+	RecordPcap RecordFormat = iota
+	RecordPcapNG
+)
+
+func (f RecordFormat) String() string {
+	switch f {
+	case RecordPcap:
+		return "pcap"
+	case RecordPcapNG:
+		return "pcapng"
+	default:
+		return "unknown"
+	}
+}
+
+// RecordOption configures a recording started with WithRecording.
+type RecordOption func(*recorderConfig)
+
+type recorderConfig struct {
+	path     string
+	format   RecordFormat
+	maxSize  int64
+	interval time.Duration
+}
+
+// maxPendingComments bounds how many reassembly errors are held for the next packet.
+const maxPendingComments = 16
+
+// recordSnapLen is the snapshot length written to pcap file headers.
+const recordSnapLen = 65535
+
+// recordRetryDelay is how long, going by capture timestamps, a recorder drops packets
+// before retrying a file it couldn't open.
+const recordRetryDelay = 10 * time.Second
+
+// WithRecordFormat sets the recording file format. The default is chosen from the
+// file extension, with ".pcapng" selecting RecordPcapNG and anything else RecordPcap.
+func WithRecordFormat(f RecordFormat) RecordOption {
+	return func(c *recorderConfig) { c.format = f }
+}
+
+// WithRotateSize starts a new recording file once the current one reaches
+// roughly n bytes. Zero disables size-based rotation.
+func WithRotateSize(n int64) RecordOption {
+	return func(c *recorderConfig) { c.maxSize = n }
+}
+
+// WithRotateInterval starts a new recording file once the packets in the current
+// one span d, going by capture timestamps. Zero disables time-based rotation.
+func WithRotateInterval(d time.Duration) RecordOption {
+	return func(c *recorderConfig) { c.interval = d }
+}
+
+// WithRecording tees every packet that passes the capture filter to a pcap or
+// pcapng file at path, so sessions can be replayed later with the "file" mode.
+//
+// The first file is written to path. Rotated files, and files opened when a
+// stopped Sniffer is started again, insert a sequence number before the extension,
+// e.g. session.1.pcapng. pcapng recordings carry the capture interface and filter,
+// and attach a comment for each reassembly error to the packet that caused it. Errors
+// found while flushing idle connections go on the next packet recorded.
+//
+// Write failures are sent to Sniffer.Errors as ErrRecordingFailure and don't stop capture.
+// If a file can't be opened, packets are dropped from the recording for 10 seconds of
+// capture time before it's tried again, and the failure is only reported once.
+func WithRecording(path string, opts ...RecordOption) Option {
+	return func(c *snifferConfig) {
+		rc := &recorderConfig{path: path, format: RecordPcap}
+		if strings.EqualFold(filepath.Ext(path), ".pcapng") {
+			rc.format = RecordPcapNG
+		}
+
+		for _, opt := range opts {
+			opt(rc)
+		}
+
+		c.recording = rc
+	}
+}
+
+// recorder writes captured packets to rotating pcap or pcapng files.
+type recorder struct {
+	cfg   recorderConfig
+	iface pcapgo.NgInterface
+
+	// Reassembly errors are reported from stream goroutines
+	mu       sync.Mutex
+	comments []string
+
+	// Everything else is only touched from Sniffer.Start
+	file    *os.File
+	buf     *bufio.Writer
+	count   *countingWriter
+	pcap    *pcapgo.Writer
+	ng      *pcapgo.NgWriter
+	first   time.Time
+	seq     int
+	current string
+
+	// Set while the next file can't be opened
+	failed  bool
+	retryAt time.Time
+}
+
+func newRecorder(cfg recorderConfig, linkType layers.LinkType, source, filter string) *recorder {
+	return &recorder{
+		cfg: cfg,
+		iface: pcapgo.NgInterface{
+			Name:                source,
+			Description:         "zanarkand capture",
+			Filter:              filter,
+			OS:                  runtime.GOOS,
+			LinkType:            linkType,
+			TimestampResolution: 9,
+		},
+	}
+}
+
+// annotate queues a reassembly error to be attached to the next recorded packet. Packets
+// are recorded once they've been assembled, so that's the packet which caused it.
+func (r *recorder) annotate(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.comments) < maxPendingComments {
+		r.comments = append(r.comments, err.Error())
+	}
+}
+
+func (r *recorder) takeComments() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	comments := r.comments
+	r.comments = nil
+	return comments
+}
+
+// write records a single packet, rotating or opening files as needed.
+func (r *recorder) write(ci gopacket.CaptureInfo, data []byte) error {
+	if r.file != nil && r.shouldRotate(ci.Timestamp) {
+		if err := r.close(); err != nil {
+			return err
+		}
+	}
+
+	if r.file == nil {
+		if r.failed && ci.Timestamp.Before(r.retryAt) {
+			return nil
+		}
+
+		if err := r.open(ci.Timestamp); err != nil {
+			// Only report the first failure until a file opens again
+			reported := r.failed
+			r.failed, r.retryAt = true, ci.Timestamp.Add(recordRetryDelay)
+			if reported {
+				return nil
+			}
+			return err
+		}
+
+		r.failed = false
+	}
+
+	// Recordings have a single interface, whatever the capture handle reported
+	ci.InterfaceIndex = 0
+
+	var err error
+	if r.ng != nil {
+		err = r.ng.WritePacketWithOptions(ci, data, pcapgo.NgPacketOptions{Comments: r.takeComments()})
+	} else {
+		err = r.pcap.WritePacket(ci, data)
+	}
+
+	if err != nil {
+		return ErrRecordingFailure{Path: r.current, Err: err}
+	}
+
+	return nil
+}
+
+func (r *recorder) shouldRotate(ts time.Time) bool {
+	if r.cfg.maxSize > 0 && r.size() >= r.cfg.maxSize {
+		return true
+	}
+
+	return r.cfg.interval > 0 && ts.Sub(r.first) >= r.cfg.interval
+}
+
+// size returns the bytes written to the current file, including anything still buffered.
+func (r *recorder) size() int64 {
+	if r.ng != nil {
+		// The NgWriter buffers internally, so only flushed bytes are counted
+		return r.count.n
+	}
+
+	return r.count.n + int64(r.buf.Buffered())
+}
+
+func (r *recorder) filename() string {
+	if r.seq == 0 {
+		return r.cfg.path
+	}
+
+	ext := filepath.Ext(r.cfg.path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(r.cfg.path, ext), r.seq, ext)
+}
+
+// open creates the next file. The sequence number only moves on once it's open, so a
+// failed file is retried under the same name.
+func (r *recorder) open(ts time.Time) error {
+	r.current = r.filename()
+
+	f, err := os.Create(r.current)
+	if err != nil {
+		return ErrRecordingFailure{Path: r.current, Err: err}
+	}
+
+	r.count = &countingWriter{w: f}
+
+	switch r.cfg.format {
+	case RecordPcapNG:
+		r.ng, err = pcapgo.NewNgWriterInterface(r.count, r.iface, pcapgo.NgWriterOptions{
+			SectionInfo: pcapgo.NgSectionInfo{
+				Hardware:    runtime.GOARCH,
+				OS:          runtime.GOOS,
+				Application: "zanarkand",
+			},
+		})
+
+	default:
+		r.buf = bufio.NewWriter(r.count)
+		r.pcap = pcapgo.NewWriterNanos(r.buf)
+		err = r.pcap.WriteFileHeader(recordSnapLen, r.iface.LinkType)
+	}
+
+	if err != nil {
+		_ = f.Close()
+		r.ng, r.pcap, r.buf = nil, nil, nil
+		return ErrRecordingFailure{Path: r.current, Err: err}
+	}
+
+	r.file = f
+	r.first = ts
+	r.seq++
+	return nil
+}
+
+// close flushes and closes the current file, if any.
+func (r *recorder) close() error {
+	if r.file == nil {
+		return nil
+	}
+
+	var err error
+	if r.ng != nil {
+		err = r.ng.Flush()
+	} else {
+		err = r.buf.Flush()
+	}
+
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	r.file, r.buf, r.count, r.pcap, r.ng = nil, nil, nil, nil, nil
+
+	if err != nil {
+		return ErrRecordingFailure{Path: r.current, Err: err}
+	}
+
+	return nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,262 @@
+package zanarkand
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcapgo"
+)
+
+func testRecorder(t *testing.T, name string, opts ...RecordOption) (*recorder, string) {
+	t.Helper()
+
+	var cfg snifferConfig
+	path := filepath.Join(t.TempDir(), name)
+	WithRecording(path, opts...)(&cfg)
+
+	return newRecorder(*cfg.recording, layers.LinkTypeEthernet, "eth0", "tcp portrange 54992-54994"), path
+}
+
+func recordPackets(t *testing.T, r *recorder, start time.Time, count int, gap time.Duration) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		data := make([]byte, 100)
+		data[0] = byte(i)
+
+		ci := gopacket.CaptureInfo{Timestamp: start.Add(time.Duration(i) * gap), CaptureLength: len(data), Length: len(data), InterfaceIndex: 3}
+		if err := r.write(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecorderPcapNGComments(t *testing.T) {
+	r, path := testRecorder(t, "session.pcapng")
+	if r.cfg.format != RecordPcapNG {
+		t.Fatalf("Expected pcapng format from the extension, got %s", r.cfg.format)
+	}
+
+	start := time.Unix(1580625008, 0)
+	recordPackets(t, r, start, 1, 0)
+
+	r.annotate(ErrReassemblyError{Err: errors.New("lost 40 bytes")})
+	recordPackets(t, r, start.Add(time.Second), 1, 0)
+
+	// The second Start opens a new file rather than truncating the first
+	for _, name := range []string{path, filepath.Join(filepath.Dir(path), "session.1.pcapng")} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		ng, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		intf, err := ng.Interface(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if intf.Name != "eth0" || intf.Filter != "tcp portrange 54992-54994" || intf.LinkType != layers.LinkTypeEthernet {
+			t.Errorf("%s: unexpected interface metadata %+v", name, intf)
+		}
+
+		_, _, opts, err := ng.ReadPacketDataWithOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name == path && len(opts.Comments) != 0 {
+			t.Errorf("Expected no comments on the first packet, got %v", opts.Comments)
+		}
+
+		if name != path && (len(opts.Comments) != 1 || opts.Comments[0] != "reassembly error: lost 40 bytes") {
+			t.Errorf("Expected the reassembly error as a comment, got %v", opts.Comments)
+		}
+	}
+}
+
+func TestRecorderRotation(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []RecordOption
+		files int
+	}{
+		// 24 byte header plus 116 bytes per packet, so 3 packets per file
+		{"size", []RecordOption{WithRotateSize(300)}, 4},
+		{"interval", []RecordOption{WithRotateInterval(5 * time.Second)}, 2},
+		{"none", nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, path := testRecorder(t, "session.pcap", tt.opts...)
+			recordPackets(t, r, time.Unix(1580625008, 0), 10, time.Second)
+
+			matches, err := filepath.Glob(filepath.Join(filepath.Dir(path), "session*.pcap"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(matches) != tt.files {
+				t.Fatalf("Expected %d files, got %v", tt.files, matches)
+			}
+
+			packets := 0
+			for _, name := range matches {
+				f, err := os.Open(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				pr, err := pcapgo.NewReader(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for {
+					if _, _, err := pr.ReadPacketData(); err != nil {
+						break
+					}
+					packets++
+				}
+				f.Close()
+			}
+
+			if packets != 10 {
+				t.Errorf("Expected 10 packets across all files, got %d", packets)
+			}
+		})
+	}
+}
+
+func TestRecordingCommentsOnCausingPacket(t *testing.T) {
+	ping := func(id uint32) []byte {
+		return wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: id})
+	}
+
+	// The junk before the second Frame is resynced past while assembling packet 2
+	junk := append([]byte{1, 2, 3, 4, 5, 6, 7}, ping(2)...)
+	capture := pcapFile(t, tcpStream(t, 55021, ping(1), junk, ping(3)))
+
+	path := filepath.Join(t.TempDir(), "session.pcapng")
+	sniffer, err := NewSnifferFromReader(bytes.NewReader(capture), WithRecording(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sniffer.Start(context.Background()); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ng, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var comments [][]string
+	for {
+		_, _, opts, err := ng.ReadPacketDataWithOptions()
+		if err != nil {
+			break
+		}
+		comments = append(comments, opts.Comments)
+	}
+
+	if len(comments) != 4 {
+		t.Fatalf("Expected 4 packets recorded, got %d", len(comments))
+	}
+
+	for i, c := range comments {
+		if i == 2 && (len(c) != 1 || !strings.Contains(c[0], "resync")) {
+			t.Errorf("Expected the resync comment on packet 2, got %v", c)
+		}
+		if i != 2 && len(c) != 0 {
+			t.Errorf("Expected no comments on packet %d, got %v", i, c)
+		}
+	}
+}
+
+func TestRecorderOpenFailure(t *testing.T) {
+	r, path := testRecorder(t, "session.pcap", WithRotateSize(300))
+	dir := filepath.Dir(path)
+
+	start := time.Unix(1580625008, 0)
+	write := func(i int) error {
+		data := make([]byte, 100)
+		return r.write(gopacket.CaptureInfo{Timestamp: start.Add(time.Duration(i) * time.Second), CaptureLength: len(data), Length: len(data)}, data)
+	}
+
+	// Fill the first file, then take the directory away before it rotates
+	for i := 0; i < 3; i++ {
+		if err := write(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	failures := 0
+	for i := 3; i < 8; i++ {
+		if err := write(i); err != nil {
+			var recording ErrRecordingFailure
+			if !errors.As(err, &recording) {
+				t.Fatalf("Expected an ErrRecordingFailure, got %v", err)
+			}
+			failures++
+		}
+	}
+
+	if failures != 1 {
+		t.Errorf("Expected the open failure to be reported once, got %d", failures)
+	}
+	if r.seq != 1 {
+		t.Errorf("Expected the sequence number to stay at 1, got %d", r.seq)
+	}
+
+	// Once the directory is back, the next file opens after the retry delay
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := write(8); err != nil {
+		t.Fatal(err)
+	}
+	if r.file != nil {
+		t.Fatal("Expected the recorder to wait before retrying")
+	}
+
+	if err := write(3 + int(recordRetryDelay/time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "session.1.pcap")); err != nil {
+		t.Errorf("Expected the retried file to be session.1.pcap: %v", err)
+	}
+}
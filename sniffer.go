@@ -3,18 +3,18 @@ package zanarkand
 import (
 	"bufio"
 	"bytes"
-	"compress/zlib"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"runtime/trace"
 	"sync"
 	"time"
 
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/layers"
-	"github.com/gopacket/gopacket/pcap"
-	"github.com/gopacket/gopacket/tcpassembly"
+	"github.com/gopacket/gopacket/reassembly"
 
 	"github.com/ayyaruq/zanarkand/devices"
 )
@@ -23,9 +23,11 @@ import (
 type SnifferState int
 
 const (
-	SnifferStopped SnifferState = iota
-	SnifferRunning
-	SnifferFinished
+	SnifferStopped  SnifferState = iota // created, or stopped and ready to Start again
+	SnifferRunning                      // capturing
+	SnifferFinished                     // an offline capture was exhausted
+	SnifferPaused                       // capturing, but holding Frames back until Resume
+	SnifferClosed                       // closed, along with the capture handle
 )
 
 func (s SnifferState) String() string {
@@ -36,6 +38,10 @@ func (s SnifferState) String() string {
 		return "running"
 	case SnifferFinished:
 		return "finished"
+	case SnifferPaused:
+		return "paused"
+	case SnifferClosed:
+		return "closed"
 	default:
 		return "unknown"
 	}
@@ -43,17 +49,33 @@ func (s SnifferState) String() string {
 
 // Sniffer is a representation of a packet source, filter, and destination.
 type Sniffer struct {
-	mu    sync.RWMutex
-	state SnifferState
+	mu        sync.RWMutex
+	state     SnifferState
+	run       *run
+	gate      *pauseGate
+	closeOnce sync.Once
 
 	dataCh chan reassembledPacket
+	data   *queue[reassembledPacket]
 	errCh  chan error
-	ctx    context.Context
-	cancel context.CancelFunc
+	connCh chan ConnectionEvent
 
-	factory   tcpassembly.StreamFactory
-	pool      *tcpassembly.StreamPool
-	assembler *tcpassembly.Assembler
+	decompressors *DecompressorRegistry
+	recorder      *recorder
+	direction     DirectionStrategy
+	observer      Observer
+	logger        *slog.Logger
+
+	handle     devices.DeviceHandle
+	stats      *snifferStats
+	spillDir   string
+	spillLimit int64
+	idle       time.Duration
+
+	packets   *packetReader
+	factory   *frameStreamFactory
+	pool      *reassembly.StreamPool
+	assembler *reassembly.Assembler
 
 	Source *gopacket.PacketSource
 }
@@ -62,16 +84,40 @@ type Sniffer struct {
 type Option func(*snifferConfig)
 
 type snifferConfig struct {
-	dataBufSize int
-	errBufSize  int
+	dataBufSize   int
+	errBufSize    int
+	connBufSize   int
+	connHandler   func(ConnectionEvent)
+	portRanges    []PortRange
+	bpfFilter     string
+	decompressors *DecompressorRegistry
+	recording     *recorderConfig
+	direction     DirectionStrategy
+	observer      Observer
+	logger        *slog.Logger
+	backpressure  BackpressurePolicy
+	spillDir      string
+	spillLimit    int64
+	pauseLimit    int
+	maxPagesConn  int
+	maxPagesTotal int
+	maxFrameSize  int
+	idleTimeout   time.Duration
 }
 
 // Default buffer sizes
 const (
 	defaultDataBufSize = 200
 	defaultErrBufSize  = 1
+	defaultConnBufSize = 16
 )
 
+// defaultIdleTimeout is how long a connection may be silent before it's closed
+const defaultIdleTimeout = 2 * time.Minute
+
+// flushInterval is how often out-of-order data and idle connections are flushed
+const flushInterval = 3 * time.Second
+
 // WithDataBufferSize sets the buffer size for the frame data channel.
 // This controls how many reassembled frames can be queued before the
 // reassembler goroutines block. The default is 200.
@@ -85,28 +131,47 @@ func WithErrorBufferSize(n int) Option {
 	return func(c *snifferConfig) { c.errBufSize = n }
 }
 
+// WithReassemblyBufferLimits sets how many pages of out-of-order data are buffered
+// while waiting for missing segments, per connection and in total. A page holds up to
+// 1900 bytes. Once a limit is reached, the oldest data is flushed and the missing bytes
+// are reported as an ErrStreamGap. Values <= 0 remove the limit. The defaults are 32
+// and 192.
+func WithReassemblyBufferLimits(perConnection, total int) Option {
+	return func(c *snifferConfig) { c.maxPagesConn, c.maxPagesTotal = perConnection, total }
+}
+
+// WithMaxFrameSize sets the largest Frame length accepted from a Frame header, bounding
+// the memory allocated for a corrupt or malicious length. Larger Frames are skipped and
+// reported as an ErrInvalidFrameLength. The default is 1 MiB.
+func WithMaxFrameSize(n int) Option {
+	return func(c *snifferConfig) { c.maxFrameSize = n }
+}
+
+// WithIdleTimeout sets how long a connection may go without packets before it's flushed
+// and closed, for connections that end without a FIN or RST. Idle time is measured on the
+// capture's clock, the packet timestamps, so offline captures close connections as they
+// would have live. Values <= 0 keep idle connections open until the Sniffer stops. The
+// default is 2 minutes.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *snifferConfig) { c.idleTimeout = d }
+}
+
+// WithDirectionStrategy sets how Frames read by the Sniffer decide their Direction.
+// The default checks the configured port ranges, then falls back to private addresses.
+func WithDirectionStrategy(strategy DirectionStrategy) Option {
+	return func(c *snifferConfig) { c.direction = strategy }
+}
+
 // NewSniffer creates a Sniffer instance.
 func NewSniffer(mode, src string, opts ...Option) (*Sniffer, error) {
-	cfg := snifferConfig{
-		dataBufSize: defaultDataBufSize,
-		errBufSize:  defaultErrBufSize,
-	}
-	for _, opt := range opts {
-		opt(&cfg)
-	}
+	cfg := newSnifferConfig(opts...)
 
-	dataCh := make(chan reassembledPacket, cfg.dataBufSize)
-	errCh := make(chan error, cfg.errBufSize)
-	streamFactory := &frameStreamFactory{dataCh: dataCh, errCh: errCh}
-	streamPool := tcpassembly.NewStreamPool(streamFactory)
-	assembler := tcpassembly.NewAssembler(streamPool)
-	assembler.AssemblerOptions.MaxBufferedPagesPerConnection = 32
-	assembler.AssemblerOptions.MaxBufferedPagesTotal = 192 // 32 for each of the Client/Server pairs for Lobby, Chat, and Zone
-
-	var err error
 	var handle devices.DeviceHandle
 
-	filter := "tcp portrange 54992-54994 or tcp portrange 55006-55007 or tcp portrange 55021-55040 or tcp portrange 55296-55551"
+	filter, err := cfg.buildFilter()
+	if err != nil {
+		return nil, err
+	}
 
 	if src == "" {
 		return nil, fmt.Errorf("capture handle: no source provided")
@@ -117,38 +182,206 @@ func NewSniffer(mode, src string, opts ...Option) (*Sniffer, error) {
 		handle, err = devices.OpenFile(src, filter)
 
 	case "pcap":
-		handle, err = devices.OpenPcap(src, filter, pcap.BlockForever)
+		handle, err = devices.OpenPcap(src, filter, devices.BlockForever)
 
 	case "pfring":
-		handle, err = devices.OpenPFRing(src, filter, 1600, pcap.BlockForever)
+		handle, err = devices.OpenPFRing(src, filter, 1600, devices.BlockForever)
 
 	case "afpacket":
-		handle, err = devices.OpenAFPacket(src, filter, 25, pcap.BlockForever)
+		handle, err = devices.OpenAFPacket(src, filter, 25, devices.BlockForever)
 
 	default:
 		err = ErrUnknownInput{Err: fmt.Errorf("unknown input type: %s", mode)}
 	}
 
+	var filterErr devices.ErrFilter
+	if errors.As(err, &filterErr) {
+		return nil, ErrInvalidFilter{Filter: filter, Err: filterErr.Err}
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("capture handle: %w", err)
 	}
 
-	return &Sniffer{
-		factory:   streamFactory,
-		pool:      streamPool,
-		assembler: assembler,
-		state:     SnifferStopped,
-		dataCh:    dataCh,
-		errCh:     errCh,
-		Source:    gopacket.NewPacketSource(handle, handle.LinkType()),
-	}, nil
+	sniffer, err := newSniffer(handle, src, filter, cfg)
+	if err != nil {
+		handle.Close()
+		return nil, err
+	}
+
+	return sniffer, nil
 }
 
-// IsActive reports whether the Sniffer is currently capturing.
+// NewSnifferFromReader creates a Sniffer reading an offline pcap or pcapng capture from r,
+// which may be gzip or zstd compressed. Like the "file" mode, Start returns io.EOF once the
+// capture is exhausted.
+//
+// Port ranges are matched in pure Go, so no libpcap is needed unless WithBPFFilter is used.
+// BPF expressions are compiled with libpcap, so builds without cgo return an ErrInvalidFilter
+// wrapping devices.ErrNoLibpcap; open the capture with devices.OpenReader and filter it with
+// SetBPFProgram or SetFilterFunc, then use NewSnifferFromHandle instead.
+func NewSnifferFromReader(r io.Reader, opts ...Option) (*Sniffer, error) {
+	cfg := newSnifferConfig(opts...)
+
+	filter, err := cfg.buildFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := devices.OpenReader(r, cfg.bpfFilter)
+
+	var filterErr devices.ErrFilter
+	if errors.As(err, &filterErr) {
+		return nil, ErrInvalidFilter{Filter: filter, Err: filterErr.Err}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("capture handle: %w", err)
+	}
+
+	decoder, err := linkDecoder(handle.LinkType())
+	if err != nil {
+		handle.Close()
+		return nil, err
+	}
+
+	if cfg.bpfFilter == "" {
+		handle.SetFilterFunc(cfg.portMatcher(decoder))
+	}
+
+	sniffer, err := newSniffer(handle, "reader", filter, cfg)
+	if err != nil {
+		handle.Close()
+		return nil, err
+	}
+
+	return sniffer, nil
+}
+
+// NewSnifferFromHandle creates a Sniffer reading from an already opened DeviceHandle,
+// such as a devices.MemoryHandle in tests or a custom capture source. No capture filter
+// is applied, so the handle should only return FFXIV traffic; port range and BPF
+// filter options are ignored.
+func NewSnifferFromHandle(handle devices.DeviceHandle, opts ...Option) (*Sniffer, error) {
+	if handle == nil {
+		return nil, fmt.Errorf("capture handle: no handle provided")
+	}
+
+	return newSniffer(handle, "handle", "", newSnifferConfig(opts...))
+}
+
+func newSnifferConfig(opts ...Option) snifferConfig {
+	cfg := snifferConfig{
+		dataBufSize:   defaultDataBufSize,
+		errBufSize:    defaultErrBufSize,
+		connBufSize:   defaultConnBufSize,
+		portRanges:    append([]PortRange(nil), DefaultPortRanges...),
+		decompressors: DefaultDecompressors,
+		maxPagesConn:  defaultMaxPagesPerConnection,
+		maxPagesTotal: defaultMaxPagesTotal,
+		maxFrameSize:  defaultMaxFrameSize,
+		idleTimeout:   defaultIdleTimeout,
+		spillLimit:    defaultSpillLimit,
+		pauseLimit:    defaultPauseBufferLimit,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// newSniffer sets up decoding and reassembly for an opened capture handle.
+func newSniffer(handle devices.DeviceHandle, src, filter string, cfg snifferConfig) (*Sniffer, error) {
+	decoder, err := linkDecoder(handle.LinkType())
+	if err != nil {
+		return nil, err
+	}
+
+	logger := cfg.logger
+	if logger == nil {
+		logger = discardLogger
+	}
+
+	direction := cfg.direction
+	if direction == nil {
+		direction = DirectionChain(PortDirection(cfg.portRanges...), PrivateAddressDirection())
+	}
+
+	dataCh := make(chan reassembledPacket, cfg.dataBufSize)
+	data := newQueue("frames", dataCh, cfg.backpressure, packetCodec)
+	gate := newPauseGate(data, cfg.pauseLimit)
+	errCh := make(chan error, cfg.errBufSize)
+	connCh := make(chan ConnectionEvent, cfg.connBufSize)
+	stats := new(snifferStats)
+	streamFactory := &frameStreamFactory{out: gate, errCh: errCh, stats: stats, logger: logger, maxFrameSize: cfg.maxFrameSize}
+	streamFactory.onConnection = func(e ConnectionEvent) {
+		if cfg.connHandler != nil {
+			cfg.connHandler(e)
+		}
+
+		select {
+		case connCh <- e:
+		default:
+			stats.eventsDropped.Add(1)
+			logger.Debug("connection event dropped", "event", e.Type.String(), "stream", e.StreamID)
+		}
+	}
+	streamPool := reassembly.NewStreamPool(streamFactory)
+	assembler := reassembly.NewAssembler(streamPool)
+	assembler.AssemblerOptions.MaxBufferedPagesPerConnection = cfg.maxPagesConn
+	assembler.AssemblerOptions.MaxBufferedPagesTotal = cfg.maxPagesTotal
+
+	var rec *recorder
+	if cfg.recording != nil {
+		rec = newRecorder(*cfg.recording, handle.LinkType(), src, filter)
+	}
+
+	if rec != nil || cfg.observer != nil {
+		streamFactory.onError = func(err error) {
+			if rec != nil {
+				rec.annotate(err)
+			}
+			if cfg.observer != nil {
+				cfg.observer.ObserveError(err)
+			}
+		}
+	}
+
+	sniffer := &Sniffer{
+		recorder:      rec,
+		direction:     direction,
+		observer:      cfg.observer,
+		logger:        logger,
+		handle:        handle,
+		stats:         stats,
+		factory:       streamFactory,
+		pool:          streamPool,
+		assembler:     assembler,
+		state:         SnifferStopped,
+		run:           newRun(),
+		gate:          gate,
+		dataCh:        dataCh,
+		data:          data,
+		spillDir:      cfg.spillDir,
+		spillLimit:    cfg.spillLimit,
+		idle:          cfg.idleTimeout,
+		errCh:         errCh,
+		connCh:        connCh,
+		decompressors: cfg.decompressors,
+		Source:        gopacket.NewPacketSource(handle, decoder),
+	}
+	sniffer.packets = newPacketReader(sniffer.Source)
+	data.attach(sniffer)
+
+	return sniffer, nil
+}
+
+// IsActive reports whether the Sniffer is currently capturing, including while paused.
 func (s *Sniffer) IsActive() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.state == SnifferRunning
+	return s.state == SnifferRunning || s.state == SnifferPaused
 }
 
 // Status returns the current Sniffer state.
@@ -166,57 +399,133 @@ func (s *Sniffer) Errors() <-chan error {
 	return s.errCh
 }
 
-// Start an initialised Sniffer. It blocks until Stop is called or the context is cancelled.
-// For file mode, it returns io.EOF when the file is exhausted.
+// Connections returns a channel that receives a ConnectionEvent when a connection
+// carrying Frames opens and when it closes. The channel is buffered and will drop
+// events if not consumed.
+func (s *Sniffer) Connections() <-chan ConnectionEvent {
+	return s.connCh
+}
+
+// reportError sends an error to the error channel, dropping it if the buffer is full.
+func (s *Sniffer) reportError(err error) {
+	if s.observer != nil {
+		s.observer.ObserveError(err)
+	}
+
+	select {
+	case s.errCh <- err:
+	default:
+		s.stats.errorsDropped.Add(1)
+		s.logger.Debug("error dropped", "error", err)
+	}
+}
+
+// Start an initialised Sniffer. It blocks until Stop is called or the context is
+// cancelled, then returns nil, and the Sniffer may be started again. For file mode, it
+// returns io.EOF once the file is exhausted and its last Frames are queued. Starting a
+// Sniffer that's already started, finished or closed returns an ErrInvalidState; a
+// paused Sniffer counts as started. If Stop was called before Start, Start returns nil
+// straight away without capturing, as described in lifecycle.go.
 func (s *Sniffer) Start(ctx context.Context) error {
-	s.ctx, s.cancel = context.WithCancel(ctx)
-	defer s.cancel()
+	r, err := s.begin(ctx)
+	if r == nil {
+		return err
+	}
+
+	return s.capture(r)
+}
 
-	s.mu.Lock()
-	s.state = SnifferRunning
-	s.mu.Unlock()
+// capture reads and reassembles packets until r is cancelled or the capture is exhausted.
+func (s *Sniffer) capture(r *run) (err error) {
+	defer func() { s.end(r, err) }()
+	defer s.data.close()
 
-	packets := s.Source.Packets()
-	ticker := time.NewTicker(3 * time.Second)
+	// Stopping gives up on Frames nobody's reading, so the connections can be flushed
+	s.gate.start(r.ctx.Done())
+
+	s.logger.Info("sniffer started", "link_type", s.handle.LinkType().String())
+
+	ticker := time.NewTicker(s.flushPeriod())
 	defer ticker.Stop()
 
+	// The capture's clock: the last packet timestamp, advanced by the time since it was read
+	var clock struct {
+		seen      bool
+		last, now time.Time
+	}
+
+	if s.recorder != nil {
+		defer func() {
+			if err := s.recorder.close(); err != nil {
+				s.reportError(err)
+			}
+		}()
+	}
+
 	for {
 		select {
-		case <-s.ctx.Done():
-			s.mu.Lock()
-			s.state = SnifferStopped
-			s.mu.Unlock()
+		case <-r.ctx.Done():
+			// Close the connections, and don't let their last Frames leak into the next run
 			s.assembler.FlushAll()
+			s.factory.running.Wait()
+			s.logger.Info("sniffer stopped", "packets", s.stats.packetsSeen.Load(), "frames", s.stats.framesDecoded.Load())
 			return nil
 
-		case packet := <-packets:
+		case packet := <-s.packets.next():
+			s.packets.received()
+
 			// Nil Packet means end of a PCAP file
 			if packet == nil {
-				s.mu.Lock()
-				s.state = SnifferFinished
-				s.mu.Unlock()
+				// Hand over whatever is left and close the connections, then wait for
+				// the last Frames to be queued
+				s.assembler.FlushAll()
+				s.factory.running.Wait()
+				s.logger.Info("capture finished", "packets", s.stats.packetsSeen.Load(), "frames", s.stats.framesDecoded.Load())
 				return io.EOF
 			}
 
+			s.stats.packetsSeen.Add(1)
+			clock.seen, clock.last, clock.now = true, packet.Metadata().Timestamp, time.Now()
+
 			// Kinda weird, just skip this packet
 			if packet.NetworkLayer() == nil || packet.TransportLayer() == nil || packet.TransportLayer().LayerType() != layers.LayerTypeTCP {
-				continue
+				s.stats.packetsSkipped.Add(1)
+			} else {
+				tcp := packet.TransportLayer().(*layers.TCP)
+				ci := captureContext(packet.Metadata().CaptureInfo)
+				s.assembler.AssembleWithContext(packet.NetworkLayer().NetworkFlow(), tcp, &ci)
 			}
 
-			tcp := packet.TransportLayer().(*layers.TCP)
-			s.assembler.AssembleWithTimestamp(packet.NetworkLayer().NetworkFlow(), tcp, packet.Metadata().Timestamp)
+			// Record the packet once it's assembled, so the errors it caused are attached to it
+			if s.recorder != nil {
+				if err := s.recorder.write(packet.Metadata().CaptureInfo, packet.Data()); err != nil {
+					s.logger.Warn("recording failed", "error", err)
+					s.reportError(err)
+				}
+			}
 
 		case t := <-ticker.C:
-			s.assembler.FlushWithOptions(tcpassembly.FlushOptions{CloseAll: false, T: t.Add(-3 * time.Second)})
+			if clock.seen {
+				t = clock.last.Add(t.Sub(clock.now))
+			}
+
+			opts := reassembly.FlushOptions{T: t.Add(-flushInterval)}
+			if s.idle > 0 {
+				opts.TC = t.Add(-s.idle)
+			}
+			s.assembler.FlushWithOptions(opts)
 		}
 	}
 }
 
-// Stop a running Sniffer.
-func (s *Sniffer) Stop() {
-	if s.cancel != nil {
-		s.cancel()
+// flushPeriod returns how often to flush, often enough to notice an idle connection soon
+// after its timeout.
+func (s *Sniffer) flushPeriod() time.Duration {
+	if s.idle > 0 && s.idle < flushInterval {
+		return s.idle
 	}
+
+	return flushInterval
 }
 
 // StartTrace begins runtime/trace profiling, writing to w.
@@ -231,96 +540,179 @@ func StopTrace() {
 	trace.Stop()
 }
 
-// NextFrame returns the next decoded Frame read by the Sniffer.
+// NextFrame returns the next decoded Frame read by the Sniffer. It may be called before
+// Start, and blocks until a Frame is read. Once the Sniffer stops or finishes and every
+// buffered Frame has been returned, it returns io.EOF.
 func (s *Sniffer) NextFrame() (*Frame, error) {
-	select {
-	case data := <-s.dataCh:
-		// Setup our Frame
-		frame := new(Frame)
+	var data reassembledPacket
 
-		if err := frame.Decode(data.Body); err != nil {
-			return nil, err
-		}
-
-		if int(frame.Length) != len(data.Body) {
-			return nil, ErrNotEnoughData{Expected: len(data.Body), Received: int(frame.Length)}
+	// Hand over buffered Frames before reporting the end of the run
+	select {
+	case data = <-s.dataCh:
+	default:
+		select {
+		case data = <-s.dataCh:
+		case <-s.Done():
+			return nil, io.EOF
 		}
+	}
 
-		// Add our flow data
-		frame.meta.Flow = data.Flow
+	// Setup our Frame
+	frame := new(Frame)
 
-		return frame, nil
+	if err := frame.Decode(data.Body); err != nil {
+		return nil, err
+	}
 
-	case <-s.ctx.Done():
-		return nil, s.ctx.Err()
+	if int(frame.Length) != len(data.Body) {
+		return nil, ErrNotEnoughData{Expected: len(data.Body), Received: int(frame.Length)}
 	}
+
+	// Add our flow data
+	frame.meta = data.Meta
+	frame.direction = s.direction
+
+	return frame, nil
 }
 
 // FrameHandler is called by ProcessFrames for each message in a frame.
+// The reader is positioned at the start of the message, including its GenericHeader, so
+// it can be passed straight to a GenericMessage's Decode, and holds exactly header.Length
+// bytes, all of which can be Peeked at once. Handlers may read as much or as little of it
+// as they like; ProcessFrames always advances to the next message. Messages shorter than
+// their segment's headers or longer than the rest of the Frame end the Frame with an
+// ErrDecodingFailure.
+//
+// Earlier versions handed over the reader after the GenericHeader had been read. Handlers
+// that only read what follows it should Discard the first 16 bytes.
 type FrameHandler func(frame *Frame, header *GenericHeader, r *bufio.Reader) error
 
 // ProcessFrames iterates over frames and calls fn for each message in each frame.
-// It handles decompression and reader setup. It blocks until the Sniffer is stopped,
-// or an error occurs.
+// It handles decompression and reader setup. It blocks until the Sniffer is stopped
+// or finished and its buffered Frames are processed, returning nil, or an error occurs.
+// It keeps waiting while the Sniffer is paused.
 func (s *Sniffer) ProcessFrames(fn FrameHandler) error {
-	var zpool sync.Pool
-
 	for {
 		frame, err := s.NextFrame()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
 		if err != nil {
 			return fmt.Errorf("error retrieving next frame: %w", err)
 		}
 
-		var r *bufio.Reader
-		var z io.ReadCloser
+		size := 0
+		handler := fn
+		if s.observer != nil {
+			handler = s.observed(fn, &size)
+		}
 
-		// Setup our Message reader
-		if frame.Compression == FrameCompressionZlib {
-			z = zpool.Get().(io.ReadCloser)
-			if z != nil {
-				err = z.(zlib.Resetter).Reset(bytes.NewReader(frame.Body), nil)
-				if err != nil {
-					return fmt.Errorf("error resetting ZLIB decoder: %w", err)
-				}
-			} else {
-				z, err = zlib.NewReader(bytes.NewReader(frame.Body))
-				if err != nil {
-					return fmt.Errorf("error creating ZLIB decoder: %w", err)
-				}
-			}
-			r = bufio.NewReader(z)
-		} else {
-			r = bufio.NewReader(bytes.NewReader(frame.Body))
+		err = forEachMessage(frame, s.decompressors, handler)
+
+		// Don't decode garbage, but keep going with the next Frame
+		var failed errDecompression
+		if errors.As(err, &failed) {
+			s.stats.decompressionFailures.Add(1)
+			s.logger.Warn("frame decompression failed", append(frameAttrs(frame), "compression", frame.Compression.String(), "error", failed.Err)...)
+			s.reportError(failed.Err)
+			continue
 		}
 
-		for i := 0; i < int(frame.Count); i++ {
-			header := new(GenericHeader)
+		if s.observer != nil {
+			s.observer.ObserveFrame(frame, size)
+		}
 
-			err := header.Decode(r)
-			if err != nil {
-				if z != nil {
-					z.Close()
-				}
-				return ErrDecodingFailure{Err: err}
-			}
+		if err != nil {
+			return err
+		}
+	}
+}
 
-			if err := fn(frame, header, r); err != nil {
-				if z != nil {
-					z.Close()
-				}
-				return err
-			}
+// errDecompression marks a Frame whose body couldn't be decompressed, so ProcessFrames
+// can report it and skip to the next Frame.
+type errDecompression struct {
+	Err error
+}
+
+func (e errDecompression) Error() string { return e.Err.Error() }
+
+func (e errDecompression) Unwrap() error { return e.Err }
+
+// forEachMessage decompresses a Frame body and calls fn with a bounded view of each message.
+// Decompression failures are returned as an errDecompression.
+func forEachMessage(frame *Frame, decompressors *DecompressorRegistry, fn FrameHandler) error {
+	z, err := decompress(frame, decompressors)
+	if err != nil {
+		return errDecompression{Err: err}
+	}
+	defer z.Close()
+
+	return decodeMessages(frame, z, fn)
+}
+
+// decompress returns a reader over the decompressed body of a Frame.
+func decompress(frame *Frame, decompressors *DecompressorRegistry) (io.ReadCloser, error) {
+	d, ok := decompressors.Lookup(frame.Compression)
+	if !ok {
+		return nil, ErrUnsupportedCompression{Compression: frame.Compression}
+	}
+
+	z, err := d.Decompress(frame)
+	if err != nil {
+		return nil, ErrDecodingFailure{Err: err}
+	}
+
+	return z, nil
+}
+
+// minMessageLength returns the shortest valid message for a segment type, its GenericHeader
+// plus any segment header the message types decode.
+func minMessageLength(segment uint16) uint32 {
+	switch segment {
+	case GameEvent:
+		return messageHeaderLength + 16
+	case ServerPing, ServerPong:
+		return messageHeaderLength + 8
+	default:
+		return messageHeaderLength
+	}
+}
+
+// decodeMessages calls fn with a bounded view of each message in a decompressed Frame body.
+func decodeMessages(frame *Frame, z io.Reader, fn FrameHandler) error {
+	// Setup our Message reader
+	r := bufio.NewReader(z)
+
+	for i := 0; i < int(frame.Count); i++ {
+		data, err := r.Peek(messageHeaderLength)
+		if err != nil {
+			return ErrDecodingFailure{Err: ErrNotEnoughData{Expected: messageHeaderLength, Received: len(data), Err: err}}
 		}
 
-		// Return the zlib reader to the pool for reuse
-		if z != nil {
-			zpool.Put(z)
+		header := new(GenericHeader)
+		header.unmarshal(data)
+
+		// Without a sane length there's no way to find the next message
+		if header.Length < minMessageLength(header.Segment) {
+			return ErrDecodingFailure{Err: fmt.Errorf("invalid message length %d for segment %d", header.Length, header.Segment)}
 		}
 
-		// We're done with the current frame, if Sniffer is stopped then exit,
-		// allowing user to start a new subscriber routine.
-		if !s.IsActive() {
-			return nil
+		// Only read what the body holds, rather than allocating whatever the header claims
+		msg, err := io.ReadAll(io.LimitReader(r, int64(header.Length)))
+		if err != nil {
+			return ErrDecodingFailure{Err: err}
+		}
+		if len(msg) < int(header.Length) {
+			return ErrDecodingFailure{Err: ErrNotEnoughData{Expected: int(header.Length), Received: len(msg)}}
+		}
+
+		// Hand over exactly one message, buffered whole so it can be Peeked; anything the
+		// handler doesn't read is skipped
+		err = fn(frame, header, bufio.NewReaderSize(bytes.NewReader(msg), len(msg)))
+		if err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
@@ -0,0 +1,498 @@
+package zanarkand
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcapgo"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+// rawMessage builds a message with a GenericHeader for the given segment and payload.
+func rawMessage(segment uint16, payload []byte) []byte {
+	data := make([]byte, messageHeaderLength+len(payload))
+	binary.LittleEndian.PutUint32(data[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(data[4:8], 0x10000001)
+	binary.LittleEndian.PutUint32(data[8:12], 0x10000002)
+	binary.LittleEndian.PutUint16(data[12:14], segment)
+	copy(data[messageHeaderLength:], payload)
+	return data
+}
+
+// rawGameEvent builds a GameEvent message with the given opcode and body.
+func rawGameEvent(opcode uint16, body []byte) []byte {
+	payload := make([]byte, 16+len(body))
+	binary.LittleEndian.PutUint16(payload[0:2], 0x14)
+	binary.LittleEndian.PutUint16(payload[2:4], opcode)
+	binary.LittleEndian.PutUint16(payload[6:8], 3)
+	binary.LittleEndian.PutUint32(payload[8:12], 1580625008)
+	copy(payload[16:], body)
+	return rawMessage(GameEvent, payload)
+}
+
+func mixedSegmentFrame(t *testing.T, compression Compressor) *Frame {
+	t.Helper()
+
+	var body []byte
+	body = append(body, rawMessage(ServerPing, []byte{0x15, 0xCD, 0x5B, 0x07, 0x42, 0xE0, 0x89, 0x58})...)
+	body = append(body, rawMessage(SessionInit, bytes.Repeat([]byte{0xAA}, 40))...)
+	body = append(body, rawGameEvent(0x0125, []byte{1, 2, 3, 4, 5, 6, 7, 8})...)
+	body = append(body, rawMessage(EncryptInit, bytes.Repeat([]byte{0xBB}, 8))...)
+	body = append(body, rawGameEvent(0x0232, bytes.Repeat([]byte{0xCC}, 4096))...)
+
+	if compression == FrameCompressionZlib {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		_, _ = w.Write(body)
+		_ = w.Close()
+		body = buf.Bytes()
+	}
+
+	return &Frame{Count: 5, Compression: compression, Body: body}
+}
+
+func TestForEachMessageMixedSegments(t *testing.T) {
+	for _, compression := range []Compressor{FrameCompressionNone, FrameCompressionZlib} {
+		frame := mixedSegmentFrame(t, compression)
+
+		var segments []uint16
+		var events []*GameEventMessage
+		var pings []*KeepaliveMessage
+
+		err := forEachMessage(frame, DefaultDecompressors, func(frame *Frame, header *GenericHeader, r *bufio.Reader) error {
+			segments = append(segments, header.Segment)
+
+			switch header.Segment {
+			case GameEvent:
+				msg := new(GameEventMessage)
+				if err := msg.Decode(r); err != nil {
+					return err
+				}
+				events = append(events, msg)
+
+			case ServerPing:
+				msg := new(KeepaliveMessage)
+				if err := msg.Decode(r); err != nil {
+					return err
+				}
+				pings = append(pings, msg)
+
+			case EncryptInit:
+				// Only consume part of the message
+				_, _ = r.Discard(4)
+			}
+
+			// Everything else is ignored without being read
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", compression, err)
+		}
+
+		expected := []uint16{ServerPing, SessionInit, GameEvent, EncryptInit, GameEvent}
+		if len(segments) != len(expected) {
+			t.Fatalf("%v: expected %d messages, got %d", compression, len(expected), len(segments))
+		}
+
+		for i := range expected {
+			if segments[i] != expected[i] {
+				t.Errorf("%v: expected segment %d at message %d, got %d", compression, expected[i], i, segments[i])
+			}
+		}
+
+		if len(pings) != 1 || pings[0].ID != 123456789 {
+			t.Errorf("%v: expected one ping with ID 123456789, got %v", compression, pings)
+		}
+
+		if len(events) != 2 {
+			t.Fatalf("%v: expected 2 GameEvent messages, got %d", compression, len(events))
+		}
+
+		if events[0].Opcode != 0x0125 || !bytes.Equal(events[0].Body, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+			t.Errorf("%v: unexpected first GameEvent: %s body %v", compression, events[0], events[0].Body)
+		}
+
+		if events[1].Opcode != 0x0232 || len(events[1].Body) != 4096 || events[1].Body[4095] != 0xCC {
+			t.Errorf("%v: unexpected second GameEvent: %s", compression, events[1])
+		}
+	}
+}
+
+func TestForEachMessageInvalidLength(t *testing.T) {
+	withLength := func(msg []byte, length uint32) []byte {
+		binary.LittleEndian.PutUint32(msg[0:4], length)
+		return msg
+	}
+
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"truncated header", withLength(rawMessage(ServerPing, make([]byte, 8)), 4)},
+		{"undersized GameEvent", withLength(rawGameEvent(0x0125, nil), 20)},
+		{"undersized keepalive", withLength(rawMessage(ServerPing, make([]byte, 8)), 16)},
+		{"oversized message", withLength(rawMessage(SessionInit, make([]byte, 8)), 0xFFFFFFFF)},
+		{"past the end of the body", withLength(rawGameEvent(0x0125, make([]byte, 8)), 48)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var decoded int
+			frame := &Frame{Count: 1, Body: tt.body}
+
+			err := forEachMessage(frame, DefaultDecompressors, func(_ *Frame, header *GenericHeader, r *bufio.Reader) error {
+				decoded++
+				if header.Segment == GameEvent {
+					return new(GameEventMessage).Decode(r)
+				}
+				return new(KeepaliveMessage).Decode(r)
+			})
+
+			if _, ok := err.(ErrDecodingFailure); !ok {
+				t.Errorf("Expected ErrDecodingFailure, got %v", err)
+			}
+
+			if decoded != 0 {
+				t.Errorf("Expected the handler not to be called, got %d calls", decoded)
+			}
+		})
+	}
+}
+
+func TestForEachMessageDecompressorRegistry(t *testing.T) {
+	frame := mixedSegmentFrame(t, FrameCompressionNone)
+	frame.Compression = FrameCompressionOodle
+
+	count := func(*Frame, *GenericHeader, *bufio.Reader) error { return nil }
+
+	registry := NewDecompressorRegistry()
+
+	var unsupported ErrUnsupportedCompression
+	err := forEachMessage(frame, registry, count)
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("Expected ErrUnsupportedCompression for an unregistered Oodle frame, got %v", err)
+	}
+
+	// A fake Oodle decompressor that passes the body straight through
+	registry.Register(FrameCompressionOodle, DecompressorFunc(func(f *Frame) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(f.Body)), nil
+	}))
+
+	var messages int
+	err = forEachMessage(frame, registry, func(*Frame, *GenericHeader, *bufio.Reader) error {
+		messages++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error with a registered decompressor: %v", err)
+	}
+
+	if messages != 5 {
+		t.Errorf("Expected 5 messages from the fake decompressor, got %d", messages)
+	}
+}
+
+var (
+	testServerIP = net.IP{203, 0, 113, 7}
+	testClientIP = net.IP{192, 168, 1, 2}
+)
+
+// tcpStream builds Ethernet packets for a game server to client TCP connection: a SYN-ACK
+// followed by one packet per payload.
+func tcpStream(t *testing.T, serverPort uint16, payloads ...[]byte) [][]byte {
+	t.Helper()
+	return tcpReply(t, testServerIP, testClientIP, serverPort, 50000, payloads...)
+}
+
+// tcpPackets builds Ethernet packets for the client's direction of a TCP connection: a SYN
+// followed by one packet per payload.
+func tcpPackets(t *testing.T, srcIP, dstIP net.IP, srcPort, dstPort uint16, payloads ...[]byte) [][]byte {
+	t.Helper()
+	return tcpSegments(t, false, srcIP, dstIP, srcPort, dstPort, payloads...)
+}
+
+// tcpReply builds Ethernet packets for the server's direction of a TCP connection: a SYN-ACK
+// followed by one packet per payload.
+func tcpReply(t *testing.T, srcIP, dstIP net.IP, srcPort, dstPort uint16, payloads ...[]byte) [][]byte {
+	t.Helper()
+	return tcpSegments(t, true, srcIP, dstIP, srcPort, dstPort, payloads...)
+}
+
+func tcpSegments(t *testing.T, synAck bool, srcIP, dstIP net.IP, srcPort, dstPort uint16, payloads ...[]byte) [][]byte {
+	t.Helper()
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    srcIP,
+		DstIP:    dstIP,
+	}
+
+	build := func(tcp *layers.TCP, payload []byte) []byte {
+		_ = tcp.SetNetworkLayerForChecksum(ip)
+
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(payload)); err != nil {
+			t.Fatal(err)
+		}
+
+		return append([]byte(nil), buf.Bytes()...)
+	}
+
+	seq := uint32(1000)
+	packets := [][]byte{build(&layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(dstPort), Seq: seq, SYN: true, ACK: synAck, Window: 65535}, nil)}
+	seq++
+
+	for _, payload := range payloads {
+		packets = append(packets, build(&layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(dstPort), Seq: seq, ACK: true, PSH: true, Window: 65535}, payload))
+		seq += uint32(len(payload))
+	}
+
+	return packets
+}
+
+// pcapFile writes Ethernet packets to a pcap capture.
+func pcapFile(t *testing.T, packets [][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := pcapgo.NewWriter(&buf)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Unix(1580625008, 0)
+	for i, p := range packets {
+		ci := gopacket.CaptureInfo{Timestamp: ts.Add(time.Duration(i) * time.Millisecond), CaptureLength: len(p), Length: len(p)}
+		if err := w.WritePacket(ci, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func TestNewSnifferFromReader(t *testing.T) {
+	frame, err := NewFrame(1, FrameCompressionZlib,
+		&GameEventMessage{GenericHeader: GenericHeader{Segment: GameEvent}, Opcode: 0x0232, Body: bytes.Repeat([]byte{0xCC}, 64)},
+		&KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: 123456789},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wire, err := frame.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capture := pcapFile(t, append(
+		tcpStream(t, 443, wire), // outside the port ranges
+		tcpStream(t, 55021, wire[:20], wire[20:60], wire[60:])...,
+	))
+
+	// Hold the capture open until the frame is read, so Start doesn't finish first
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = pw.Write(capture)
+	}()
+
+	sniffer, err := NewSnifferFromReader(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- sniffer.Start(context.Background()) }()
+
+	for !sniffer.IsActive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	got, err := sniffer.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Count != 2 || got.Compression != FrameCompressionZlib || !bytes.Equal(got.Body, frame.Body) {
+		t.Errorf("Unexpected frame: %s", got)
+	}
+
+	_ = pw.Close()
+	if err := <-done; err != io.EOF {
+		t.Errorf("Expected io.EOF at the end of the capture, got %v", err)
+	}
+
+	select {
+	case extra := <-sniffer.dataCh:
+		t.Errorf("Expected the port 443 stream to be filtered, got %d bytes", len(extra.Body))
+	default:
+	}
+}
+
+// wireFrame builds the encoded bytes of a Frame holding msgs.
+func wireFrame(t *testing.T, compression Compressor, msgs ...encoding.BinaryMarshaler) []byte {
+	t.Helper()
+
+	frame, err := NewFrame(1, compression, msgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wire, err := frame.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return wire
+}
+
+func TestNewSnifferFromHandle(t *testing.T) {
+	event := func(opcode uint16) *GameEventMessage {
+		return &GameEventMessage{GenericHeader: GenericHeader{Segment: GameEvent}, Opcode: opcode, Body: []byte{1, 2, 3, 4}}
+	}
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, 16)
+
+	sniffer, err := NewSnifferFromHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type delivery struct {
+		opcode    uint16
+		direction FlowDirection
+	}
+	received := make(chan delivery, 4)
+
+	handler := NewGameEventHandler(func(msg *GameEventMessage, direction FlowDirection) {
+		received <- delivery{msg.Opcode, direction}
+	}, WithOpcodes(0x0232, 0x0125))
+
+	done := make(chan error, 1)
+	go func() { done <- sniffer.Start(context.Background()) }()
+
+	for !sniffer.IsActive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	go func() { _ = handler.Subscribe(context.Background(), sniffer) }()
+
+	packets := append(
+		tcpReply(t, testServerIP, testClientIP, 55021, 50000, wireFrame(t, FrameCompressionZlib, event(0x0232), event(0x0999))),
+		tcpPackets(t, testClientIP, testServerIP, 50000, 55021, wireFrame(t, FrameCompressionNone, event(0x0125)))...,
+	)
+	for _, p := range packets {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := make(map[delivery]bool)
+	for len(got) < 2 {
+		select {
+		case d := <-received:
+			got[d] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for messages, got %v", got)
+		}
+	}
+
+	if !got[delivery{0x0232, FrameIngress}] || !got[delivery{0x0125, FrameEgress}] {
+		t.Errorf("Unexpected deliveries: %v", got)
+	}
+
+	handle.Finish()
+	if err := <-done; err != io.EOF {
+		t.Errorf("Expected io.EOF after Finish, got %v", err)
+	}
+}
+
+func TestProcessFramesDecompressionFailure(t *testing.T) {
+	ping := &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: 1}
+
+	broken := wireFrame(t, FrameCompressionNone, ping)
+	broken[33] = FrameCompressionOodle
+
+	registry := NewDecompressorRegistry()
+	registry.Register(FrameCompressionOodle, DecompressorFunc(func(*Frame) (io.ReadCloser, error) {
+		return nil, errors.New("corrupt Oodle stream")
+	}))
+
+	handle := devices.NewMemoryHandleFromPackets(layers.LinkTypeEthernet, tcpStream(t, 55021, broken, wireFrame(t, FrameCompressionNone, ping))...)
+
+	sniffer, err := NewSnifferFromHandle(handle, WithDecompressors(registry), WithErrorBufferSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+
+	var messages int
+	err = sniffer.ProcessFrames(func(*Frame, *GenericHeader, *bufio.Reader) error {
+		messages++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected ProcessFrames to keep going after a decompression failure, got %v", err)
+	}
+
+	if messages != 1 {
+		t.Errorf("Expected the message from the second Frame, got %d messages", messages)
+	}
+
+	var failure ErrDecodingFailure
+	select {
+	case err := <-sniffer.Errors():
+		if !errors.As(err, &failure) {
+			t.Errorf("Expected an ErrDecodingFailure, got %v", err)
+		}
+	default:
+		t.Error("Expected the decompression failure to be reported")
+	}
+
+	if stats := sniffer.Stats(); stats.DecompressionFailures != 1 {
+		t.Errorf("Expected 1 decompression failure, got %d", stats.DecompressionFailures)
+	}
+}
+
+func TestForEachMessageLarge(t *testing.T) {
+	// Well past bufio's default size, but Decode still Peeks the whole message
+	event := &GameEventMessage{GenericHeader: GenericHeader{Segment: GameEvent}, Opcode: 0x0232, Body: bytes.Repeat([]byte{7}, 96<<10)}
+
+	frame, err := NewFrame(1, FrameCompressionNone, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg GameEventMessage
+	err = forEachMessage(frame, DefaultDecompressors, func(_ *Frame, _ *GenericHeader, r *bufio.Reader) error {
+		return msg.Decode(r)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if msg.Opcode != event.Opcode || !bytes.Equal(msg.Body, event.Body) {
+		t.Errorf("Expected the whole message, got opcode %#x and %d bytes", msg.Opcode, len(msg.Body))
+	}
+}
@@ -0,0 +1,80 @@
+package zanarkand
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+// Stats is a snapshot of the counters kept by a Sniffer since it was created.
+type Stats struct {
+	PacketsSeen    uint64 // packets read from the capture handle
+	PacketsSkipped uint64 // packets without a TCP layer
+	BytesAssembled uint64 // TCP payload bytes reassembled in order
+
+	FramesDecoded         uint64 // Frames read from reassembled streams
+	BytesResynced         uint64 // bytes skipped looking for the start of a Frame
+	DecompressionFailures uint64 // Frames ProcessFrames couldn't decompress
+
+	ErrorsDropped           uint64 // errors dropped because the Errors channel was full
+	ConnectionEventsDropped uint64 // events dropped because the Connections channel was full
+
+	DataQueued   int           // Frames waiting to be read by NextFrame
+	DataCapacity int           // size of the Frame buffer, see WithDataBufferSize
+	DataBlocked  time.Duration // time spent waiting for room in a full Frame buffer
+	DataDropped  uint64        // Frames dropped under the BackpressurePolicy, or when stopping with a full buffer
+	DataSpilled  uint64        // Frames written to the spill queue
+
+	PauseBuffered  int    // Frames held by PauseBuffer until Resume
+	PauseDiscarded uint64 // Frames discarded while paused
+
+	OpenStreams int64 // TCP connections being reassembled
+
+	// Capture holds the kernel or libpcap counters of a live capture, when CaptureErr is nil.
+	Capture    devices.CaptureStats
+	CaptureErr error
+}
+
+// snifferStats holds the counters behind Stats, shared with the reassembly goroutines.
+type snifferStats struct {
+	packetsSeen    atomic.Uint64
+	packetsSkipped atomic.Uint64
+	bytesAssembled atomic.Uint64
+
+	framesDecoded         atomic.Uint64
+	bytesResynced         atomic.Uint64
+	decompressionFailures atomic.Uint64
+
+	errorsDropped atomic.Uint64
+	eventsDropped atomic.Uint64
+
+	openStreams atomic.Int64
+}
+
+// Stats returns the Sniffer's counters. It's safe to call at any time, from any goroutine,
+// and cheap enough to poll. Kernel counters are read from the capture handle on each call.
+func (s *Sniffer) Stats() Stats {
+	stats := Stats{
+		PacketsSeen:             s.stats.packetsSeen.Load(),
+		PacketsSkipped:          s.stats.packetsSkipped.Load(),
+		BytesAssembled:          s.stats.bytesAssembled.Load(),
+		FramesDecoded:           s.stats.framesDecoded.Load(),
+		BytesResynced:           s.stats.bytesResynced.Load(),
+		DecompressionFailures:   s.stats.decompressionFailures.Load(),
+		ErrorsDropped:           s.stats.errorsDropped.Load(),
+		ConnectionEventsDropped: s.stats.eventsDropped.Load(),
+		DataQueued:              len(s.dataCh),
+		DataCapacity:            cap(s.dataCh),
+		DataBlocked:             time.Duration(s.data.blocked.Load()),
+		DataDropped:             s.data.dropped.Load(),
+		DataSpilled:             s.data.spilled.Load(),
+		PauseBuffered:           s.gate.buffered(),
+		PauseDiscarded:          s.gate.discarded.Load(),
+		OpenStreams:             s.stats.openStreams.Load(),
+	}
+
+	stats.Capture, stats.CaptureErr = devices.HandleStats(s.handle)
+
+	return stats
+}
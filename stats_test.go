@@ -0,0 +1,117 @@
+package zanarkand
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand/devices"
+)
+
+func udpPacket(t *testing.T, payload []byte) []byte {
+	t.Helper()
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: testServerIP, DstIP: testClientIP}
+	udp := &layers.UDP{SrcPort: 55021, DstPort: 50000}
+	_ = udp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestSnifferStats(t *testing.T) {
+	ping := func(id uint32) []byte {
+		return wireFrame(t, FrameCompressionNone, &KeepaliveMessage{GenericHeader: GenericHeader{Segment: ServerPing}, ID: id})
+	}
+
+	// Junk before the first Frame is skipped, and its resync error dropped
+	stream := append(append(bytes.Repeat([]byte{0xAA}, 5), ping(1)...), ping(2)...)
+	packets := append([][]byte{udpPacket(t, []byte("not a frame"))}, tcpStream(t, 55021, stream[:30], stream[30:])...)
+
+	handle := devices.NewMemoryHandle(layers.LinkTypeEthernet, len(packets))
+	defer handle.Close()
+
+	sniffer, err := NewSnifferFromHandle(handle, WithErrorBufferSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = sniffer.Start(context.Background()) }()
+
+	for !sniffer.IsActive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, p := range packets {
+		if err := handle.WritePacketData(p, gopacket.CaptureInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frames := make(chan *Frame, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			frame, err := sniffer.NextFrame()
+			if err != nil {
+				return
+			}
+			frames <- frame
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-frames:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a frame")
+		}
+	}
+
+	stats := sniffer.Stats()
+	want := Stats{
+		PacketsSeen:    uint64(len(packets)),
+		PacketsSkipped: 1,
+		BytesAssembled: uint64(len(stream)),
+		FramesDecoded:  2,
+		BytesResynced:  5,
+		ErrorsDropped:  1,
+		DataCapacity:   defaultDataBufSize,
+		OpenStreams:    1,
+	}
+
+	if !errors.Is(stats.CaptureErr, devices.ErrNoStats) {
+		t.Errorf("Expected no capture stats for a MemoryHandle, got %v", stats.CaptureErr)
+	}
+
+	stats.CaptureErr = nil
+	if stats != want {
+		t.Errorf("Unexpected stats %+v, expected %+v", stats, want)
+	}
+
+	// Stopping flushes the connection
+	sniffer.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sniffer.Stats().OpenStreams != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the stream to close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -2,6 +2,10 @@ package zanarkand
 
 import (
 	"context"
+	"errors"
+	"sync"
+
+	"github.com/ayyaruq/zanarkand/opcodes"
 )
 
 // Subscriber describes the interface for individual Frame segment subscribers.
@@ -10,11 +14,70 @@ type Subscriber interface {
 	Close(s *Sniffer)
 }
 
+// ErrSubscriberClosed is returned by Subscribe once a subscriber's channels are closed.
+var ErrSubscriberClosed = errors.New("subscriber closed")
+
+// ErrNoOpcodeTable is returned by Subscribe when WithOpcodeNames is used without WithOpcodeTable.
+var ErrNoOpcodeTable = errors.New("opcode names given without an opcode table")
+
+// subscriptions tracks the Subscribe calls of a channel based subscriber, so Close can
+// wait for them to stop sending before closing its channels.
+type subscriptions struct {
+	mu     sync.Mutex
+	closed bool
+	active sync.WaitGroup
+	once   sync.Once
+}
+
+// add registers a Subscribe call, returning false once the subscriber is closed.
+func (c *subscriptions) add() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	c.active.Add(1)
+	return true
+}
+
+func (c *subscriptions) done() {
+	c.active.Done()
+}
+
+// close refuses new Subscribe calls, then runs fn once the running ones have returned.
+// Only the first call does anything.
+func (c *subscriptions) close(fn func()) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.closed = true
+		c.mu.Unlock()
+
+		c.active.Wait()
+		fn()
+	})
+}
+
 // GameEventOption configures a GameEventSubscriber or GameEventHandler.
 type GameEventOption func(*gameEventConfig)
 
 type gameEventConfig struct {
-	opcodes map[uint16]struct{}
+	opcodes  map[uint16]struct{}
+	table    *opcodes.Table
+	names    []string
+	payloads *PayloadRegistry
+
+	// Channel settings for GameEventSubscriber
+	backpressure BackpressurePolicy
+	bufSize      int
+
+	// Opcodes resolved from names, by the direction they're sent in and the connection type
+	ingress map[opcodes.Connection]map[uint16]struct{}
+	egress  map[opcodes.Connection]map[uint16]struct{}
+
+	// err is returned by Subscribe for an unusable configuration
+	err error
 }
 
 // WithOpcodes filters GameEventMessages to only those matching the given opcodes.
@@ -27,3 +90,87 @@ func WithOpcodes(opcodes ...uint16) GameEventOption {
 		}
 	}
 }
+
+// WithOpcodeTable sets the opcode Table used to resolve names given to WithOpcodeNames.
+func WithOpcodeTable(table *opcodes.Table) GameEventOption {
+	return func(c *gameEventConfig) { c.table = table }
+}
+
+// WithOpcodeNames filters GameEventMessages to only those matching the given opcode names,
+// resolved against the Table from WithOpcodeTable. Server names match inbound messages and
+// Client names match outbound messages, using the Zone or Chat list for the Frame's
+// connection type. Names that aren't in the Table never match. This may be combined with
+// WithOpcodes. Without WithOpcodeTable, Subscribe returns ErrNoOpcodeTable.
+func WithOpcodeNames(names ...string) GameEventOption {
+	return func(c *gameEventConfig) { c.names = append(c.names, names...) }
+}
+
+// WithEventBackpressure gives a GameEventSubscriber's channels a buffer of bufferSize
+// messages, and sets what happens to messages when a buffer is full. Dropped messages are
+// counted by Dropped and reported on the Sniffer's Errors as an ErrDropped. Spill queues
+// use the Sniffer's WithSpillQueue settings. By default the channels are unbuffered and
+// block. GameEventHandler ignores this option.
+func WithEventBackpressure(policy BackpressurePolicy, bufferSize int) GameEventOption {
+	return func(c *gameEventConfig) { c.backpressure, c.bufSize = policy, bufferSize }
+}
+
+// newGameEventConfig applies opts and resolves any opcode names.
+func newGameEventConfig(opts ...GameEventOption) gameEventConfig {
+	cfg := gameEventConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if len(cfg.names) == 0 {
+		return cfg
+	}
+
+	if cfg.table == nil {
+		cfg.err = ErrNoOpcodeTable
+		return cfg
+	}
+
+	cfg.ingress = make(map[opcodes.Connection]map[uint16]struct{})
+	cfg.egress = make(map[opcodes.Connection]map[uint16]struct{})
+
+	for _, conn := range []opcodes.Connection{opcodes.Zone, opcodes.Chat} {
+		cfg.ingress[conn] = make(map[uint16]struct{})
+		cfg.egress[conn] = make(map[uint16]struct{})
+
+		for _, name := range cfg.names {
+			if op, ok := cfg.table.Opcode(opcodes.Server, conn, name); ok {
+				cfg.ingress[conn][op] = struct{}{}
+			}
+
+			if op, ok := cfg.table.Opcode(opcodes.Client, conn, name); ok {
+				cfg.egress[conn][op] = struct{}{}
+			}
+		}
+	}
+
+	return cfg
+}
+
+// allows reports whether a GameEventMessage on a Frame's connection passes the opcode filters.
+func (c *gameEventConfig) allows(opcode uint16, direction FlowDirection, connection uint16) bool {
+	if len(c.opcodes) == 0 && len(c.names) == 0 {
+		return true
+	}
+
+	if _, ok := c.opcodes[opcode]; ok {
+		return true
+	}
+
+	conn := opcodes.Connection(connection)
+
+	switch direction {
+	case FrameIngress:
+		_, ok := c.ingress[conn][opcode]
+		return ok
+	case FrameEgress:
+		_, ok := c.egress[conn][opcode]
+		return ok
+	default:
+		return false
+	}
+}
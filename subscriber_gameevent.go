@@ -2,6 +2,7 @@ package zanarkand
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 )
@@ -10,30 +11,67 @@ import (
 type GameEventSubscriber struct {
 	IngressEvents chan *GameEventMessage
 	EgressEvents  chan *GameEventMessage
-	opcodes       map[uint16]struct{}
+	cfg           gameEventConfig
+
+	ingress, egress *queue[*GameEventMessage]
+	subs            subscriptions
 }
 
 // NewGameEventSubscriber returns a Subscriber handle with channels for inbound and outbound GameEventMessages.
 func NewGameEventSubscriber(opts ...GameEventOption) *GameEventSubscriber {
-	cfg := gameEventConfig{}
-	for _, opt := range opts {
-		opt(&cfg)
+	cfg := newGameEventConfig(opts...)
+	codec := gameEventCodec(cfg.payloads)
+
+	g := &GameEventSubscriber{
+		IngressEvents: make(chan *GameEventMessage, cfg.bufSize),
+		EgressEvents:  make(chan *GameEventMessage, cfg.bufSize),
+		cfg:           cfg,
 	}
+	g.ingress = newQueue("ingress events", g.IngressEvents, cfg.backpressure, codec)
+	g.egress = newQueue("egress events", g.EgressEvents, cfg.backpressure, codec)
 
-	return &GameEventSubscriber{
-		IngressEvents: make(chan *GameEventMessage),
-		EgressEvents:  make(chan *GameEventMessage),
-		opcodes:       cfg.opcodes,
+	return g
+}
+
+// gameEventCodec spills GameEventMessages in their wire format.
+func gameEventCodec(payloads *PayloadRegistry) spillCodec[*GameEventMessage] {
+	return spillCodec[*GameEventMessage]{
+		encode: func(msg *GameEventMessage) ([]byte, error) {
+			return msg.MarshalBinary()
+		},
+
+		decode: func(data []byte) (*GameEventMessage, error) {
+			msg := &GameEventMessage{payloads: payloads}
+			err := msg.Decode(bufio.NewReaderSize(bytes.NewReader(data), len(data)))
+			return msg, err
+		},
 	}
 }
 
+// Dropped returns the number of messages dropped from the channels under the
+// BackpressurePolicy set by WithEventBackpressure.
+func (g *GameEventSubscriber) Dropped() uint64 {
+	return g.ingress.dropped.Load() + g.egress.dropped.Load()
+}
+
 // Subscribe starts the GameEventSubscriber. It blocks until the context is cancelled,
-// the Sniffer is stopped, or an error occurs. If the Sniffer is not already running,
-// it will be started in a goroutine.
+// the Sniffer is stopped, or an error occurs. If the Sniffer is stopped, it will be
+// started in a goroutine. Once the GameEventSubscriber is closed, it returns
+// ErrSubscriberClosed.
 func (g *GameEventSubscriber) Subscribe(ctx context.Context, s *Sniffer) error {
-	if !s.IsActive() {
-		go s.Start(ctx)
+	if g.cfg.err != nil {
+		return g.cfg.err
+	}
+
+	if !g.subs.add() {
+		return ErrSubscriberClosed
 	}
+	defer g.subs.done()
+
+	s.startIfStopped(ctx)
+
+	g.ingress.attach(s)
+	g.egress.attach(s)
 
 	return s.ProcessFrames(func(frame *Frame, header *GenericHeader, r *bufio.Reader) error {
 		if header.Segment != GameEvent {
@@ -42,32 +80,40 @@ func (g *GameEventSubscriber) Subscribe(ctx context.Context, s *Sniffer) error {
 
 		msg := new(GameEventMessage)
 		if err := msg.Decode(r); err != nil {
+			s.logDecodeFailure(frame, header, err)
 			return ErrDecodingFailure{Err: err}
 		}
+		msg.payloads = g.cfg.payloads
 
-		if len(g.opcodes) > 0 {
-			if _, ok := g.opcodes[msg.Opcode]; !ok {
-				return nil
-			}
+		direction := frame.Direction()
+		if !g.cfg.allows(msg.Opcode, direction, frame.Connection) {
+			return nil
 		}
 
-		switch frame.Direction() {
+		switch direction {
 		case FrameIngress:
-			g.IngressEvents <- msg
+			g.ingress.send(msg)
 		case FrameEgress:
-			g.EgressEvents <- msg
+			g.egress.send(msg)
 		default:
+			s.logger.Warn("unexpected frame direction", append(frameAttrs(frame), opcodeAttr(msg.Opcode))...)
 			return ErrDecodingFailure{Err: fmt.Errorf("unexpected frame direction")}
 		}
 		return nil
 	})
 }
 
-// Close will stop a sniffer, drain the channels, then close the channels.
+// Close will stop a sniffer, discard any spilled messages, then close the channels once
+// Subscribe has returned. Calling Close more than once is a no-op.
 func (g *GameEventSubscriber) Close(s *Sniffer) {
 	s.Stop()
-	close(g.IngressEvents)
-	close(g.EgressEvents)
+	g.ingress.shutdown()
+	g.egress.shutdown()
+
+	g.subs.close(func() {
+		close(g.IngressEvents)
+		close(g.EgressEvents)
+	})
 }
 
 // GameEventCallback is a function called for each decoded GameEventMessage.
@@ -88,32 +134,29 @@ type GameEventCallback func(msg *GameEventMessage, direction FlowDirection)
 // reused across calls; do not retain the pointer after the callback returns.
 type GameEventHandler struct {
 	callback GameEventCallback
-	opcodes  map[uint16]struct{}
+	cfg      gameEventConfig
 	msg      GameEventMessage
 }
 
 // NewGameEventHandler returns a subscriber that calls fn for each
-// decoded GameEventMessage. Use WithOpcodes to filter by opcode.
+// decoded GameEventMessage. Use WithOpcodes or WithOpcodeNames to filter by opcode.
 func NewGameEventHandler(fn GameEventCallback, opts ...GameEventOption) *GameEventHandler {
-	cfg := gameEventConfig{}
-	for _, opt := range opts {
-		opt(&cfg)
-	}
-
 	return &GameEventHandler{
 		callback: fn,
-		opcodes:  cfg.opcodes,
+		cfg:      newGameEventConfig(opts...),
 	}
 }
 
 // Subscribe starts the GameEventHandler. It blocks until the context is cancelled,
-// the Sniffer is stopped, or an error occurs. If the Sniffer is not already running,
-// it will be started in a goroutine.
+// the Sniffer is stopped, or an error occurs. If the Sniffer is stopped, it will be
+// started in a goroutine.
 func (g *GameEventHandler) Subscribe(ctx context.Context, s *Sniffer) error {
-	if !s.IsActive() {
-		go s.Start(ctx)
+	if g.cfg.err != nil {
+		return g.cfg.err
 	}
 
+	s.startIfStopped(ctx)
+
 	return s.ProcessFrames(func(frame *Frame, header *GenericHeader, r *bufio.Reader) error {
 		if header.Segment != GameEvent {
 			return nil
@@ -121,17 +164,18 @@ func (g *GameEventHandler) Subscribe(ctx context.Context, s *Sniffer) error {
 
 		g.msg.Reset()
 		if err := g.msg.Decode(r); err != nil {
+			s.logDecodeFailure(frame, header, err)
 			return ErrDecodingFailure{Err: err}
 		}
+		g.msg.payloads = g.cfg.payloads
 
-		if len(g.opcodes) > 0 {
-			if _, ok := g.opcodes[g.msg.Opcode]; !ok {
-				return nil
-			}
+		direction := frame.Direction()
+		if !g.cfg.allows(g.msg.Opcode, direction, frame.Connection) {
+			return nil
 		}
 
-		direction := frame.Direction()
 		if direction == 0 {
+			s.logger.Warn("unexpected frame direction", append(frameAttrs(frame), opcodeAttr(g.msg.Opcode))...)
 			return ErrDecodingFailure{Err: fmt.Errorf("unexpected frame direction")}
 		}
 
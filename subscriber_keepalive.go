@@ -2,28 +2,77 @@ package zanarkand
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 )
 
 // KeepaliveSubscriber is a Subscriber for Keepalive segments.
 type KeepaliveSubscriber struct {
 	Events chan *KeepaliveMessage
+
+	events *queue[*KeepaliveMessage]
+	subs   subscriptions
+}
+
+// KeepaliveOption configures a KeepaliveSubscriber.
+type KeepaliveOption func(*keepaliveConfig)
+
+type keepaliveConfig struct {
+	backpressure BackpressurePolicy
+	bufSize      int
+}
+
+// WithKeepaliveBackpressure gives a KeepaliveSubscriber's channel a buffer of bufferSize
+// messages, and sets what happens to messages when it's full, like WithEventBackpressure.
+func WithKeepaliveBackpressure(policy BackpressurePolicy, bufferSize int) KeepaliveOption {
+	return func(c *keepaliveConfig) { c.backpressure, c.bufSize = policy, bufferSize }
 }
 
 // NewKeepaliveSubscriber returns a Subscriber handle. As the traffic is minimal, this subscriber uses a single Event channel.
-func NewKeepaliveSubscriber() *KeepaliveSubscriber {
-	return &KeepaliveSubscriber{
-		Events: make(chan *KeepaliveMessage),
+func NewKeepaliveSubscriber(opts ...KeepaliveOption) *KeepaliveSubscriber {
+	cfg := keepaliveConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
 	}
+
+	k := &KeepaliveSubscriber{Events: make(chan *KeepaliveMessage, cfg.bufSize)}
+	k.events = newQueue("keepalive events", k.Events, cfg.backpressure, keepaliveCodec)
+
+	return k
+}
+
+// keepaliveCodec spills KeepaliveMessages in their wire format.
+var keepaliveCodec = spillCodec[*KeepaliveMessage]{
+	encode: func(msg *KeepaliveMessage) ([]byte, error) {
+		return msg.MarshalBinary()
+	},
+
+	decode: func(data []byte) (*KeepaliveMessage, error) {
+		msg := new(KeepaliveMessage)
+		err := msg.Decode(bufio.NewReaderSize(bytes.NewReader(data), len(data)))
+		return msg, err
+	},
+}
+
+// Dropped returns the number of messages dropped from the channel under the
+// BackpressurePolicy set by WithKeepaliveBackpressure.
+func (k *KeepaliveSubscriber) Dropped() uint64 {
+	return k.events.dropped.Load()
 }
 
 // Subscribe starts the KeepaliveSubscriber. It blocks until the context is cancelled,
-// the Sniffer is stopped, or an error occurs. If the Sniffer is not already running,
-// it will be started in a goroutine.
+// the Sniffer is stopped, or an error occurs. If the Sniffer is stopped, it will be
+// started in a goroutine. Once the KeepaliveSubscriber is closed, it returns
+// ErrSubscriberClosed.
 func (k *KeepaliveSubscriber) Subscribe(ctx context.Context, s *Sniffer) error {
-	if !s.IsActive() {
-		go s.Start(ctx)
+	if !k.subs.add() {
+		return ErrSubscriberClosed
 	}
+	defer k.subs.done()
+
+	s.startIfStopped(ctx)
+
+	k.events.attach(s)
 
 	return s.ProcessFrames(func(frame *Frame, header *GenericHeader, r *bufio.Reader) error {
 		if header.Segment != ServerPing && header.Segment != ServerPong {
@@ -32,18 +81,22 @@ func (k *KeepaliveSubscriber) Subscribe(ctx context.Context, s *Sniffer) error {
 
 		msg := new(KeepaliveMessage)
 		if err := msg.Decode(r); err != nil {
+			s.logDecodeFailure(frame, header, err)
 			return ErrDecodingFailure{Err: err}
 		}
 
-		k.Events <- msg
+		k.events.send(msg)
 		return nil
 	})
 }
 
-// Close will stop a sniffer, drain the channel, then close the channel.
+// Close will stop a sniffer, discard any spilled messages, then close the channel once
+// Subscribe has returned. Calling Close more than once is a no-op.
 func (k *KeepaliveSubscriber) Close(s *Sniffer) {
 	s.Stop()
-	close(k.Events)
+	k.events.shutdown()
+
+	k.subs.close(func() { close(k.Events) })
 }
 
 // KeepaliveCallback is a function called for each decoded KeepaliveMessage.
@@ -76,12 +129,10 @@ func NewKeepaliveHandler(fn KeepaliveCallback) *KeepaliveHandler {
 }
 
 // Subscribe starts the KeepaliveHandler. It blocks until the context is cancelled,
-// the Sniffer is stopped, or an error occurs. If the Sniffer is not already running,
-// it will be started in a goroutine.
+// the Sniffer is stopped, or an error occurs. If the Sniffer is stopped, it will be
+// started in a goroutine.
 func (k *KeepaliveHandler) Subscribe(ctx context.Context, s *Sniffer) error {
-	if !s.IsActive() {
-		go s.Start(ctx)
-	}
+	s.startIfStopped(ctx)
 
 	return s.ProcessFrames(func(frame *Frame, header *GenericHeader, r *bufio.Reader) error {
 		if header.Segment != ServerPing && header.Segment != ServerPong {
@@ -90,6 +141,7 @@ func (k *KeepaliveHandler) Subscribe(ctx context.Context, s *Sniffer) error {
 
 		k.msg.Reset()
 		if err := k.msg.Decode(r); err != nil {
+			s.logDecodeFailure(frame, header, err)
 			return ErrDecodingFailure{Err: err}
 		}
 
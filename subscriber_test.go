@@ -0,0 +1,74 @@
+package zanarkand
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/ayyaruq/zanarkand/devices"
+	"github.com/ayyaruq/zanarkand/opcodes"
+)
+
+func TestOpcodeNameFilter(t *testing.T) {
+	table := opcodes.NewTable("Global", "test")
+	table.Add(opcodes.Server, opcodes.Zone, opcodes.Entry{Name: "ActorCast", Opcode: 0x0232})
+	table.Add(opcodes.Server, opcodes.Chat, opcodes.Entry{Name: "ActorCast", Opcode: 0x0100})
+	table.Add(opcodes.Server, opcodes.Chat, opcodes.Entry{Name: "ChatMessage", Opcode: 0x0232})
+	table.Add(opcodes.Client, opcodes.Zone, opcodes.Entry{Name: "ChatHandler", Opcode: 0x0123})
+
+	cfg := newGameEventConfig(WithOpcodeTable(table), WithOpcodeNames("ActorCast", "ChatHandler", "Missing"), WithOpcodes(0x0999))
+	if cfg.err != nil {
+		t.Fatal(cfg.err)
+	}
+
+	zone, chat := uint16(opcodes.Zone), uint16(opcodes.Chat)
+
+	tests := []struct {
+		name       string
+		opcode     uint16
+		direction  FlowDirection
+		connection uint16
+		want       bool
+	}{
+		{"server name on zone", 0x0232, FrameIngress, zone, true},
+		{"server name on chat", 0x0100, FrameIngress, chat, true},
+		{"zone opcode on chat", 0x0232, FrameIngress, chat, false},
+		{"chat opcode on zone", 0x0100, FrameIngress, zone, false},
+		{"server name outbound", 0x0232, FrameEgress, zone, false},
+		{"client name outbound", 0x0123, FrameEgress, zone, true},
+		{"client name inbound", 0x0123, FrameIngress, zone, false},
+		{"client name on chat", 0x0123, FrameEgress, chat, false},
+		{"raw opcode", 0x0999, FrameIngress, chat, true},
+		{"unknown opcode", 0x0555, FrameIngress, zone, false},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.allows(tt.opcode, tt.direction, tt.connection); got != tt.want {
+			t.Errorf("%s: expected allows(%#04x) to be %v, got %v", tt.name, tt.opcode, tt.want, got)
+		}
+	}
+}
+
+func TestOpcodeNamesWithoutTable(t *testing.T) {
+	sniffer, err := NewSnifferFromHandle(devices.NewMemoryHandle(layers.LinkTypeEthernet, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sniffer.Close()
+
+	sub := NewGameEventSubscriber(WithOpcodeNames("ActorCast"))
+	if err := sub.Subscribe(context.Background(), sniffer); !errors.Is(err, ErrNoOpcodeTable) {
+		t.Errorf("Expected ErrNoOpcodeTable from GameEventSubscriber, got %v", err)
+	}
+
+	handler := NewGameEventHandler(func(*GameEventMessage, FlowDirection) {}, WithOpcodeNames("ActorCast"))
+	if err := handler.Subscribe(context.Background(), sniffer); !errors.Is(err, ErrNoOpcodeTable) {
+		t.Errorf("Expected ErrNoOpcodeTable from GameEventHandler, got %v", err)
+	}
+
+	if sniffer.Status() != SnifferStopped {
+		t.Errorf("Expected the Sniffer not to be started, got state %v", sniffer.Status())
+	}
+}